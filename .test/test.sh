@@ -42,7 +42,7 @@ bashbrew fetch infosiftr-moby
 # again but with cache
 ( BASHBREW_ARCH_NAMESPACES= "$dir/../sources.sh" --cache-file="$dir/sources-cache.json" infosiftr-moby > "$dir/sources-moby.json" )
 # technically, this *also* needs BASHBREW_STAGING_TEMPLATE='tianon/zz-staging:ARCH-BUILD', but that's a "builds.sh" flag and separating that would complicate including this even more, so Tianon has run the following one-liner to "inject" those builds as if they lived in 'oisupport/staging-ARCH:BUILD' instead:
-#   jq --raw-output '[ .[] | select(any(.source.arches[].tags[]; startswith("infosiftr-moby:"))) | "tianon/zz-staging:\(.build.arch)-\(.buildId)" as $tianon | @sh "../bin/lookup \($tianon) | jq --arg img \(.build.img) \("{ indexes: { ($img): . } }")" ] | "{ " + join(" && ") + @sh " && cat cache-builds.json; } | jq --slurp --tab \("reduce .[] as $i ({ indexes: { } }; .indexes += $i.indexes)") > cache-builds.json.new && mv cache-builds.json.new cache-builds.json"' builds.json | bash -Eeuo pipefail -x
+#   jq --raw-output '[ .[] | select(any(.source.arches[].tags[]; startswith("infosiftr-moby:"))) | "tianon/zz-staging:\(.build.arch)-\(.buildId)" as $tianon | @sh "../bin/lookup \($tianon) | jq --arg img \(.build.img) \("{ version: 1, indexes: { ($img): { lastUsed: \"1970-01-01T00:00:00Z\", index: . } } }")" ] | "{ " + join(" && ") + @sh " && cat cache-builds.json; } | jq --slurp --tab \("reduce .[] as $i ({ version: 1, indexes: { } }; .indexes += $i.indexes)") > cache-builds.json.new && mv cache-builds.json.new cache-builds.json"' builds.json | bash -Eeuo pipefail -x
 # (and then re-run the tests to canonicalize the file ordering)
 jq --tab --slurp 'add' "$dir/sources-doi.json" "$dir/sources-moby.json" > "$dir/sources.json"
 rm -f "$dir/sources-doi.json" "$dir/sources-moby.json" "$dir/sources-cache.json"
@@ -70,11 +70,14 @@ rm -rf "$coverage/GOCOVERDIR" "$coverage/bin"
 mkdir -p "$coverage/GOCOVERDIR" "$coverage/bin"
 export GOCOVERDIR="${GOCOVERDIR:-"$coverage/GOCOVERDIR"}"
 
+# (the "lastUsed" timestamps in the cache file come from SOURCE_DATE_EPOCH when it's set, which keeps "cache-builds.json" stable)
+export SOURCE_DATE_EPOCH=0
 time "$coverage/builds.sh" --cache "$dir/cache-builds.json" "$dir/sources.json" > "$dir/builds.json"
 [ -s "$coverage/bin/builds" ] # just to make sure it actually did build/use an appropriate binary 🙈
 
 # test again, but with "--cache=..." instead of "--cache ..." (which also lets us delete the cache and get slightly better coverage reports at the expense of speed / Hub requests)
 time "$coverage/builds.sh" --cache="$dir/cache-builds.json" "$dir/sources.json" > "$dir/builds.json"
+unset SOURCE_DATE_EPOCH
 
 # test "lookup" code for more edge cases
 "$dir/../.go-env.sh" go build -coverpkg=./... -trimpath -o "$coverage/bin/lookup" ./cmd/lookup
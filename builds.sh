@@ -4,6 +4,7 @@ set -Eeuo pipefail
 # TODO drop this from the defaults and set it explicitly in DOI instead (to prevent accidents)
 : "${BASHBREW_STAGING_TEMPLATE:=oisupport/staging-ARCH:BUILD}"
 export BASHBREW_STAGING_TEMPLATE
+# (BASHBREW_STAGING_CONFIG can also be set to a JSON object, or the path to a file containing one, with per-architecture overrides; see "stagingConfig" in cmd/builds/staging.go)
 
 # put the binary in the directory of a symlink of "builds.sh" (used for testing coverage; see GOCOVERDIR below)
 dir="$(dirname "$BASH_SOURCE")"
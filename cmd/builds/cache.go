@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker-library/meta-scripts/registry"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// the current version of the "--cache" file format (files without a version are the original format, where "indexes" values were the index objects directly, and are upgraded transparently)
+const cacheFileVersion = 1
+
+type cacheFileContents struct {
+	Version int                                `json:"version"`
+	Indexes map[registry.Reference]*cacheEntry `json:"indexes"`
+}
+
+type cacheEntry struct {
+	// the last time a run of this tool needed this entry (see "--cache-max-age")
+	LastUsed time.Time      `json:"lastUsed"`
+	Index    *ocispec.Index `json:"index"`
+}
+
+var (
+	saveCache      *cacheFileContents                 // only the entries used by this run (plus anything from "loadedCache" that's still young enough when we save)
+	loadedCache    map[registry.Reference]*cacheEntry // everything from the cache file
+	saveCacheMutex sync.Mutex
+
+	// how long to keep entries that weren't used by this run (zero means only keep what this run used)
+	cacheMaxAge time.Duration
+)
+
+// "now" for "lastUsed" purposes (honoring https://reproducible-builds.org/specs/source-date-epoch/ so the cache file can be reproducible for tests)
+func cacheNow() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// records that this run used the given index (so it gets written to the cache file)
+func cacheIndex(ref registry.Reference, index *ocispec.Index) {
+	saveCacheMutex.Lock()
+	defer saveCacheMutex.Unlock()
+	if saveCache != nil {
+		saveCache.Indexes[ref] = &cacheEntry{LastUsed: cacheNow(), Index: index}
+	}
+}
+
+// if the given image was in the cache file, records that this run used it (for images we didn't actually need to look up, like the staging images of builds reused via "--previous")
+func touchCache(img string) {
+	ref, err := normalizeRef(img)
+	if err != nil {
+		return
+	}
+	saveCacheMutex.Lock()
+	entry, ok := loadedCache[ref]
+	saveCacheMutex.Unlock()
+	if ok {
+		cacheIndex(ref, entry.Index)
+	}
+}
+
+func loadCacheFromFile() error {
+	if cacheFile == "" {
+		return nil
+	}
+
+	// now that we know we have a file we want cache to go into (and come from), let's initialize the "saveCache" (which will be written when the whole process is done / we're successful, and *only* caches staging images)
+	saveCacheMutex.Lock()
+	saveCache = &cacheFileContents{Version: cacheFileVersion, Indexes: map[registry.Reference]*cacheEntry{}}
+	saveCacheMutex.Unlock()
+
+	f, err := os.Open(cacheFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var cache struct {
+		Version int                                    `json:"version"`
+		Indexes map[registry.Reference]json.RawMessage `json:"indexes"`
+	}
+	dec := json.NewDecoder(f)
+	if err := dec.Decode(&cache); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the cache object (corrupted?)")
+	}
+	if cache.Version > cacheFileVersion {
+		return fmt.Errorf("unsupported cache file version %d (expected %d or older)", cache.Version, cacheFileVersion)
+	}
+
+	entries := map[registry.Reference]*cacheEntry{}
+	for ref, raw := range cache.Indexes {
+		entry := &cacheEntry{}
+		if cache.Version == 0 {
+			// the original (unversioned) format, which didn't track usage (so we'll pretend everything was just used, which gives it the full "--cache-max-age" to prove itself)
+			entry.LastUsed = cacheNow()
+			err = json.Unmarshal(raw, &entry.Index)
+		} else {
+			err = json.Unmarshal(raw, entry)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+		if entry.Index == nil {
+			return fmt.Errorf("%s: missing index", ref)
+		}
+		entries[ref] = entry
+	}
+
+	saveCacheMutex.Lock()
+	loadedCache = entries
+	saveCacheMutex.Unlock()
+
+	for img, entry := range entries {
+		index := entry.Index
+		fun, _ := cacheResolve.LoadOrStore(img.String(), sync.OnceValues(func() (*ocispec.Index, error) {
+			return index, nil
+		}))
+		index2, err := fun.(func() (*ocispec.Index, error))()
+		if err != nil {
+			// this should never happen (hence panic vs return) 🙈
+			panic(err)
+		}
+		if index2 != index {
+			panic("index2 != index??? " + img.String())
+		}
+	}
+
+	return nil
+}
+
+// re-checks (via HEAD requests) a random sample of "n" entries from the cache file against their registries, and drops any that are no longer accurate (so they get looked up again); returns how many were dropped
+func verifyCache(ctx context.Context, n int) (int, error) {
+	saveCacheMutex.Lock()
+	refs := make([]registry.Reference, 0, len(loadedCache))
+	for ref := range loadedCache {
+		refs = append(refs, ref)
+	}
+	saveCacheMutex.Unlock()
+	rand.Shuffle(len(refs), func(i, j int) { refs[i], refs[j] = refs[j], refs[i] })
+	if n < len(refs) {
+		refs = refs[:n]
+	}
+
+	stale := 0
+	for _, ref := range refs {
+		saveCacheMutex.Lock()
+		entry := loadedCache[ref]
+		saveCacheMutex.Unlock()
+
+		ok, err := verifyCacheEntry(ctx, ref, entry.Index)
+		if err != nil {
+			return stale, err
+		}
+		if ok {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "NOTE: cache entry %s is stale; dropping it\n", ref)
+		stale++
+		saveCacheMutex.Lock()
+		delete(loadedCache, ref)
+		saveCacheMutex.Unlock()
+		cacheResolve.Delete(ref.String())
+	}
+	return stale, nil
+}
+
+// whether the given cached index still matches what the registry has
+func verifyCacheEntry(ctx context.Context, ref registry.Reference, index *ocispec.Index) (bool, error) {
+	// the index we cached records the digest it was synthesized from (see [registry.SynthesizeIndex])
+	want := ref.Digest
+	if refName, err := registry.ParseRef(index.Annotations[ocispec.AnnotationRefName]); err == nil && refName.Digest != "" {
+		want = refName.Digest
+	}
+
+	r, err := retry(ctx, ref.String(), func() (ociregistry.BlobReader, error) {
+		return registry.Lookup(ctx, ref, &registry.LookupOptions{Head: true})
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: failed HEAD: %w", ref, err)
+	}
+	if r == nil {
+		// 404
+		return false, nil
+	}
+	defer r.Close()
+	return want == "" || r.Descriptor().Digest == want, nil
+}
+
+// writes the cache file (atomically, so a crash or full disk can't leave behind a truncated cache), pruning anything this run didn't use (unless it was used recently enough; see [cacheMaxAge])
+func saveCacheToFile() error {
+	saveCacheMutex.Lock()
+	defer saveCacheMutex.Unlock()
+
+	if saveCache == nil || cacheFile == "" {
+		return nil
+	}
+
+	pruned := 0
+	now := cacheNow()
+	for ref, entry := range loadedCache {
+		if _, ok := saveCache.Indexes[ref]; ok {
+			continue
+		}
+		if cacheMaxAge > 0 && now.Sub(entry.LastUsed) <= cacheMaxAge {
+			saveCache.Indexes[ref] = entry
+			continue
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		fmt.Fprintf(os.Stderr, "NOTE: pruned %d unused cache entries\n", pruned)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(cacheFile), "."+filepath.Base(cacheFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // (a no-op once it's successfully renamed)
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+
+	if err := enc.Encode(saveCache); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(cacheFile); err == nil {
+		// (CreateTemp always uses 0600, so preserve any existing permissions)
+		if err := os.Chmod(f.Name(), fi.Mode().Perm()); err != nil {
+			return err
+		}
+	} else if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), cacheFile)
+}
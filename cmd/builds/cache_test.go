@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker-library/meta-scripts/registry"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCacheFile(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	now := time.Unix(1700000000, 0).UTC()
+
+	// (all the cache state is global, so make sure every subtest starts fresh)
+	reset := func(t *testing.T, contents string) {
+		t.Helper()
+		cacheFile = filepath.Join(t.TempDir(), "cache.json")
+		if contents != "" {
+			if err := os.WriteFile(cacheFile, []byte(contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cacheResolve = sync.Map{}
+		saveCache = nil
+		loadedCache = nil
+		cacheMaxAge = 0
+	}
+	t.Cleanup(func() {
+		cacheFile = ""
+		cacheResolve = sync.Map{}
+		saveCache = nil
+		loadedCache = nil
+		cacheMaxAge = 0
+	})
+	saved := func(t *testing.T) cacheFileContents {
+		t.Helper()
+		b, err := os.ReadFile(cacheFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cache cacheFileContents
+		if err := json.Unmarshal(b, &cache); err != nil {
+			t.Fatal(err)
+		}
+		return cache
+	}
+	ref := func(t *testing.T, s string) registry.Reference {
+		t.Helper()
+		r, err := registry.ParseRef(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	t.Run("legacy", func(t *testing.T) {
+		reset(t, `{"indexes":{"alpine@sha256:34871e7290500828b39e22294660bee86d966bc0017544e848dd9a255cdf59e0":{"schemaVersion":2,"manifests":[]}}}`)
+		if err := loadCacheFromFile(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := cacheResolve.Load("alpine@sha256:34871e7290500828b39e22294660bee86d966bc0017544e848dd9a255cdf59e0"); !ok {
+			t.Fatal("expected legacy entry to be loaded")
+		}
+		cacheMaxAge = time.Hour // (so the unused legacy entry survives)
+		if err := saveCacheToFile(); err != nil {
+			t.Fatal(err)
+		}
+		cache := saved(t)
+		if cache.Version != cacheFileVersion || len(cache.Indexes) != 1 {
+			t.Fatalf("unexpected cache: %+v", cache)
+		}
+		for _, entry := range cache.Indexes {
+			if !entry.LastUsed.Equal(now) || entry.Index == nil || entry.Index.SchemaVersion != 2 {
+				t.Fatalf("unexpected entry: %+v", entry)
+			}
+		}
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		reset(t, `{
+			"version": 1,
+			"indexes": {
+				"example.com/old:1": { "lastUsed": "2023-01-01T00:00:00Z", "index": { "schemaVersion": 2, "manifests": [] } },
+				"example.com/recent:1": { "lastUsed": "2023-11-10T00:00:00Z", "index": { "schemaVersion": 2, "manifests": [] } },
+				"example.com/used:1": { "lastUsed": "2023-01-01T00:00:00Z", "index": { "schemaVersion": 2, "manifests": [] } }
+			}
+		}`)
+		if err := loadCacheFromFile(); err != nil {
+			t.Fatal(err)
+		}
+		touchCache("example.com/used:1")
+		cacheIndex(ref(t, "example.com/new:1"), &ocispec.Index{})
+		cacheMaxAge = 30 * 24 * time.Hour
+		if err := saveCacheToFile(); err != nil {
+			t.Fatal(err)
+		}
+		cache := saved(t)
+		if _, ok := cache.Indexes[ref(t, "example.com/old:1")]; ok {
+			t.Error("expected old entry to be pruned")
+		}
+		if entry, ok := cache.Indexes[ref(t, "example.com/recent:1")]; !ok || entry.LastUsed.Equal(now) {
+			t.Errorf("expected recent entry to be kept as-is: %+v", entry)
+		}
+		for _, r := range []string{"example.com/used:1", "example.com/new:1"} {
+			if entry, ok := cache.Indexes[ref(t, r)]; !ok || !entry.LastUsed.Equal(now) {
+				t.Errorf("expected %s to be used now: %+v", r, entry)
+			}
+		}
+
+		// without a max age, only what this run used survives
+		cacheMaxAge = 0
+		delete(saveCache.Indexes, ref(t, "example.com/recent:1"))
+		if err := saveCacheToFile(); err != nil {
+			t.Fatal(err)
+		}
+		if cache := saved(t); len(cache.Indexes) != 2 {
+			t.Errorf("expected only used entries, got %+v", cache.Indexes)
+		}
+
+		// (the temporary file should never be left behind)
+		if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(cacheFile), ".cache.json.*")); len(matches) != 0 {
+			t.Errorf("unexpected leftover files: %v", matches)
+		}
+	})
+
+	for _, x := range []struct {
+		name     string
+		contents string
+		wantErr  string
+	}{
+		{"future version", `{"version":99,"indexes":{}}`, "unsupported cache file version 99"},
+		{"garbage", `{"version":1,"indexes":{}} garbage`, "unexpected data after the cache object"},
+		{"missing index", `{"version":1,"indexes":{"example.com/foo:1":{"lastUsed":"2023-01-01T00:00:00Z"}}}`, "missing index"},
+	} {
+		x := x // https://github.com/golang/go/issues/60078
+		t.Run(x.name, func(t *testing.T) {
+			reset(t, x.contents)
+			if err := loadCacheFromFile(); err == nil || !strings.Contains(err.Error(), x.wantErr) {
+				t.Fatalf("expected error %q, got %v", x.wantErr, err)
+			}
+		})
+	}
+}
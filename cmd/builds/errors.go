@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"cuelabs.dev/go/oci/ociregistry"
+)
+
+// a failure to resolve a single source/architecture combination
+type buildError struct {
+	SourceID string
+	Arch     string
+	Err      error
+}
+
+func (e buildError) Error() string {
+	return fmt.Sprintf("%s [%s]: %v", e.SourceID, e.Arch, e.Err)
+}
+
+func (e buildError) Unwrap() error {
+	return e.Err
+}
+
+// returned for sources whose parent failed (so the summary can tell the root causes apart from everything that failed because of them)
+var errParentFailed = errors.New("parent failed")
+
+// whether the given error is likely to go away if we just try again (network hiccups, server errors that outlasted the retries [registry.Client] already does at the HTTP level, etc)
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var httpErr ociregistry.HTTPError
+	if errors.As(err, &httpErr) {
+		code := httpErr.StatusCode()
+		return code == 429 || code >= 500
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		// a host that doesn't exist isn't going to start existing in the next few seconds
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+var (
+	retryAttempts = 4
+	retryDelay    = 2 * time.Second // doubled after every attempt
+)
+
+// calls "f" until it succeeds, returns a non-transient error (see [isTransient]), or we run out of attempts ("what" is only used for logging)
+func retry[T any](ctx context.Context, what string, f func() (T, error)) (T, error) {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		ret, err := f()
+		if attempt >= retryAttempts || !isTransient(err) {
+			return ret, err
+		}
+		fmt.Fprintf(os.Stderr, "NOTE: retrying %s in %s (attempt %d/%d): %v\n", what, delay, attempt+1, retryAttempts, err)
+		select {
+		case <-ctx.Done():
+			return ret, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
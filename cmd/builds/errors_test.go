@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"cuelabs.dev/go/oci/ociregistry"
+)
+
+func TestIsTransient(t *testing.T) {
+	for _, x := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("nope"), false},
+		{context.Canceled, false},
+		{fmt.Errorf("wrapped: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection timed out")}, true},
+		{&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, false},
+		{ociregistry.NewHTTPError(ociregistry.ErrManifestUnknown, 404, nil, nil), false},
+		{ociregistry.NewHTTPError(errors.New("bad gateway"), 502, nil, nil), true},
+		{buildError{SourceID: "foo", Arch: "amd64", Err: io.ErrUnexpectedEOF}, true},
+	} {
+		if got := isTransient(x.err); got != x.want {
+			t.Errorf("isTransient(%v): expected %v, got %v", x.err, x.want, got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	retryDelay = 0
+
+	calls := 0
+	ret, err := retry(context.Background(), "test", func() (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return calls, nil
+	})
+	if err != nil || ret != 3 {
+		t.Fatalf("expected success on third attempt, got %d (%v)", ret, err)
+	}
+
+	calls = 0
+	_, err = retry(context.Background(), "test", func() (int, error) {
+		calls++
+		return 0, io.ErrUnexpectedEOF
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || calls != retryAttempts {
+		t.Fatalf("expected %d attempts, got %d (%v)", retryAttempts, calls, err)
+	}
+
+	calls = 0
+	_, err = retry(context.Background(), "test", func() (int, error) {
+		calls++
+		return 0, errors.New("permanent")
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("expected exactly one attempt for a permanent error, got %d (%v)", calls, err)
+	}
+}
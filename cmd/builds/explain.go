@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/docker-library/meta-scripts/om"
+)
+
+// the exact bytes that get hashed to create a buildId
+func buildIDPreimage(parts BuildIDParts) ([]byte, error) {
+	b, err := json.Marshal(&parts)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, byte('\n')) // previous calculation of buildId included a newline in the JSON, so this preserves compatibility
+	// TODO if we ever have a bigger "buildId break" event (like adding major base images that force the whole tree to rebuild), we should probably ditch this newline
+	return b, nil
+}
+
+func loadBuildsJSON(file string) (om.OrderedMap[MetaBuild], error) {
+	var builds om.OrderedMap[MetaBuild]
+	f, err := os.Open(file)
+	if err != nil {
+		return builds, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&builds); err != nil {
+		return builds, fmt.Errorf("%s: %w", file, err)
+	}
+	return builds, nil
+}
+
+// the (single architecture) source object of a build
+func (b MetaBuild) source() (MetaSource, error) {
+	var source MetaSource
+	err := json.Unmarshal(b.Source, &source)
+	return source, err
+}
+
+func (b MetaBuild) tags() []string {
+	source, err := b.source()
+	if err != nil {
+		return nil
+	}
+	return source.Arches[b.Build.Arch].Tags
+}
+
+// for humans
+func (b MetaBuild) String() string {
+	tag := b.Build.SourceID
+	if tags := b.tags(); len(tags) > 0 {
+		tag = tags[0]
+	}
+	return fmt.Sprintf("%s (%s) [%s]", b.BuildID, tag, b.Build.Arch)
+}
+
+// finds builds by buildId, or (if there isn't one) by tag (which usually matches several architectures)
+func findBuilds(builds om.OrderedMap[MetaBuild], query string) []MetaBuild {
+	if builds.Has(query) {
+		return []MetaBuild{builds.Get(query)}
+	}
+	var ret []MetaBuild
+	for _, buildID := range builds.Keys() {
+		if build := builds.Get(buildID); slices.Contains(build.tags(), query) {
+			ret = append(ret, build)
+		}
+	}
+	return ret
+}
+
+// finds the build in "previous" that is the "same" as the given build (same sourceId and arch, or if the source itself changed, the same arch and any of the same tags)
+func findPrevious(previous om.OrderedMap[MetaBuild], build MetaBuild) (MetaBuild, bool) {
+	tags := build.tags()
+	var byTag *MetaBuild
+	for _, buildID := range previous.Keys() {
+		prev := previous.Get(buildID)
+		if prev.Build.Arch != build.Build.Arch {
+			continue
+		}
+		if prev.Build.SourceID == build.Build.SourceID {
+			return prev, true
+		}
+		if byTag == nil && slices.ContainsFunc(prev.tags(), func(tag string) bool { return slices.Contains(tags, tag) }) {
+			byTag = &prev
+		}
+	}
+	if byTag != nil {
+		return *byTag, true
+	}
+	return MetaBuild{}, false
+}
+
+// writes the exact buildId pre-image of every build matching "query" (a buildId or tag) to "out", and a human explanation of each (and, given "previous", of why it changed) to "w"
+func explain(out, w io.Writer, query string, builds, previous om.OrderedMap[MetaBuild]) error {
+	matches := findBuilds(builds, query)
+	if len(matches) == 0 {
+		return fmt.Errorf("no build with buildId or tag %q", query)
+	}
+	for _, build := range matches {
+		preimage, err := buildIDPreimage(build.Build.BuildIDParts)
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(preimage); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "%s\n", build)
+		if sum := fmt.Sprintf("%x", sha256.Sum256(preimage)); sum != build.BuildID {
+			fmt.Fprintf(w, "  WARNING: sha256 of the pre-image is %s, not the buildId (was it calculated differently?)\n", sum)
+		}
+		if len(previous.Keys()) > 0 {
+			explainChange(w, builds, previous, build, "  ", map[string]bool{})
+		}
+	}
+	return nil
+}
+
+// writes which inputs of "build" changed compared to its equivalent in "previous", following changed parents that are themselves in "builds" (recursively) so the actual root cause is visible
+func explainChange(w io.Writer, builds, previous om.OrderedMap[MetaBuild], build MetaBuild, indent string, seen map[string]bool) {
+	prev, ok := findPrevious(previous, build)
+	if !ok {
+		fmt.Fprintf(w, "%snew (no previous build of this source or its tags)\n", indent)
+		return
+	}
+	if prev.BuildID == build.BuildID {
+		if digest, prevDigest := build.resolvedDigest(), prev.resolvedDigest(); digest != prevDigest {
+			// (for parents, this is usually what changed: the same inputs were simply built again)
+			fmt.Fprintf(w, "%sunchanged buildId, but rebuilt: %s -> %s\n", indent, prevDigest, digest)
+		} else {
+			fmt.Fprintf(w, "%sunchanged\n", indent)
+		}
+		return
+	}
+	if seen[build.BuildID] {
+		// (explained already, somewhere above)
+		fmt.Fprintf(w, "%s(see above)\n", indent)
+		return
+	}
+	seen[build.BuildID] = true
+
+	fmt.Fprintf(w, "%sprevious: %s\n", indent, prev.BuildID)
+	explained := false
+	if prev.Build.SourceID != build.Build.SourceID {
+		fmt.Fprintf(w, "%schanged: sourceId %s -> %s\n", indent, prev.Build.SourceID, build.Build.SourceID)
+		explained = true
+	}
+
+	source, _ := build.source()
+	parents := build.Build.Parents
+	prevParents := prev.Build.Parents
+	for _, from := range prevParents.Keys() {
+		if !parents.Has(from) {
+			fmt.Fprintf(w, "%sremoved: parent %s (%s)\n", indent, from, prevParents.Get(from))
+			explained = true
+		}
+	}
+	for _, from := range parents.Keys() {
+		digest := parents.Get(from)
+		if !prevParents.Has(from) {
+			fmt.Fprintf(w, "%sadded: parent %s (%s)\n", indent, from, digest)
+			explained = true
+			continue
+		}
+		prevDigest := prevParents.Get(from)
+		if digest == prevDigest {
+			continue
+		}
+		fmt.Fprintf(w, "%schanged: parent %s %s -> %s\n", indent, from, prevDigest, digest)
+		explained = true
+
+		parentSourceID := source.Arches[build.Build.Arch].Parents.Get(from).SourceID
+		if parentSourceID == nil {
+			fmt.Fprintf(w, "%s  (not built from sources.json)\n", indent)
+			continue
+		}
+		parent, err := findSourceBuild(builds, *parentSourceID, build.Build.Arch)
+		if err != nil {
+			fmt.Fprintf(w, "%s  %v\n", indent, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s  %s\n", indent, parent)
+		explainChange(w, builds, previous, parent, indent+"    ", seen)
+	}
+	if !explained {
+		// the same sourceId, arch, and parents, so the difference must be in the order of the parents or how the pre-image was encoded
+		fmt.Fprintf(w, "%schanged: parent order or pre-image encoding\n", indent)
+	}
+}
+
+// the digest of the (staging) image of a build, or "none" if it hasn't been built yet
+func (b MetaBuild) resolvedDigest() string {
+	if b.Build.Resolved == nil || len(b.Build.Resolved.Manifests) == 0 {
+		return "none"
+	}
+	return string(b.Build.Resolved.Manifests[0].Digest)
+}
+
+func findSourceBuild(builds om.OrderedMap[MetaBuild], sourceID, arch string) (MetaBuild, error) {
+	for _, buildID := range builds.Keys() {
+		if build := builds.Get(buildID); build.Build.SourceID == sourceID && build.Build.Arch == arch {
+			return build, nil
+		}
+	}
+	return MetaBuild{}, errors.New("parent " + sourceID + " [" + arch + "] is not in builds.json")
+}
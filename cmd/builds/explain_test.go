@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/docker-library/meta-scripts/om"
+
+	godigest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func testIndex(digest string) *ocispec.Index {
+	return &ocispec.Index{Manifests: []ocispec.Descriptor{{Digest: godigest.Digest(digest)}}}
+}
+
+func TestExplain(t *testing.T) {
+	// builds a (minimal) builds.json entry, with a real buildId
+	newBuild := func(t *testing.T, sourceID, arch, tag, digest string, parents ...string) MetaBuild {
+		t.Helper()
+		var b MetaBuild
+		b.Build.SourceID = sourceID
+		b.Build.Arch = arch
+		sourceParents := map[string]any{}
+		for i := 0; i+2 < len(parents); i += 3 { // from, digest, sourceId (or "")
+			from, parentDigest, parentSourceID := parents[i], parents[i+1], parents[i+2]
+			b.Build.Parents.Set(from, parentDigest)
+			if parentSourceID != "" {
+				sourceParents[from] = map[string]any{"sourceId": parentSourceID}
+			} else {
+				sourceParents[from] = map[string]any{"sourceId": nil}
+			}
+		}
+		preimage, err := buildIDPreimage(b.Build.BuildIDParts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.BuildID = fmt.Sprintf("%x", sha256.Sum256(preimage))
+		if digest != "" {
+			b.Build.Resolved = testIndex(digest)
+		}
+		b.Source, err = json.Marshal(map[string]any{
+			"sourceId": sourceID,
+			"arches": map[string]any{
+				arch: map[string]any{"tags": []string{tag}, "parents": sourceParents},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	buildsJSON := func(builds ...MetaBuild) om.OrderedMap[MetaBuild] {
+		var ret om.OrderedMap[MetaBuild]
+		for _, b := range builds {
+			ret.Set(b.BuildID, b)
+		}
+		return ret
+	}
+
+	base := newBuild(t, "base", "amd64", "base:1", "sha256:base2", "debian:bookworm", "sha256:debian2", "")
+	child := newBuild(t, "child", "amd64", "child:1", "", "base:1", "sha256:base2", "base")
+	other := newBuild(t, "other", "amd64", "other:1", "sha256:other")
+	builds := buildsJSON(base, child, other)
+
+	prevBase := newBuild(t, "base", "amd64", "base:1", "sha256:base1", "debian:bookworm", "sha256:debian1", "")
+	prevChild := newBuild(t, "child", "amd64", "child:1", "sha256:child1", "base:1", "sha256:base1", "base")
+	previous := buildsJSON(prevBase, prevChild, other)
+
+	var out, w strings.Builder
+	if err := explain(&out, &w, "child:1", builds, previous); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), `{"sourceId":"child","arch":"amd64","parents":{"base:1":"sha256:base2"}}`+"\n"; got != want {
+		t.Errorf("expected pre-image %q, got %q", want, got)
+	}
+	for _, want := range []string{
+		child.BuildID + " (child:1) [amd64]\n",
+		"  previous: " + prevChild.BuildID + "\n",
+		"  changed: parent base:1 sha256:base1 -> sha256:base2\n",
+		"    " + base.BuildID + " (base:1) [amd64]\n",
+		"      changed: parent debian:bookworm sha256:debian1 -> sha256:debian2\n",
+		"        (not built from sources.json)\n",
+	} {
+		if !strings.Contains(w.String(), want) {
+			t.Errorf("expected explanation to contain %q:\n%s", want, w.String())
+		}
+	}
+	if strings.Contains(w.String(), "WARNING") {
+		t.Errorf("unexpected warning:\n%s", w.String())
+	}
+
+	t.Run("by buildId", func(t *testing.T) {
+		var out, w strings.Builder
+		if err := explain(&out, &w, other.BuildID, builds, previous); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%x", sha256.Sum256([]byte(out.String()))); got != other.BuildID {
+			t.Errorf("expected pre-image to hash to %s, got %s", other.BuildID, got)
+		}
+		if !strings.HasSuffix(w.String(), "  unchanged\n") {
+			t.Errorf("expected unchanged:\n%s", w.String())
+		}
+	})
+
+	t.Run("rebuilt parent", func(t *testing.T) {
+		// same buildId for the parent, but a different image
+		rebuiltBase := base
+		rebuiltBase.Build.Resolved = testIndex("sha256:base3")
+		var w strings.Builder
+		explainChange(&w, buildsJSON(rebuiltBase), buildsJSON(base), rebuiltBase, "", map[string]bool{})
+		if got, want := w.String(), "unchanged buildId, but rebuilt: sha256:base2 -> sha256:base3\n"; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if err := explain(&out, &w, "nope:1", builds, previous); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/docker-library/meta-scripts/om"
+)
+
+// the graph of every source/architecture combination and which others they need resolved first (their parents that are also built from "sources.json"), built up front so that "sources.json" ordering doesn't matter and problems like cycles are reported instead of hanging
+type sourceGraph struct {
+	Nodes om.OrderedMap[*sourceGraphNode] // in topological order (parents before children) after [sourceGraph.sort]; see [sourceGraphKey]
+}
+
+type sourceGraphNode struct {
+	SourceID string `json:"sourceId"`
+	Arch     string `json:"arch"`
+	Tag      string `json:"tag"` // (just the first one, for humans)
+
+	// parent image name (from "FROM") => node key of the source that builds it (only for parents that are built from "sources.json")
+	Parents om.OrderedMap[string] `json:"parents"`
+
+	// non-nil if this node can never be resolved (a parent that doesn't exist, or a dependency cycle)
+	Err error `json:"-"`
+}
+
+func (n sourceGraphNode) MarshalJSON() ([]byte, error) {
+	type plain sourceGraphNode // (avoid infinite recursion)
+	var errString string
+	if n.Err != nil {
+		errString = n.Err.Error()
+	}
+	return json.Marshal(struct {
+		plain
+		Error string `json:"error,omitempty"`
+	}{plain(n), errString})
+}
+
+// for humans (used in error messages)
+func (n sourceGraphNode) String() string {
+	return n.Tag + " [" + n.Arch + "]"
+}
+
+func sourceGraphKey(sourceID, arch string) string {
+	return sourceID + "-" + arch
+}
+
+// adds a node for the given source/architecture (the architecture must be one of the keys of "source.Arches")
+func (g *sourceGraph) add(source MetaSource, arch string) {
+	node := &sourceGraphNode{
+		SourceID: source.SourceID,
+		Arch:     arch,
+	}
+	if tags := source.Arches[arch].Tags; len(tags) > 0 {
+		node.Tag = tags[0]
+	}
+	parents := source.Arches[arch].Parents
+	for _, from := range parents.Keys() {
+		if parent := parents.Get(from); parent.SourceID != nil {
+			node.Parents.Set(from, sourceGraphKey(*parent.SourceID, arch))
+		}
+	}
+	g.Nodes.Set(sourceGraphKey(source.SourceID, arch), node)
+}
+
+// marks any nodes with missing parents or that are part of a dependency cycle (see [sourceGraphNode.Err]) and re-orders [sourceGraph.Nodes] topologically (parents before children, but otherwise in the original order); returns all the problems it found
+func (g *sourceGraph) sort() []error {
+	var (
+		problems []error
+		sorted   om.OrderedMap[*sourceGraphNode]
+		visiting = map[string]bool{}
+		stack    []string
+	)
+	var visit func(key string)
+	visit = func(key string) {
+		if sorted.Has(key) {
+			return
+		}
+		node := g.Nodes.Get(key)
+		if visiting[key] {
+			// we've come back around to a node we're still in the middle of, so everything on the stack from there to here is a cycle
+			cycle := stack[slices.Index(stack, key):]
+			names := make([]string, 0, len(cycle)+1)
+			for _, k := range cycle {
+				names = append(names, g.Nodes.Get(k).String())
+			}
+			names = append(names, node.String())
+			err := fmt.Errorf("dependency cycle: %s", strings.Join(names, " -> "))
+			problems = append(problems, err)
+			for _, k := range cycle {
+				if n := g.Nodes.Get(k); n.Err == nil {
+					n.Err = err
+				}
+			}
+			return
+		}
+		visiting[key] = true
+		stack = append(stack, key)
+		for _, from := range node.Parents.Keys() {
+			parentKey := node.Parents.Get(from)
+			if !g.Nodes.Has(parentKey) {
+				if node.Err == nil {
+					node.Err = fmt.Errorf("parent %s should be %s, but that sourceId is unknown to us", from, strings.TrimSuffix(parentKey, "-"+node.Arch))
+					problems = append(problems, fmt.Errorf("%s: %w", node, node.Err))
+				}
+				continue
+			}
+			visit(parentKey)
+		}
+		stack = stack[:len(stack)-1]
+		delete(visiting, key)
+		sorted.Set(key, node)
+	}
+	for _, key := range g.Nodes.Keys() {
+		visit(key)
+	}
+	g.Nodes = sorted
+	return problems
+}
+
+// writes the graph as JSON (the nodes, in topological order)
+func (g *sourceGraph) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(g.Nodes)
+}
+
+// writes the graph in Graphviz "dot" format (edges point from parents to children, and nodes with problems are red)
+func (g *sourceGraph) writeDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph builds {\n")
+	for _, key := range g.Nodes.Keys() {
+		node := g.Nodes.Get(key)
+		attrs := fmt.Sprintf("label=%q", node.Tag+"\n["+node.Arch+"]")
+		if node.Err != nil {
+			attrs += fmt.Sprintf(", color=red, tooltip=%q", node.Err.Error())
+		}
+		fmt.Fprintf(&b, "\t%q [%s];\n", key, attrs)
+	}
+	for _, key := range g.Nodes.Keys() {
+		node := g.Nodes.Get(key)
+		for _, from := range node.Parents.Keys() {
+			parentKey := node.Parents.Get(from)
+			if !g.Nodes.Has(parentKey) {
+				// (so missing parents are still visible)
+				fmt.Fprintf(&b, "\t%q [label=%q, color=red, style=dashed];\n", parentKey, from+"\n(unknown sourceId)")
+			}
+			fmt.Fprintf(&b, "\t%q -> %q [label=%q];\n", parentKey, key, from)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSourceGraph(t *testing.T) {
+	newGraph := func(t *testing.T, sources ...string) *sourceGraph {
+		t.Helper()
+		var g sourceGraph
+		for _, s := range sources {
+			var source MetaSource
+			if err := json.Unmarshal([]byte(s), &source); err != nil {
+				t.Fatal(err)
+			}
+			for arch := range source.Arches {
+				g.add(source, arch)
+			}
+		}
+		return &g
+	}
+
+	t.Run("order", func(t *testing.T) {
+		// children listed before their parents (which is fine now)
+		g := newGraph(t,
+			`{"sourceId":"c","arches":{"amd64":{"tags":["c:1"],"parents":{"b:1":{"sourceId":"b"},"debian:bookworm":{"sourceId":null}}}}}`,
+			`{"sourceId":"b","arches":{"amd64":{"tags":["b:1"],"parents":{"a:1":{"sourceId":"a"}}}}}`,
+			`{"sourceId":"a","arches":{"amd64":{"tags":["a:1"],"parents":{"scratch":{}}}}}`,
+			`{"sourceId":"d","arches":{"amd64":{"tags":["d:1"],"parents":{}}}}`,
+		)
+		if problems := g.sort(); len(problems) != 0 {
+			t.Fatalf("unexpected problems: %v", problems)
+		}
+		if got, want := g.Nodes.Keys(), []string{"a-amd64", "b-amd64", "c-amd64", "d-amd64"}; !slices.Equal(got, want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+		if got := g.Nodes.Get("c-amd64").Parents.Keys(); !slices.Equal(got, []string{"b:1"}) {
+			t.Fatalf("expected only parents from sources.json, got %v", got)
+		}
+	})
+
+	t.Run("problems", func(t *testing.T) {
+		g := newGraph(t,
+			`{"sourceId":"a","arches":{"amd64":{"tags":["a:1"],"parents":{"c:1":{"sourceId":"c"}}}}}`,
+			`{"sourceId":"b","arches":{"amd64":{"tags":["b:1"],"parents":{"a:1":{"sourceId":"a"}}}}}`,
+			`{"sourceId":"c","arches":{"amd64":{"tags":["c:1"],"parents":{"b:1":{"sourceId":"b"}}}}}`,
+			`{"sourceId":"d","arches":{"amd64":{"tags":["d:1"],"parents":{"c:1":{"sourceId":"c"}}}}}`,
+			`{"sourceId":"e","arches":{"amd64":{"tags":["e:1"],"parents":{"nope:1":{"sourceId":"nope"}}}}}`,
+		)
+		problems := g.sort()
+		if len(problems) != 2 {
+			t.Fatalf("expected a cycle and a missing parent, got %v", problems)
+		}
+		if got, want := problems[0].Error(), "dependency cycle: a:1 [amd64] -> c:1 [amd64] -> b:1 [amd64] -> a:1 [amd64]"; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+		if got, want := problems[1].Error(), "e:1 [amd64]: parent nope:1 should be nope, but that sourceId is unknown to us"; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+		for _, key := range []string{"a-amd64", "b-amd64", "c-amd64", "e-amd64"} {
+			if g.Nodes.Get(key).Err == nil {
+				t.Errorf("expected %s to be marked as a problem", key)
+			}
+		}
+		if err := g.Nodes.Get("d-amd64").Err; err != nil {
+			// (it'll fail when its parent does, but it isn't part of the cycle itself)
+			t.Errorf("unexpected problem for d-amd64: %v", err)
+		}
+		if len(g.Nodes.Keys()) != 5 {
+			t.Errorf("expected all nodes to still be in the graph, got %v", g.Nodes.Keys())
+		}
+
+		var b strings.Builder
+		if err := g.writeDOT(&b); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			"\t\"c-amd64\" -> \"d-amd64\" [label=\"c:1\"];\n",
+			"\t\"nope-amd64\" [label=\"nope:1\\n(unknown sourceId)\", color=red, style=dashed];\n",
+		} {
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("expected DOT output to contain %q:\n%s", want, b.String())
+			}
+		}
+
+		b.Reset()
+		if err := g.writeJSON(&b); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(b.String(), `"error": "dependency cycle: `) {
+			t.Errorf("expected JSON output to contain errors:\n%s", b.String())
+		}
+	})
+}
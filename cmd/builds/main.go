@@ -4,13 +4,17 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"maps"
 	"os"
-	"os/exec"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/docker-library/meta-scripts/om"
 	"github.com/docker-library/meta-scripts/registry"
@@ -40,12 +44,17 @@ type BuildIDParts struct {
 type MetaBuild struct {
 	BuildID string `json:"buildId"`
 	Build   struct {
-		Img      string         `json:"img"`
-		Resolved *ocispec.Index `json:"resolved"`
+		Img string `json:"img"`
+		// the template "img" came from (only recorded with BASHBREW_STAGING_CONFIG, since otherwise it's always BASHBREW_STAGING_TEMPLATE)
+		StagingTemplate string         `json:"stagingTemplate,omitempty"`
+		Resolved        *ocispec.Index `json:"resolved"`
 		BuildIDParts
 		ResolvedParents om.OrderedMap[ocispec.Index] `json:"resolvedParents"`
 	} `json:"build"`
 	Source json.RawMessage `json:"source"`
+
+	// why this source/architecture failed to resolve (only ever written with "--keep-going", keyed by "sourceId-arch" instead of the buildId since failures don't always get far enough to have one; see "needs_build" in "meta.jq" for why these never get queued)
+	Error string `json:"error,omitempty"`
 }
 
 var (
@@ -54,10 +63,11 @@ var (
 	cacheFile    string
 )
 
-func resolveIndex(ctx context.Context, img string, diskCacheForSure bool) (*ocispec.Index, error) {
+// parses an image reference into the form we use as a cache key
+func normalizeRef(img string) (registry.Reference, error) {
 	ref, err := registry.ParseRef(img)
 	if err != nil {
-		return nil, err
+		return ref, err
 	}
 	if ref.Digest != "" {
 		// we use "ref" as a cache key, so if we have an explicit digest, ditch any tag data
@@ -65,10 +75,21 @@ func resolveIndex(ctx context.Context, img string, diskCacheForSure bool) (*ocis
 	} else if ref.Tag == "" {
 		ref.Tag = "latest"
 	}
+	return ref, nil
+}
+
+// "referrers" is whether to also look up subject-linked attestations (see [registry.SynthesizeIndexOptions]), which we only want for our own staging images (parents don't need them, and it's extra requests per image); it isn't part of the cache key because staging images are never looked up as parents (those come from "sourceArchResolved" instead)
+func resolveIndex(ctx context.Context, img string, diskCacheForSure, referrers bool) (*ocispec.Index, error) {
+	ref, err := normalizeRef(img)
+	if err != nil {
+		return nil, err
+	}
 	refString := ref.String()
 
 	cacheFunc, wasCached := cacheResolve.LoadOrStore(refString, sync.OnceValues(func() (*ocispec.Index, error) {
-		return registry.SynthesizeIndex(ctx, ref)
+		return retry(ctx, refString, func() (*ocispec.Index, error) {
+			return registry.SynthesizeIndex(ctx, ref, &registry.SynthesizeIndexOptions{Referrers: referrers})
+		})
 	}))
 
 	index, err := cacheFunc.(func() (*ocispec.Index, error))()
@@ -88,18 +109,14 @@ func resolveIndex(ctx context.Context, img string, diskCacheForSure bool) (*ocis
 		diskCacheForSure = (ref.Digest != "")
 	}
 	if diskCacheForSure {
-		saveCacheMutex.Lock()
-		if saveCache != nil {
-			saveCache.Indexes[ref] = index
-		}
-		saveCacheMutex.Unlock()
+		cacheIndex(ref, index)
 	}
 
 	return index, nil
 }
 
-func resolveArchIndex(ctx context.Context, img string, arch string, diskCacheForSure bool) (*ocispec.Index, error) {
-	index, err := resolveIndex(ctx, img, diskCacheForSure)
+func resolveArchIndex(ctx context.Context, img string, arch string, diskCacheForSure, referrers bool) (*ocispec.Index, error) {
+	index, err := resolveIndex(ctx, img, diskCacheForSure, referrers)
 	if err != nil {
 		return nil, err
 	}
@@ -111,7 +128,8 @@ func resolveArchIndex(ctx context.Context, img string, arch string, diskCacheFor
 	indexCopy := *index
 	indexCopy.Manifests = nil
 	indexCopy.Manifests = append(indexCopy.Manifests, index.Manifests...)
-	// TODO top-level *and* nested Annotations/URLs/Platform also? (we don't currently mutate any of those, so not critical)
+	indexCopy.Annotations = maps.Clone(index.Annotations) // (we modify these below)
+	// TODO nested Annotations/URLs/Platform also? (we don't currently mutate any of those, so not critical)
 	index = &indexCopy
 
 	i := 0 // https://go.dev/wiki/SliceTricks#filter-in-place (used to delete references that don't belong to the selected architecture)
@@ -122,247 +140,459 @@ func resolveArchIndex(ctx context.Context, img string, arch string, diskCacheFor
 		index.Manifests[i] = m
 		i++
 	}
+	if i != len(index.Manifests) {
+		// the index no longer matches upstream, so deploy can't copy the original as-is (see registry.SynthesizedIndexUpstream); we deliberately don't list every other architecture's digest in registry.AnnotationExcludedManifests, though (that's most of upstream, repeated for every resolved parent in "builds.json", and the other architectures are exactly what anyone would expect to be missing from a single-architecture index)
+		index.Annotations[registry.AnnotationUnmodified] = "false"
+	}
 	index.Manifests = index.Manifests[:i] // https://go.dev/wiki/SliceTricks#filter-in-place
 
-	// TODO set an annotation on the index to specify whether or not we actually filtered anything (or whether it's safe to copy the original index as-is during arch-specific deploy instead of reconstructing it from all the parts); maybe a list of digests that were skipped/excluded?
-	// see matching TODO over in registry.SynthesizeIndex (which this needs to also respect/keep/supplement, if/when we implement it here)
-
 	if len(index.Manifests) == 0 {
 		return nil, nil
 	}
 
-	// TODO if we have more than one *actual* image match for arch (not just an attestation), this should error!! (would mean something like index/manifest list with multiple os.version values for Windows - we avoid this in DOI today, but we don't have any automated *checks* for it, so the current state is a little precarious)
+	// if we have more than one *actual* image match for arch (not just an attestation), that's ambiguous (something like an index with multiple os.version values for Windows), so callers need to decide what to do about it (hence returning the index *and* the error)
+	if err := archImagesError(index); err != nil {
+		return index, fmt.Errorf("%s: %w", img, err)
+	}
 
 	return index, nil
 }
 
-type cacheFileContents struct {
-	Indexes map[registry.Reference]*ocispec.Index `json:"indexes"`
+// returns a [registry.MultipleImagesError] if the given (single arch) index has more than one actual image in it
+func archImagesError(index *ocispec.Index) error {
+	if multiple := registry.MultipleImages(index, false); len(multiple) > 0 {
+		return multiple[0] // (there can only be one, since we only have one arch)
+	}
+	return nil
 }
 
-var (
-	saveCache      *cacheFileContents
-	saveCacheMutex sync.Mutex
-)
-
-func loadCacheFromFile() error {
-	if cacheFile == "" {
-		return nil
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx) // (so the first failure can stop everything else, unless "--keep-going")
+	defer cancel()
+
+	var (
+		sourcesJsonFile string // "sources.json"
+		keepGoing       bool   // whether to keep resolving everything else after a failure (and report all failures at the end) instead of stopping at the first one
+		graphFormat     string // "json" or "dot" (see [sourceGraph])
+		previousFile    string // a previous "builds.json" to reuse unchanged builds from (see [previousBuilds])
+		explainQuery    string // a buildId or tag to explain (see [explain]), in which case the input is "builds.json" instead
+		verifyCacheN    int    // how many cache entries to re-check against their registries (see [verifyCache])
+	)
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: builds [--cache cache.json [--cache-max-age days] [--verify-cache[=n]]] [--previous builds.json] [--keep-going] [--graph json|dot] sources.json")
+		fmt.Fprintln(os.Stderr, "   or: builds --explain <buildId|tag> [--previous previous-builds.json] builds.json")
+		os.Exit(2)
 	}
-
-	// now that we know we have a file we want cache to go into (and come from), let's initialize the "saveCache" (which will be written when the whole process is done / we're successful, and *only* caches staging images)
-	saveCacheMutex.Lock()
-	saveCache = &cacheFileContents{Indexes: map[registry.Reference]*ocispec.Index{}}
-	saveCacheMutex.Unlock()
-
-	f, err := os.Open(cacheFile)
-	if os.IsNotExist(err) {
-		return nil
-	} else if err != nil {
-		return err
+	for args := os.Args[1:]; len(args) > 0; args = args[1:] {
+		switch arg := args[0]; {
+		// support "--cache foo.json" and "--cache=foo.json"
+		case arg == "--cache" && len(args) >= 2:
+			cacheFile = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--cache="):
+			cacheFile = strings.TrimPrefix(arg, "--cache=")
+
+		// "--cache-max-age 30" / "--cache-max-age=30" (days to keep cache entries that weren't used; see [cacheMaxAge])
+		case arg == "--cache-max-age" && len(args) >= 2, strings.HasPrefix(arg, "--cache-max-age="):
+			val, ok := strings.CutPrefix(arg, "--cache-max-age=")
+			if !ok {
+				val = args[1]
+				args = args[1:]
+			}
+			days, err := strconv.Atoi(val)
+			if err != nil || days < 0 {
+				usage()
+			}
+			cacheMaxAge = time.Duration(days) * 24 * time.Hour
+
+		// "--verify-cache" / "--verify-cache=100" (how many randomly chosen entries to check)
+		case arg == "--verify-cache":
+			verifyCacheN = 10
+		case strings.HasPrefix(arg, "--verify-cache="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--verify-cache="))
+			if err != nil || n < 1 {
+				usage()
+			}
+			verifyCacheN = n
+
+		// "--previous builds.json" / "--previous=builds.json"
+		case arg == "--previous" && len(args) >= 2:
+			previousFile = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--previous="):
+			previousFile = strings.TrimPrefix(arg, "--previous=")
+
+		// "--explain buildId" / "--explain=tag"
+		case arg == "--explain" && len(args) >= 2:
+			explainQuery = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--explain="):
+			explainQuery = strings.TrimPrefix(arg, "--explain=")
+
+		case arg == "--keep-going":
+			keepGoing = true
+
+		// "--graph json" / "--graph=dot" (just print the graph of sources and their parents, for debugging)
+		case arg == "--graph" && len(args) >= 2:
+			graphFormat = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--graph="):
+			graphFormat = strings.TrimPrefix(arg, "--graph=")
+
+		case sourcesJsonFile == "" && !strings.HasPrefix(arg, "--"):
+			sourcesJsonFile = arg
+
+		default:
+			usage()
+		}
 	}
-	defer f.Close()
-
-	var cache cacheFileContents
-	err = json.NewDecoder(f).Decode(&cache) // *technically*, this will silently ignore garbage (or extra documents) at the end of the file, but it's for our cache file so it's not really an issue for us (the only input to this should be our own output)
-	if err != nil {
-		return err
+	if sourcesJsonFile == "" || (graphFormat != "" && graphFormat != "json" && graphFormat != "dot") {
+		usage()
 	}
 
-	for img, index := range cache.Indexes {
-		index := index // https://github.com/golang/go/issues/60078
-		fun, _ := cacheResolve.LoadOrStore(img.String(), sync.OnceValues(func() (*ocispec.Index, error) {
-			return index, nil
-		}))
-		index2, err := fun.(func() (*ocispec.Index, error))()
+	if explainQuery != "" {
+		// prints the exact pre-image of the buildId(s) on stdout (so "| sha256sum" gives the buildId back), and the explanation on stderr
+		builds, err := loadBuildsJSON(sourcesJsonFile)
 		if err != nil {
-			// this should never happen (hence panic vs return) 🙈
-			panic(err)
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		var previous om.OrderedMap[MetaBuild]
+		if previousFile != "" {
+			previous, err = loadBuildsJSON(previousFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
 		}
-		if index2 != index {
-			panic("index2 != index??? " + img.String())
+		if err := explain(os.Stdout, os.Stderr, explainQuery, builds, previous); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
 		}
+		return
 	}
 
-	return nil
-}
-
-func saveCacheToFile() error {
-	saveCacheMutex.Lock()
-	defer saveCacheMutex.Unlock()
-
-	if saveCache == nil || cacheFile == "" {
-		return nil
+	if err := loadCacheFromFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to load cache %q: %v\n", cacheFile, err)
+		os.Exit(1)
 	}
-
-	f, err := os.Create(cacheFile)
-	if err != nil {
-		return err
+	if verifyCacheN > 0 {
+		if stale, err := verifyCache(ctx, verifyCacheN); err != nil {
+			// not being able to verify isn't a reason to fail the whole run (the lookups will probably fail too, and report that properly)
+			fmt.Fprintf(os.Stderr, "WARNING: failed to verify cache: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "NOTE: verified cache (%d stale entries dropped)\n", stale)
+		}
 	}
-	defer f.Close()
 
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "\t")
+	var (
+		previous       previousBuilds
+		previousReused atomic.Int64
+	)
+	if previousFile != "" {
+		var err error
+		previous, err = loadPrevious(previousFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to load previous builds: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	err = enc.Encode(saveCache)
+	staging, err := loadStagingConfig()
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
 	}
 
-	return nil
-}
-
-func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
-
-	sourcesJsonFile := os.Args[1] // "sources.json"
-
-	// support "--cache foo.json" and "--cache=foo.json"
-	if sourcesJsonFile == "--cache" && len(os.Args) >= 4 {
-		cacheFile = os.Args[2]
-		sourcesJsonFile = os.Args[3]
-	} else if cf, ok := strings.CutPrefix(sourcesJsonFile, "--cache="); ok && len(os.Args) >= 3 {
-		cacheFile = cf
-		sourcesJsonFile = os.Args[2]
+	var (
+		failures      []error
+		failuresMutex sync.Mutex
+	)
+	fail := func(err error) {
+		failuresMutex.Lock()
+		defer failuresMutex.Unlock()
+		if !keepGoing && len(failures) > 0 && errors.Is(err, context.Canceled) {
+			// we already gave up, so this is just the fallout of that
+			return
+		}
+		failures = append(failures, err)
+		if !keepGoing {
+			cancel()
+		}
 	}
-	if err := loadCacheFromFile(); err != nil {
-		panic(err)
+	failed := func() bool {
+		failuresMutex.Lock()
+		defer failuresMutex.Unlock()
+		return len(failures) > 0
 	}
-
-	stagingTemplate := os.Getenv("BASHBREW_STAGING_TEMPLATE") // "oisupport/staging-ARCH:BUILD"
-	if !strings.Contains(stagingTemplate, "BUILD") {
-		panic("invalid BASHBREW_STAGING_TEMPLATE (missing BUILD)")
+	printFailures := func() {
+		failuresMutex.Lock()
+		defer failuresMutex.Unlock()
+		// (sorted, so the summary doesn't depend on which goroutine happened to fail first)
+		slices.SortFunc(failures, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+		fmt.Fprintf(os.Stderr, "\nERROR: %d failure(s):\n", len(failures))
+		for _, err := range failures {
+			fmt.Fprintf(os.Stderr, " - %v\n", err)
+		}
 	}
 
 	type out struct {
-		buildId string
-		json    []byte
+		key  string // the buildId (or "sourceId-arch" for failures; see [MetaBuild.Error])
+		json []byte
 	}
 	outs := make(chan chan out, concurrency) // we want the end result to be "in order", so we have a channel of channels of outputs so each output can be generated async (and write to the "inner" channel) and the outer channel stays in the input order
 
-	go func() {
-		// Go does not have ordered maps *and* is complicated to read an object, make a tiny modification, write it back out (without modelling the entire schema), so we'll let a single invocation of jq solve both problems (munging the documents in the way we expect *and* giving us an in-order stream)
-		jq := exec.Command("jq", "--compact-output", ".[] | (.arches | to_entries[]) as $arch | .arches = { ($arch.key): $arch.value }", sourcesJsonFile)
-		jq.Stderr = os.Stderr
+	// a semaphore, so no more than "concurrency" sources are talking to registries at once
+	resolving := make(chan struct{}, concurrency)
 
-		stdout, err := jq.StdoutPipe()
-		if err != nil {
-			panic(err)
-		}
-		if err := jq.Start(); err != nil {
-			panic(err)
-		}
+	// we need to know about every source before we can resolve any of them (so that "sources.json" order doesn't matter for parents)
+	var (
+		graph              sourceGraph
+		sourceArchOuts     []chan out // in "sources.json" order
+		sourceArchResolved = map[string](func() (*ocispec.Index, error)){}
+	)
 
-		sourceArchResolved := map[string](func() *ocispec.Index){}
-		sourceArchResolvedMutex := sync.RWMutex{}
+	f, err := os.Open(sourcesJsonFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := readSources(sourcesJsonFile, f, func(rawSource json.RawMessage) error {
+		build := MetaBuild{Source: rawSource}
 
-		decoder := json.NewDecoder(stdout)
-		for decoder.More() {
-			var build MetaBuild
+		var source MetaSource
+		if err := json.Unmarshal(build.Source, &source); err != nil {
+			fail(fmt.Errorf("%s: %w", build.Source, err))
+			return nil
+		}
 
-			if err := decoder.Decode(&build.Source); err == io.EOF {
-				break
-			} else if err != nil {
-				panic(err)
-			}
+		build.Build.SourceID = source.SourceID
 
-			var source MetaSource
-			if err := json.Unmarshal(build.Source, &source); err != nil {
-				panic(err)
-			}
+		if len(source.Arches) != 1 {
+			fail(buildError{SourceID: source.SourceID, Err: fmt.Errorf("unexpected arches length: %d", len(source.Arches))})
+			return nil
+		}
+		for build.Build.Arch = range source.Arches {
+			// I really hate Go.
+			// (just doing a lookup of the only key in my map into a variable)
+		}
+		if len(source.Arches[build.Build.Arch].Tags) == 0 {
+			fail(buildError{SourceID: source.SourceID, Arch: build.Build.Arch, Err: errors.New("no tags")})
+			return nil
+		}
+		tag := source.Arches[build.Build.Arch].Tags[0]
+
+		key := sourceGraphKey(source.SourceID, build.Build.Arch)
+		if graph.Nodes.Has(key) {
+			fail(buildError{SourceID: source.SourceID, Arch: build.Build.Arch, Err: errors.New("duplicate sourceId")})
+			return nil
+		}
+		graph.add(source, build.Build.Arch)
+		node := graph.Nodes.Get(key)
 
-			build.Build.SourceID = source.SourceID
+		outChan := make(chan out, 1)
+		sourceArchOuts = append(sourceArchOuts, outChan)
 
-			if len(source.Arches) != 1 {
-				panic("unexpected arches length: " + string(build.Source))
-			}
-			for build.Build.Arch = range source.Arches {
-				// I really hate Go.
-				// (just doing a lookup of the only key in my map into a variable)
+		resolve := func() (*ocispec.Index, error) {
+			if node.Err != nil {
+				// a missing parent or dependency cycle (see [sourceGraph.sort])
+				return nil, node.Err
 			}
 
-			outChan := make(chan out, 1)
-			outs <- outChan
+			// a parent with more than one image for our architecture means we can't know which one is "the" parent, so this source can't be built (and it's better to fail loudly than to silently pick the first one)
+			multipleError := func(from string, err error) error {
+				var multiple registry.MultipleImagesError
+				if !errors.As(err, &multiple) {
+					return nil
+				}
+				return fmt.Errorf("parent %s has multiple images: %w", from, multiple)
+			}
 
-			sourceArchResolvedFunc := sync.OnceValue(func() *ocispec.Index {
-				for _, from := range source.Arches[build.Build.Arch].Parents.Keys() {
-					if from == "scratch" {
-						continue
+			for _, from := range source.Arches[build.Build.Arch].Parents.Keys() {
+				if from == "scratch" {
+					continue
+				}
+				var (
+					resolved *ocispec.Index
+					err      error
+				)
+				parent := source.Arches[build.Build.Arch].Parents.Get(from)
+				if parent.SourceID != nil {
+					// (guaranteed to exist, or else "node.Err" would've been set)
+					resolved, err = sourceArchResolved[node.Parents.Get(from)]()
+					if err != nil {
+						// (the parent already reported its own error, so we don't need to repeat it here)
+						return nil, fmt.Errorf("%w: %s (%s)", errParentFailed, from, *parent.SourceID)
 					}
-					var resolved *ocispec.Index
-					parent := source.Arches[build.Build.Arch].Parents.Get(from)
-					if parent.SourceID != nil {
-						sourceArchResolvedMutex.RLock()
-						resolvedFunc, ok := sourceArchResolved[*parent.SourceID+"-"+build.Build.Arch]
-						if !ok {
-							panic("parent of " + source.SourceID + " on " + build.Build.Arch + " should be " + *parent.SourceID + " but that sourceId is unknown to us!")
-						}
-						sourceArchResolvedMutex.RUnlock()
-						resolved = resolvedFunc()
-					} else {
-						lookup := from
-						if parent.Pin != nil {
-							lookup += "@" + *parent.Pin
-						}
-
-						resolved, err = resolveArchIndex(ctx, lookup, build.Build.Arch, false)
-						if err != nil {
-							panic(err)
+					if resolved != nil {
+						if err := multipleError(from, archImagesError(resolved)); err != nil {
+							return nil, err
 						}
 					}
-					if resolved == nil {
-						fmt.Fprintf(os.Stderr, "%s (%s) -> not yet! [%s]\n", source.SourceID, source.Arches[build.Build.Arch].Tags[0], build.Build.Arch)
-						close(outChan)
-						return nil
+				} else {
+					lookup := from
+					if parent.Pin != nil {
+						lookup += "@" + *parent.Pin
 					}
-					build.Build.ResolvedParents.Set(from, *resolved)
-					build.Build.Parents.Set(from, string(resolved.Manifests[0].Digest))
-				}
 
-				// buildId calculation
-				buildIDJSON, err := json.Marshal(&build.Build.BuildIDParts)
-				if err != nil {
-					panic(err)
+					resolved, err = resolveArchIndex(ctx, lookup, build.Build.Arch, false, false)
+					if multipleErr := multipleError(from, err); multipleErr != nil {
+						return nil, multipleErr
+					} else if err != nil {
+						return nil, fmt.Errorf("parent %w", err) // ("parent some-image:tag: failed GET: ...")
+					}
+				}
+				if resolved == nil {
+					fmt.Fprintf(os.Stderr, "%s (%s) -> not yet! [%s]\n", source.SourceID, tag, build.Build.Arch)
+					close(outChan)
+					return nil, nil
 				}
-				buildIDJSON = append(buildIDJSON, byte('\n')) // previous calculation of buildId included a newline in the JSON, so this preserves compatibility
-				// TODO if we ever have a bigger "buildId break" event (like adding major base images that force the whole tree to rebuild), we should probably ditch this newline
+				build.Build.ResolvedParents.Set(from, *resolved)
+				build.Build.Parents.Set(from, string(resolved.Manifests[0].Digest))
+			}
 
-				build.BuildID = fmt.Sprintf("%x", sha256.Sum256(buildIDJSON))
-				fmt.Fprintf(os.Stderr, "%s (%s) -> %s [%s]\n", source.SourceID, source.Arches[build.Build.Arch].Tags[0], build.BuildID, build.Build.Arch)
+			// buildId calculation
+			buildIDJSON, err := buildIDPreimage(build.Build.BuildIDParts)
+			if err != nil {
+				return nil, err
+			}
 
-				build.Build.Img = strings.ReplaceAll(strings.ReplaceAll(stagingTemplate, "BUILD", build.BuildID), "ARCH", build.Build.Arch) // "oisupport/staging-amd64:xxxx"
+			build.BuildID = fmt.Sprintf("%x", sha256.Sum256(buildIDJSON))
+			fmt.Fprintf(os.Stderr, "%s (%s) -> %s [%s]\n", source.SourceID, tag, build.BuildID, build.Build.Arch)
 
-				build.Build.Resolved, err = resolveArchIndex(ctx, build.Build.Img, build.Build.Arch, true)
-				if err != nil {
-					panic(err)
+			var stagingTemplate string
+			build.Build.Img, stagingTemplate, err = staging.img(build.Build.Arch, build.BuildID) // "oisupport/staging-amd64:xxxx"
+			if err != nil {
+				return nil, err
+			}
+			if staging.structured {
+				build.Build.StagingTemplate = stagingTemplate
+			}
+
+			if prev, ok := previous.reusable(build); ok {
+				// same inputs *and* the staging image already existed, so there's no need to look it up again (the trade-off being that a staging image deleted since then won't be noticed until a run without "--previous")
+				build.Build.Resolved = prev.Build.Resolved
+				touchCache(build.Build.Img)
+				if err = archImagesError(build.Build.Resolved); err != nil {
+					err = fmt.Errorf("%s: %w", build.Build.Img, err)
 				}
+				previousReused.Add(1)
+			} else {
+				build.Build.Resolved, err = resolveArchIndex(ctx, build.Build.Img, build.Build.Arch, true, true)
+			}
+			if multiple := (registry.MultipleImagesError{}); errors.As(err, &multiple) {
+				// our own staging image having multiple images is weird, but it's still our build (and deploying it is deploy's problem), so we just warn (and anything that uses this as a parent will refuse, above)
+				fmt.Fprintf(os.Stderr, "%s (%s) -> WARNING: %v [%s]\n", source.SourceID, tag, err, build.Build.Arch)
+			} else if err != nil {
+				return nil, err
+			}
 
-				json, err := json.Marshal(&build)
-				if err != nil {
-					panic(err)
+			json, err := json.Marshal(&build)
+			if err != nil {
+				return nil, err
+			}
+			outChan <- out{
+				key:  build.BuildID,
+				json: json,
+			}
+
+			return build.Build.Resolved, nil
+		}
+		sourceArchResolvedFunc := sync.OnceValues(func() (*ocispec.Index, error) {
+			if node.Err == nil {
+				// wait for our parents *before* taking a slot (otherwise a chain of parents longer than "concurrency" would deadlock, with every slot held by something waiting on a parent that can't get one); their results are cached, so "resolve" gets them for free below
+				for _, from := range node.Parents.Keys() {
+					sourceArchResolved[node.Parents.Get(from)]()
 				}
-				outChan <- out{
-					buildId: build.BuildID,
-					json:    json,
+			}
+			resolving <- struct{}{}
+			index, err := resolve()
+			<-resolving
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s (%s) -> ERROR: %v [%s]\n", source.SourceID, tag, err, build.Build.Arch)
+				if keepGoing {
+					// mark the failure in the output too (along with whatever we did manage to resolve before it), so it's obvious from "builds.json" alone which sources are missing and why
+					build.Error = err.Error()
+					b, jsonErr := json.Marshal(&build)
+					if jsonErr != nil {
+						panic(jsonErr) // ("build.Source" already parsed successfully above, so this really cannot fail)
+					}
+					outChan <- out{
+						key:  key,
+						json: b,
+					}
 				}
+				close(outChan)
+				err = buildError{SourceID: source.SourceID, Arch: build.Build.Arch, Err: err}
+				fail(err)
+			}
+			return index, err
+		})
+		sourceArchResolved[key] = sourceArchResolvedFunc
 
-				return build.Build.Resolved
-			})
-			sourceArchResolvedMutex.Lock()
-			sourceArchResolved[source.SourceID+"-"+build.Build.Arch] = sourceArchResolvedFunc
-			sourceArchResolvedMutex.Unlock()
-			go sourceArchResolvedFunc()
+		return nil
+	}); err != nil {
+		// (a problem with "sources.json" as a whole, so there's no point in continuing)
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	f.Close()
+
+	problems := graph.sort()
+	if graphFormat == "" {
+		// make sure every architecture we're going to build has somewhere to go *before* we look anything up
+		arches := map[string]bool{}
+		for _, key := range graph.Nodes.Keys() {
+			arch := graph.Nodes.Get(key).Arch
+			if arches[arch] {
+				continue
+			}
+			arches[arch] = true
+			if _, err := staging.template(arch); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
 		}
-
-		if err := stdout.Close(); err != nil {
-			panic(err)
+	}
+	if graphFormat != "" {
+		var err error
+		switch graphFormat {
+		case "json":
+			err = graph.writeJSON(os.Stdout)
+		case "dot":
+			err = graph.writeDOT(os.Stdout)
+		}
+		if err != nil {
+			panic(err) // (failing to write to stdout)
 		}
-		if err := jq.Wait(); err != nil {
-			panic(err)
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", problem)
 		}
+		if len(problems) > 0 || failed() {
+			os.Exit(1)
+		}
+		return
+	}
+	if !keepGoing && (len(problems) > 0 || failed()) {
+		// no point in looking anything up if we already know we're going to fail
+		for _, problem := range problems {
+			fail(problem)
+		}
+		printFailures()
+		os.Exit(1)
+	}
 
-		close(outs)
+	// start resolving parents first (although sync.OnceValues would sort that out for us anyhow)
+	for _, key := range graph.Nodes.Keys() {
+		go sourceArchResolved[key]()
+	}
+	go func() {
+		defer close(outs)
+		for _, outChan := range sourceArchOuts {
+			outs <- outChan
+		}
 	}()
 
 	fmt.Print("{")
@@ -370,6 +600,12 @@ func main() {
 	for outChan := range outs {
 		out, ok := <-outChan
 		if !ok {
+			if !keepGoing && failed() {
+				// stop *without* closing the JSON object, so nothing mistakes our output for complete
+				fmt.Println()
+				printFailures()
+				os.Exit(1)
+			}
 			continue
 		}
 		if !first {
@@ -378,16 +614,33 @@ func main() {
 			first = false
 		}
 		fmt.Println()
-		buildId, err := json.Marshal(out.buildId)
+		key, err := json.Marshal(out.key)
 		if err != nil {
-			panic(err)
+			panic(err) // (this is marshalling a plain string, so it really cannot fail)
 		}
-		fmt.Printf("\t%s: %s", string(buildId), string(out.json))
+		fmt.Printf("\t%s: %s", string(key), string(out.json))
+	}
+	if !keepGoing && failed() {
+		// (a failure outside of any single source, like failing to parse "sources.json")
+		fmt.Println()
+		printFailures()
+		os.Exit(1)
 	}
 	fmt.Println()
 	fmt.Println("}")
 
+	if previous != nil {
+		fmt.Fprintf(os.Stderr, "NOTE: reused %d staging image lookups from %s\n", previousReused.Load(), previousFile)
+	}
+
+	// even with failures (in "--keep-going" mode), everything we did successfully look up is still worth caching
 	if err := saveCacheToFile(); err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "error: failed to save cache %q: %v\n", cacheFile, err)
+		os.Exit(1)
+	}
+
+	if failed() {
+		printFailures()
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// the builds from a previous run (see "--previous"), keyed by buildId
+type previousBuilds map[string]MetaBuild
+
+func loadPrevious(file string) (previousBuilds, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret previousBuilds
+	if err := json.NewDecoder(f).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return ret, nil
+}
+
+// returns the previous build for the given (freshly calculated) build if it can be reused as-is instead of looking up the staging image again: same buildId (so the same sourceId, arch, and parent digests), same staging image name, the same source object, and the staging image already existed last time (otherwise it's still "needs_build" and we need to check whether it exists yet)
+func (p previousBuilds) reusable(build MetaBuild) (MetaBuild, bool) {
+	prev, ok := p[build.BuildID]
+	if !ok || prev.Build.Resolved == nil || prev.Build.Img != build.Build.Img {
+		return prev, false
+	}
+	same, err := jsonEqual(prev.Source, build.Source)
+	if err != nil || !same {
+		return prev, false
+	}
+	return prev, true
+}
+
+// whether two JSON documents are semantically equal (builds.json usually goes through "jq" on its way to disk, so the bytes won't match exactly)
+func jsonEqual(a, b json.RawMessage) (bool, error) {
+	var av, bv any
+	if err := json.Unmarshal(a, &av); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(av, bv), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPreviousBuildsReusable(t *testing.T) {
+	prevJSON := `{
+		"deadbeef": {
+			"buildId": "deadbeef",
+			"build": {
+				"img": "oisupport/staging-amd64:deadbeef",
+				"resolved": { "schemaVersion": 2, "manifests": [] },
+				"sourceId": "foo",
+				"arch": "amd64",
+				"parents": {},
+				"resolvedParents": {}
+			},
+			"source": {
+				"sourceId": "foo",
+				"arches": { "amd64": { "tags": [ "foo:latest" ] } }
+			}
+		},
+		"cafebabe": {
+			"buildId": "cafebabe",
+			"build": {
+				"img": "oisupport/staging-amd64:cafebabe",
+				"resolved": null,
+				"sourceId": "bar",
+				"arch": "amd64",
+				"parents": {},
+				"resolvedParents": {}
+			},
+			"source": { "sourceId": "bar" }
+		}
+	}`
+	var previous previousBuilds
+	if err := json.Unmarshal([]byte(prevJSON), &previous); err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(buildID, img, source string) MetaBuild {
+		var b MetaBuild
+		b.BuildID = buildID
+		b.Build.Img = img
+		b.Source = json.RawMessage(source)
+		return b
+	}
+
+	for _, x := range []struct {
+		name  string
+		build MetaBuild
+		want  bool
+	}{
+		{"unchanged", build("deadbeef", "oisupport/staging-amd64:deadbeef", `{"sourceId":"foo","arches":{"amd64":{"tags":["foo:latest"]}}}`), true},
+		{"new buildId", build("f00df00d", "oisupport/staging-amd64:f00df00d", `{"sourceId":"foo","arches":{"amd64":{"tags":["foo:latest"]}}}`), false},
+		{"different staging template", build("deadbeef", "example/staging:amd64-deadbeef", `{"sourceId":"foo","arches":{"amd64":{"tags":["foo:latest"]}}}`), false},
+		{"changed source", build("deadbeef", "oisupport/staging-amd64:deadbeef", `{"sourceId":"foo","arches":{"amd64":{"tags":["foo:latest","foo:1"]}}}`), false},
+		{"needs_build", build("cafebabe", "oisupport/staging-amd64:cafebabe", `{"sourceId":"bar"}`), false},
+	} {
+		x := x // https://github.com/golang/go/issues/60078
+		t.Run(x.name, func(t *testing.T) {
+			prev, ok := previous.reusable(x.build)
+			if ok != x.want {
+				t.Fatalf("expected %v, got %v", x.want, ok)
+			}
+			if ok && (prev.BuildID != x.build.BuildID || prev.Build.Resolved == nil) {
+				t.Fatalf("unexpected previous build: %+v", prev)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/docker-library/meta-scripts/om"
+)
+
+// reads "sources.json" (an object or array of source objects) as a stream, and for each source, calls "yield" once per architecture with a copy of the source that only contains that one architecture in "arches" (in the original order of both sources and arches, and with every object's keys in their original order too)
+//
+// this is the equivalent of: jq --compact-output '.[] | (.arches | to_entries[]) as $arch | .arches = { ($arch.key): $arch.value }'
+//
+// errors include the position ("sources.json:LINE:COLUMN") of the problem in the input
+func readSources(name string, r io.Reader, yield func(source json.RawMessage) error) error {
+	lines := &lineReader{r: r}
+	dec := json.NewDecoder(lines)
+
+	errorf := func(offset int64, format string, args ...any) error {
+		line, col := lines.position(offset)
+		return fmt.Errorf("%s:%d:%d: %w", name, line, col, fmt.Errorf(format, args...))
+	}
+	// turn a JSON decoding error (with or without its own offset) into one with a position
+	decodeError := func(err error, base int64) error {
+		var (
+			syntaxErr *json.SyntaxError
+			typeErr   *json.UnmarshalTypeError
+		)
+		switch {
+		case errors.As(err, &syntaxErr):
+			// (the offset of a syntax error is *after* the offending byte)
+			return errorf(base+max(syntaxErr.Offset-1, 0), "%w", err)
+		case errors.As(err, &typeErr):
+			return errorf(base+typeErr.Offset, "%w", err)
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return errorf(dec.InputOffset(), "unexpected end of JSON input")
+		}
+		return errorf(dec.InputOffset(), "%w", err)
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return decodeError(err, 0)
+	}
+	var isObject bool
+	switch tok {
+	case json.Delim('{'):
+		isObject = true
+	case json.Delim('['):
+		isObject = false
+	default:
+		return errorf(dec.InputOffset(), "expected object or array of sources, got %v", tok)
+	}
+
+	for dec.More() {
+		if isObject {
+			// we don't care about the keys (they're just the sourceId again), only the values
+			if _, err := dec.Token(); err != nil {
+				return decodeError(err, 0)
+			}
+		}
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return decodeError(err, 0)
+		}
+		start := dec.InputOffset() - int64(len(raw)) // where this source object starts (for errors)
+
+		var source om.OrderedMap[json.RawMessage]
+		if err := json.Unmarshal(raw, &source); err != nil {
+			return decodeError(err, start)
+		}
+		if !source.Has("arches") {
+			return errorf(start, "source is missing \"arches\"")
+		}
+		var arches om.OrderedMap[json.RawMessage]
+		if err := json.Unmarshal(source.Get("arches"), &arches); err != nil {
+			return decodeError(err, start)
+		}
+
+		for _, arch := range arches.Keys() {
+			var single om.OrderedMap[json.RawMessage]
+			single.Set(arch, arches.Get(arch))
+			archJSON, err := json.Marshal(single)
+			if err != nil {
+				return errorf(start, "%w", err)
+			}
+
+			// (a fresh map for each arch, since copies of an OrderedMap share their underlying storage)
+			var perArch om.OrderedMap[json.RawMessage]
+			for _, key := range source.Keys() {
+				if key == "arches" {
+					perArch.Set(key, archJSON)
+				} else {
+					perArch.Set(key, source.Get(key))
+				}
+			}
+			b, err := json.Marshal(perArch)
+			if err != nil {
+				return errorf(start, "%w", err)
+			}
+			if err := yield(b); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := dec.Token(); err != nil { // closing "}" or "]"
+		return decodeError(err, 0)
+	}
+	if tok, err := dec.Token(); err != io.EOF {
+		if err != nil {
+			return decodeError(err, 0)
+		}
+		return errorf(dec.InputOffset(), "unexpected content after sources: %v", tok)
+	}
+
+	return nil
+}
+
+// an [io.Reader] wrapper that keeps track of where each line starts, so byte offsets can be turned back into line/column positions for error messages
+type lineReader struct {
+	r      io.Reader
+	offset int64
+	starts []int64 // offsets of the start of every line after the first
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			l.starts = append(l.starts, l.offset+int64(i)+1)
+		}
+	}
+	l.offset += int64(n)
+	return n, err
+}
+
+// returns the (one-based) line and column of the given byte offset
+func (l *lineReader) position(offset int64) (int, int) {
+	// how many lines start at or before "offset"
+	i := sort.Search(len(l.starts), func(i int) bool { return l.starts[i] > offset })
+	lineStart := int64(0)
+	if i > 0 {
+		lineStart = l.starts[i-1]
+	}
+	return i + 1, int(offset-lineStart) + 1
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReadSources(t *testing.T) {
+	for _, x := range []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "object",
+			input: `{
+				"b": { "sourceId": "b", "reproducibleGitChecksum": "x", "arches": { "s390x": { "tags": ["b:1"] }, "amd64": { "tags": ["b:1"], "z": 1, "a": 2 } }, "extra": true },
+				"a": { "sourceId": "a", "arches": { "arm64v8": {} } }
+			}`,
+			want: []string{
+				`{"sourceId":"b","reproducibleGitChecksum":"x","arches":{"s390x":{"tags":["b:1"]}},"extra":true}`,
+				`{"sourceId":"b","reproducibleGitChecksum":"x","arches":{"amd64":{"tags":["b:1"],"z":1,"a":2}},"extra":true}`,
+				`{"sourceId":"a","arches":{"arm64v8":{}}}`,
+			},
+		},
+		{
+			name:  "array",
+			input: `[ { "arches": { "amd64": 1, "arm32v7": 2 }, "sourceId": "c" } ]`,
+			want: []string{
+				`{"arches":{"amd64":1},"sourceId":"c"}`,
+				`{"arches":{"arm32v7":2},"sourceId":"c"}`,
+			},
+		},
+		{
+			name:  "no arches",
+			input: `{ "a": { "sourceId": "a", "arches": {} } }`,
+		},
+		{
+			name:    "syntax error",
+			input:   "{\n  \"a\": { \"sourceId\": \"a\", \"arches\": {} },\n  \"b\": { \"sourceId\": oops }\n}",
+			wantErr: "sources.json:3:22: invalid character 'o'",
+		},
+		{
+			name:    "missing arches",
+			input:   "{\n  \"a\": { \"sourceId\": \"a\", \"arches\": {} },\n  \"b\": { \"sourceId\": \"b\" }\n}",
+			wantErr: `sources.json:3:8: source is missing "arches"`,
+		},
+		{
+			name:    "arches wrong type",
+			input:   "[\n\t{ \"arches\": [] }\n]",
+			wantErr: "sources.json:2:",
+		},
+		{
+			name:    "not a collection",
+			input:   `"sources"`,
+			wantErr: "sources.json:1:10: expected object or array of sources",
+		},
+		{
+			name:    "truncated",
+			input:   "{\n  \"a\": { \"sourceId\": \"a\", \"arches\": {} }",
+			wantErr: "sources.json:2:",
+		},
+		{
+			name:    "trailing garbage",
+			input:   "[]\n[]",
+			wantErr: "sources.json:2:2: unexpected content after sources",
+		},
+	} {
+		x := x // https://github.com/golang/go/issues/60078
+		t.Run(x.name, func(t *testing.T) {
+			var got []string
+			err := readSources("sources.json", strings.NewReader(x.input), func(source json.RawMessage) error {
+				got = append(got, string(source))
+				return nil
+			})
+			if x.wantErr != "" {
+				if err == nil {
+					t.Fatalf("expected error %q, got %v", x.wantErr, got)
+				} else if !strings.HasPrefix(err.Error(), x.wantErr) {
+					t.Fatalf("expected error %q, got %q", x.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "\n") != strings.Join(x.want, "\n") {
+				t.Fatalf("expected:\n%s\ngot:\n%s", strings.Join(x.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker-library/meta-scripts/om"
+	"github.com/docker-library/meta-scripts/registry"
+)
+
+// where staging images go, per architecture ("ARCH" and "BUILD" in each template get replaced by the architecture and buildId)
+//
+//	{
+//		"default": "oisupport/staging-ARCH:BUILD",
+//		"arches": {
+//			"riscv64": "registry.example.com/staging-riscv64:BUILD",
+//			"windows-*": "example.azurecr.io/staging-ARCH:BUILD"
+//		}
+//	}
+//
+// an exact match in "arches" wins, then the first matching pattern (see [path.Match]) in the order they're listed, and then "default" (or BASHBREW_STAGING_TEMPLATE, if there is no "default")
+type stagingConfig struct {
+	Default string                `json:"default"`
+	Arches  om.OrderedMap[string] `json:"arches"`
+
+	structured bool // whether this came from BASHBREW_STAGING_CONFIG (and thus whether builds should record which template they used)
+}
+
+// loads the staging configuration from the environment: BASHBREW_STAGING_CONFIG (either inline JSON or the path to a JSON file) and/or BASHBREW_STAGING_TEMPLATE (a single template for every architecture)
+func loadStagingConfig() (stagingConfig, error) {
+	var config stagingConfig
+	if env := os.Getenv("BASHBREW_STAGING_CONFIG"); env != "" {
+		b := []byte(env)
+		if !strings.HasPrefix(strings.TrimSpace(env), "{") {
+			var err error
+			b, err = os.ReadFile(env)
+			if err != nil {
+				return config, fmt.Errorf("BASHBREW_STAGING_CONFIG: %w", err)
+			}
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields() // (a typo here means images going somewhere unexpected, so be strict)
+		if err := dec.Decode(&config); err != nil {
+			return config, fmt.Errorf("BASHBREW_STAGING_CONFIG: %w", err)
+		}
+		config.structured = true
+	}
+	if config.Default == "" {
+		config.Default = os.Getenv("BASHBREW_STAGING_TEMPLATE") // "oisupport/staging-ARCH:BUILD"
+	}
+	if err := config.validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// checks that every template is usable (before we spend any time looking anything up)
+func (c stagingConfig) validate() error {
+	if c.Default == "" && len(c.Arches.Keys()) == 0 {
+		return errors.New("missing staging configuration (BASHBREW_STAGING_TEMPLATE or BASHBREW_STAGING_CONFIG)")
+	}
+	if c.Default != "" {
+		if err := validateStagingTemplate(c.Default); err != nil {
+			return fmt.Errorf("invalid default staging template: %w", err)
+		}
+	}
+	for _, arch := range c.Arches.Keys() {
+		if _, err := path.Match(arch, ""); err != nil {
+			return fmt.Errorf("invalid staging architecture pattern %q: %w", arch, err)
+		}
+		if err := validateStagingTemplate(c.Arches.Get(arch)); err != nil {
+			return fmt.Errorf("invalid staging template for %q: %w", arch, err)
+		}
+	}
+	return nil
+}
+
+func validateStagingTemplate(template string) error {
+	if !strings.Contains(template, "BUILD") {
+		return fmt.Errorf("%q: missing BUILD", template)
+	}
+	img := expandStagingTemplate(template, "amd64", strings.Repeat("0", 64))
+	ref, err := registry.ParseRef(img)
+	if err != nil {
+		return fmt.Errorf("%q: %w", template, err)
+	}
+	if ref.Digest != "" {
+		return fmt.Errorf("%q: staging images must be by tag, not digest", template)
+	}
+	return nil
+}
+
+func expandStagingTemplate(template, arch, buildID string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(template, "BUILD", buildID), "ARCH", arch)
+}
+
+// returns the template for the given architecture (see [stagingConfig] for the precedence)
+func (c stagingConfig) template(arch string) (string, error) {
+	if c.Arches.Has(arch) {
+		return c.Arches.Get(arch), nil
+	}
+	for _, pattern := range c.Arches.Keys() {
+		if ok, _ := path.Match(pattern, arch); ok {
+			return c.Arches.Get(pattern), nil
+		}
+	}
+	if c.Default != "" {
+		return c.Default, nil
+	}
+	return "", fmt.Errorf("no staging template for %q (and no default)", arch)
+}
+
+// returns the staging image for the given architecture and buildId ("oisupport/staging-amd64:xxxx") and the template that produced it
+func (c stagingConfig) img(arch, buildID string) (string, string, error) {
+	template, err := c.template(arch)
+	if err != nil {
+		return "", "", err
+	}
+	return expandStagingTemplate(template, arch, buildID), template, nil
+}
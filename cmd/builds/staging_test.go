@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStagingConfig(t *testing.T) {
+	const buildID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	t.Run("template only", func(t *testing.T) {
+		t.Setenv("BASHBREW_STAGING_CONFIG", "")
+		t.Setenv("BASHBREW_STAGING_TEMPLATE", "oisupport/staging-ARCH:BUILD")
+		config, err := loadStagingConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.structured {
+			t.Error("expected BASHBREW_STAGING_TEMPLATE alone to not be structured")
+		}
+		img, template, err := config.img("arm64v8", buildID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "oisupport/staging-arm64v8:" + buildID; img != want || template != "oisupport/staging-ARCH:BUILD" {
+			t.Errorf("expected %q, got %q (from %q)", want, img, template)
+		}
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		t.Setenv("BASHBREW_STAGING_TEMPLATE", "oisupport/staging-ARCH:BUILD")
+		t.Setenv("BASHBREW_STAGING_CONFIG", `{
+			"arches": {
+				"windows-*": "example.azurecr.io/staging-ARCH:BUILD",
+				"windows-amd64": "example.azurecr.io/windows:BUILD",
+				"*64*": "registry.example.com/staging-ARCH:BUILD"
+			}
+		}`)
+		config, err := loadStagingConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !config.structured {
+			t.Error("expected BASHBREW_STAGING_CONFIG to be structured")
+		}
+		for arch, want := range map[string]string{
+			"windows-amd64": "example.azurecr.io/windows:BUILD",        // exact match wins over earlier patterns
+			"windows-arm64": "example.azurecr.io/staging-ARCH:BUILD",   // first matching pattern wins
+			"riscv64":       "registry.example.com/staging-ARCH:BUILD", // later pattern
+			"i386":          "oisupport/staging-ARCH:BUILD",            // falls back to BASHBREW_STAGING_TEMPLATE
+		} {
+			if got, err := config.template(arch); err != nil || got != want {
+				t.Errorf("%s: expected %q, got %q (%v)", arch, want, got, err)
+			}
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "staging.json")
+		if err := os.WriteFile(file, []byte(`{"default":"example.com/staging:ARCH-BUILD","arches":{"riscv64":"example.com/riscv:BUILD"}}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("BASHBREW_STAGING_CONFIG", file)
+		t.Setenv("BASHBREW_STAGING_TEMPLATE", "oisupport/staging-ARCH:BUILD")
+		config, err := loadStagingConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, _, err := config.img("amd64", buildID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "example.com/staging:amd64-" + buildID; img != want {
+			t.Errorf("expected %q (config default over BASHBREW_STAGING_TEMPLATE), got %q", want, img)
+		}
+	})
+
+	t.Run("no default", func(t *testing.T) {
+		t.Setenv("BASHBREW_STAGING_TEMPLATE", "")
+		t.Setenv("BASHBREW_STAGING_CONFIG", `{"arches":{"amd64":"example.com/staging:BUILD"}}`)
+		config, err := loadStagingConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := config.img("arm64v8", buildID); err == nil || !strings.Contains(err.Error(), "no staging template") {
+			t.Errorf("expected missing template error, got %v", err)
+		}
+	})
+
+	for _, x := range []struct {
+		name     string
+		config   string
+		template string
+		wantErr  string
+	}{
+		{"nothing", "", "", "missing staging configuration"},
+		{"missing BUILD", "", "oisupport/staging-ARCH", "missing BUILD"},
+		{"digest", "", "oisupport/staging@sha256:BUILD", "by tag, not digest"},
+		{"invalid ref", "", "Oisupport/staging-ARCH:BUILD", "Oisupport"},
+		{"bad pattern", `{"arches":{"[amd64":"example.com/staging:BUILD"}}`, "", "invalid staging architecture pattern"},
+		{"bad arch template", `{"arches":{"amd64":"example.com/staging:latest"}}`, "oisupport/staging-ARCH:BUILD", `invalid staging template for "amd64"`},
+		{"unknown field", `{"defualt":"example.com/staging:BUILD"}`, "", "unknown field"},
+		{"missing file", "/nonexistent/staging.json", "", "BASHBREW_STAGING_CONFIG"},
+	} {
+		x := x // https://github.com/golang/go/issues/60078
+		t.Run(x.name, func(t *testing.T) {
+			t.Setenv("BASHBREW_STAGING_CONFIG", x.config)
+			t.Setenv("BASHBREW_STAGING_TEMPLATE", x.template)
+			if _, err := loadStagingConfig(); err == nil || !strings.Contains(err.Error(), x.wantErr) {
+				t.Fatalf("expected error %q, got %v", x.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/docker-library/meta-scripts/deploy"
+)
+
+// the equivalent of `jq -L. 'include "deploy"; arch_tagged_manifests($arch) | deploy_objects[]' builds.json` (but as an [io.Reader] of a stream of JSON documents, just like our normal stdin input)
+func deployObjectsFromBuilds(buildsFile, arch string) (*bytes.Buffer, error) {
+	b, err := os.ReadFile(buildsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	builds, err := deploy.ParseBuilds(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to parse: %w", buildsFile, err)
+	}
+
+	tagged, err := deploy.ArchTaggedManifests(builds, arch)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", buildsFile, err)
+	}
+
+	objects, err := deploy.Objects(tagged)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", buildsFile, err)
+	}
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, obj := range objects {
+		if err := enc.Encode(obj); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/docker-library/meta-scripts/jq"
+)
+
+func TestDeployObjectsFromBuilds(t *testing.T) {
+	// "--from-builds" should result in exactly the same normalized inputs as the output of "deploy.jq" does
+	normalizeAll := func(t *testing.T, r io.Reader) []inputNormalized {
+		t.Helper()
+		var ret []inputNormalized
+		dec := json.NewDecoder(r)
+		for dec.More() {
+			var raw inputRaw
+			if err := dec.Decode(&raw); err != nil {
+				t.Fatal(err)
+			}
+			data, err := jq.Tab(raw.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw.Data = data
+			normal, err := NormalizeInput(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ret = append(ret, normal)
+		}
+		return ret
+	}
+
+	got, err := deployObjectsFromBuilds("../../.test/deploy-amd64/in.json", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "deploy.jq" output is an array, not a stream, so we need to pull it apart first
+	golden, err := os.ReadFile("../../.test/deploy-amd64/out.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var goldenObjects []json.RawMessage
+	if err := json.Unmarshal(golden, &goldenObjects); err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		for _, obj := range goldenObjects {
+			pw.Write(obj)
+		}
+		pw.Close()
+	}()
+
+	gotNormal := normalizeAll(t, got)
+	wantNormal := normalizeAll(t, pr)
+	if len(gotNormal) == 0 {
+		t.Fatal("no deploy objects?")
+	}
+	if !reflect.DeepEqual(gotNormal, wantNormal) {
+		t.Fatalf("--from-builds output does not match deploy.jq output")
+	}
+}
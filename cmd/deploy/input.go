@@ -6,10 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"maps"
+	"slices"
 
 	"github.com/docker-library/meta-scripts/registry"
 
 	"cuelabs.dev/go/oci/ociregistry"
+	"cuelabs.dev/go/oci/ociregistry/ociref"
 	godigest "github.com/opencontainers/go-digest"
 )
 
@@ -21,10 +23,13 @@ type deployType string
 const (
 	typeManifest deployType = "manifest"
 	typeBlob     deployType = "blob"
+
+	// a special case of copying a manifest: point one or more tags at whatever another tag (or digest) in the same repository points to (see "normalizeInputTag")
+	typeTag deployType = "tag"
 )
 
 type inputRaw struct {
-	// which type of thing we're pushing ("manifest" or "blob")
+	// which type of thing we're pushing ("manifest", "blob", or "tag")
 	Type deployType `json:"type"`
 
 	// where to push the thing ("jsmith/example:latest", "jsmith/example@sha256:xxx", etc)
@@ -35,6 +40,12 @@ type inputRaw struct {
 
 	// the data to push; if this is a JSON string, it is assumed to be a "raw" base64-encoded byte stream that should be pushed as-is, otherwise it'll be formatted and pushed as JSON (great for index, manifest, config, etc)
 	Data json.RawMessage `json:"data,omitempty"`
+
+	// (only for "tag") where the manifest we're retagging lives ("jsmith/example:1.2.3", "jsmith/example@sha256:xxx", etc); for other types, this is inferred from "lookup" instead (and thus ignored here so that normalized objects are still valid input)
+	CopyFrom string `json:"copyFrom,omitempty"`
+
+	// (only for "tag") the tags (in the same repository as "copyFrom") to point at it; these are combined with "refs" (which must also be in the same repository)
+	Tags []string `json:"tags,omitempty"`
 }
 
 // effectively, this is [inputRaw] but normalized in many ways (with inferred data like where to copy data from being explicit instead)
@@ -135,10 +146,17 @@ func NormalizeInput(raw inputRaw) (inputNormalized, error) {
 	case typeManifest, typeBlob:
 		normal.Type = raw.Type
 
+	case typeTag:
+		return normalizeInputTag(raw)
+
 	default:
 		return normal, fmt.Errorf("unknown type: %s", raw.Type)
 	}
 
+	if len(raw.Tags) > 0 {
+		return normal, fmt.Errorf("tags are only valid for type %q (use refs instead)", typeTag)
+	}
+
 	if raw.Refs == nil {
 		return normal, fmt.Errorf("missing refs entirely (JSON input glitch?)")
 	}
@@ -274,6 +292,76 @@ func NormalizeInput(raw inputRaw) (inputNormalized, error) {
 	return normal, nil
 }
 
+// "type": "tag" is deliberately much more limited than "manifest" (no data, no lookup, same repository only) so that the input says exactly what it means: resolve "copyFrom" once and push the identical manifest bytes to every tag
+func normalizeInputTag(raw inputRaw) (inputNormalized, error) {
+	normal := inputNormalized{Type: typeTag}
+
+	if raw.CopyFrom == "" {
+		return normal, fmt.Errorf("missing copyFrom (nothing to retag)")
+	}
+	from, err := registry.ParseRef(raw.CopyFrom)
+	if err != nil {
+		return normal, fmt.Errorf("%s: failed to parse copyFrom ref: %w", raw.CopyFrom, err)
+	}
+	if from.Tag == "" && from.Digest == "" {
+		return normal, fmt.Errorf("%s: copyFrom needs a tag or digest", from)
+	}
+	if !(raw.Data == nil || bytes.Equal(raw.Data, []byte("null"))) {
+		return normal, fmt.Errorf("%s: %s does not take data (use %s instead)", from, typeTag, typeManifest)
+	}
+	if len(raw.Lookup) > 0 {
+		// since we only retag within a single repository, every child already exists right next to the source
+		return normal, fmt.Errorf("%s: %s does not take lookup (use %s instead)", from, typeTag, typeManifest)
+	}
+
+	rawRefs := slices.Clone(raw.Refs)
+	for _, tag := range raw.Tags {
+		if !ociref.IsValidTag(tag) {
+			return normal, fmt.Errorf("%s: invalid tag: %q", from, tag)
+		}
+		ref := from
+		ref.Tag = tag
+		ref.Digest = ""
+		rawRefs = append(rawRefs, ref.String())
+	}
+	if len(rawRefs) == 0 {
+		return normal, fmt.Errorf("%s: zero tags specified for retagging (need at least one)", from)
+	}
+	var refsDigest ociregistry.Digest
+	normal.Refs, refsDigest, err = normalizeInputRefs(normal.Type, rawRefs)
+	if err != nil {
+		return normal, fmt.Errorf("%s: %w", from, err)
+	}
+	for _, ref := range normal.Refs {
+		if ref.Host != from.Host || ref.Repository != from.Repository {
+			return normal, fmt.Errorf("%s: %s must stay within the same repository: %s", from, typeTag, ref)
+		}
+		if ref.Tag == "" {
+			return normal, fmt.Errorf("%s: %s needs a tag: %s", from, typeTag, ref)
+		}
+	}
+
+	// digest pinning: if either side specifies a digest, that's exactly the object we'll push (and the tag we're copying *from* is no longer relevant information)
+	if refsDigest == "" {
+		refsDigest = from.Digest
+	} else if from.Digest != "" && from.Digest != refsDigest {
+		return normal, fmt.Errorf("%s: copy-by-digest mismatch: %s", from, refsDigest)
+	}
+	if refsDigest != "" {
+		from.Tag = ""
+		from.Digest = refsDigest
+		for i := range normal.Refs {
+			normal.Refs[i].Digest = refsDigest
+		}
+	}
+	normal.CopyFrom = &from
+
+	// "do" (and thus registry.CopyManifest) needs a non-nil map to write its fallback into
+	normal.Lookup = map[ociregistry.Digest]registry.Reference{}
+
+	return normal, nil
+}
+
 // WARNING: many of these codepaths will end up writing to "normal.Lookup", which because it's a map is passed by reference, so this method is *not* safe for concurrent invocation on a single "normal" object!  see "normal.clone" (above)
 func (normal inputNormalized) do(ctx context.Context, dstRef registry.Reference) (ociregistry.Descriptor, error) {
 	switch normal.Type {
@@ -285,6 +373,10 @@ func (normal inputNormalized) do(ctx context.Context, dstRef registry.Reference)
 			return registry.CopyManifest(ctx, *normal.CopyFrom, dstRef, normal.Lookup)
 		}
 
+	case typeTag:
+		// the source is resolved (and cached) once by the registry client, so every tag gets the identical manifest bytes
+		return registry.CopyManifest(ctx, *normal.CopyFrom, dstRef, normal.Lookup)
+
 	case typeBlob:
 		if normal.CopyFrom == nil {
 			return registry.EnsureBlob(ctx, dstRef, int64(len(normal.Data)), bytes.NewReader(normal.Data))
@@ -298,34 +390,45 @@ func (normal inputNormalized) do(ctx context.Context, dstRef registry.Reference)
 	}
 }
 
+// "do", but doesn't mutate state at all (and instead describes everything "do" would've done; see [registry.Plan])
+//
+// WARNING: just like "do", this is *not* safe for concurrent invocation on a single "normal" object
+func (normal inputNormalized) plan(ctx context.Context, dstRef registry.Reference) (registry.Plan, error) {
+	switch normal.Type {
+	case typeManifest:
+		if normal.CopyFrom == nil {
+			return registry.PlanEnsureManifest(ctx, dstRef, normal.Data, normal.MediaType, normal.Lookup)
+		} else {
+			return registry.PlanCopyManifest(ctx, *normal.CopyFrom, dstRef, normal.Lookup)
+		}
+
+	case typeTag:
+		return registry.PlanCopyManifest(ctx, *normal.CopyFrom, dstRef, normal.Lookup)
+
+	case typeBlob:
+		if normal.CopyFrom == nil {
+			return registry.PlanEnsureBlob(ctx, dstRef, int64(len(normal.Data)))
+		} else {
+			return registry.PlanCopyBlob(ctx, *normal.CopyFrom, dstRef)
+		}
+
+	default:
+		panic("unknown type: " + string(normal.Type))
+		// panic instead of error because this should've already been handled/normalized above (so this is a coding error, not a runtime error)
+	}
+}
+
 // "do", but doesn't mutate state at all (just tells us whether "do" would've done anything)
 func (normal inputNormalized) dryRun(ctx context.Context, dstRef registry.Reference) (bool, error) {
 	targetDigest := dstRef.Digest
 	var lookupType registry.LookupType
 	switch normal.Type {
-	case typeManifest:
+	case typeManifest, typeTag:
 		lookupType = registry.LookupTypeManifest
 		if targetDigest == "" {
 			// if we don't have a digest here, it must be because we're copying from tag to tag, so we'll just assume normal.CopyFrom is non-nil and let the runtime panic for us if the normalization above doesn't have our back
-			r, err := registry.Lookup(ctx, *normal.CopyFrom, &registry.LookupOptions{
-				Type: lookupType,
-				Head: true,
-			})
-			if err != nil {
-				return true, err
-			}
-			if r == nil {
-				return true, fmt.Errorf("%s: manifest-to-copy (%s) is 404", dstRef.String(), normal.CopyFrom.String())
-			}
-			targetDigest = r.Descriptor().Digest
-			r.Close()
-			if targetDigest == "" {
-				return true, fmt.Errorf("%s: manifest-to-copy (%s) is missing digest!", dstRef.String(), normal.CopyFrom.String())
-			}
-			if dstRef.Tag == "" {
-				// if we don't have an explicit destination tag, this is considered a request to copy-manifest-from-tag-but-push-by-digest, which is weird, but valid, so we need to copy up that digest into what we look for on the destination side
-				dstRef.Digest = targetDigest
-			}
+			_, upToDate, err := registry.CopyManifestUpToDate(ctx, *normal.CopyFrom, dstRef)
+			return !upToDate, err
 		}
 	case typeBlob:
 		lookupType = registry.LookupTypeBlob
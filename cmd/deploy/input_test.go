@@ -437,6 +437,44 @@ func TestNormalizeInput(t *testing.T) {
 			}`,
 			`{"type":"manifest","refs":["localhost:5000/foo@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d","localhost:5000/bar@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d","localhost:5000/baz@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d"],"lookup":{"":"tianon/true","sha256:25be82253336f0b8c4347bc4ecbbcdc85d0e0f118ccf8dc2e119c0a47a0a486e":"tianon/true","sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d":"tianon/true"},"copyFrom":"tianon/true@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d"}`,
 		},
+
+		{
+			"tag",
+			`{
+				"type": "tag",
+				"copyFrom": "localhost:5000/example:1.2.3",
+				"tags": [ "1.2", "1", "latest" ]
+			}`,
+			`{"type":"tag","refs":["localhost:5000/example:1.2","localhost:5000/example:1","localhost:5000/example:latest"],"copyFrom":"localhost:5000/example:1.2.3"}`,
+		},
+		{
+			"tag (Docker Hub)",
+			`{
+				"type": "tag",
+				"copyFrom": "hello-world:linux",
+				"refs": [ "docker.io/library/hello-world:latest" ]
+			}`,
+			`{"type":"tag","refs":["hello-world:latest"],"copyFrom":"hello-world:linux"}`,
+		},
+		{
+			"tag pinned",
+			`{
+				"type": "tag",
+				"copyFrom": "localhost:5000/example:1.2.3@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d",
+				"tags": [ "latest" ]
+			}`,
+			`{"type":"tag","refs":["localhost:5000/example:latest@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d"],"copyFrom":"localhost:5000/example@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d"}`,
+		},
+		{
+			"tag pinned via refs",
+			`{
+				"type": "tag",
+				"copyFrom": "localhost:5000/example:1.2.3",
+				"refs": [ "localhost:5000/example:latest@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d" ],
+				"tags": [ "1" ]
+			}`,
+			`{"type":"tag","refs":["localhost:5000/example:latest@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d","localhost:5000/example:1@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d"],"copyFrom":"localhost:5000/example@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d"}`,
+		},
 	} {
 		x := x // https://github.com/golang/go/issues/60078
 		t.Run(x.name, func(t *testing.T) {
@@ -478,3 +516,77 @@ func TestNormalizeInput(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeInputTagErrors(t *testing.T) {
+	for _, x := range []struct {
+		name    string
+		raw     string
+		wantErr string
+	}{
+		{
+			"missing copyFrom",
+			`{"type":"tag","tags":["latest"]}`,
+			"missing copyFrom",
+		},
+		{
+			"copyFrom without tag or digest",
+			`{"type":"tag","copyFrom":"localhost:5000/example","tags":["latest"]}`,
+			"copyFrom needs a tag or digest",
+		},
+		{
+			"no tags",
+			`{"type":"tag","copyFrom":"localhost:5000/example:1.2.3"}`,
+			"zero tags specified",
+		},
+		{
+			"invalid tag",
+			`{"type":"tag","copyFrom":"localhost:5000/example:1.2.3","tags":["not a tag"]}`,
+			"invalid tag:",
+		},
+		{
+			"other repository",
+			`{"type":"tag","copyFrom":"localhost:5000/example:1.2.3","refs":["localhost:5000/other:latest"]}`,
+			"must stay within the same repository",
+		},
+		{
+			"ref without tag",
+			`{"type":"tag","copyFrom":"localhost:5000/example:1.2.3","refs":["localhost:5000/example@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d"]}`,
+			"tag needs a tag",
+		},
+		{
+			"digest mismatch",
+			`{"type":"tag","copyFrom":"localhost:5000/example@sha256:25be82253336f0b8c4347bc4ecbbcdc85d0e0f118ccf8dc2e119c0a47a0a486e","refs":["localhost:5000/example:latest@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d"]}`,
+			"copy-by-digest mismatch",
+		},
+		{
+			"data",
+			`{"type":"tag","copyFrom":"localhost:5000/example:1.2.3","tags":["latest"],"data":{}}`,
+			"does not take data",
+		},
+		{
+			"lookup",
+			`{"type":"tag","copyFrom":"localhost:5000/example:1.2.3","tags":["latest"],"lookup":{"":"tianon/true"}}`,
+			"does not take lookup",
+		},
+		{
+			"tags on manifest",
+			`{"type":"manifest","refs":["localhost:5000/example"],"tags":["latest"],"lookup":{"":"tianon/true:latest"}}`,
+			"tags are only valid for type",
+		},
+	} {
+		x := x // https://github.com/golang/go/issues/60078
+		t.Run(x.name, func(t *testing.T) {
+			var raw inputRaw
+			if err := json.Unmarshal([]byte(x.raw), &raw); err != nil {
+				t.Fatalf("JSON parse error: %v", err)
+			}
+			_, err := NormalizeInput(raw)
+			if err == nil {
+				t.Fatalf("Expected error not returned: %s", x.wantErr)
+			}
+			if !strings.Contains(err.Error(), x.wantErr) {
+				t.Fatalf("Expected error doesn't match.\ngot:\n%q,\n\nexpected to contain:\n%q", err, x.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/docker-library/meta-scripts/registry"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// a set of RWMutex objects (keyed by "repo@digest") for synchronizing the pushing of "child" objects before their parents later in the list of documents
+// for every RWMutex, it will be *write*-locked during push, and *read*-locked during reading (which means we won't limit the parallelization of multiple parents after a given child is pushed, but we will stop parents from being pushed before their children)
+type childMutexes struct {
+	mutexes sync.Map
+}
+
+// the "repo@digest" key of the mutex for the given ref ("" if it doesn't have a digest, and thus doesn't have a mutex)
+func childMutexKey(ref registry.Reference) string {
+	if ref.Digest == "" {
+		return ""
+	}
+	ref.Tag = ""
+	return ref.String()
+}
+
+func (c *childMutexes) get(key string) *sync.RWMutex {
+	lock, _ := c.mutexes.LoadOrStore(key, &sync.RWMutex{})
+	return lock.(*sync.RWMutex)
+}
+
+// for every ref of "normal" (in order), write-lock its "repo@digest" right away, "prepare" the job for it (synchronously, so anything order-sensitive happens in input order), and then "run" it (inline or via "go") once it has read locks on everything it depends on
+func (c *childMutexes) schedule(normal inputNormalized, prepare func(ref registry.Reference) func(), run func(func())) {
+	// locks are per-digest, but refs might be 20 tags on the same digest, so we need to get one write lock per repo@digest and release it when the first tag completes, and every other tag needs a read lock
+	seenRefs := map[string]bool{}
+
+	for _, ref := range normal.Refs {
+		ref := ref // https://github.com/golang/go/issues/60078
+
+		// before parallelization, collect the pushing "child" mutex we need to lock for writing right away (but only for the first entry)
+		var (
+			mutex *sync.RWMutex
+			held  string
+		)
+		if key := childMutexKey(ref); key != "" && !seenRefs[key] {
+			seenRefs[key] = true
+			mutex = c.get(key)
+			// if we have a "child" mutex, lock it immediately so we don't create a race between inputs
+			mutex.Lock() // (this gets unlocked in the job below)
+			// this is sane to lock here because interdependent inputs are required to be in-order (children first), so if this hangs it's 100% a bug in the input order
+			held = key
+		}
+
+		job := prepare(ref)
+
+		run(func() {
+			if mutex != nil {
+				defer mutex.Unlock()
+			}
+			runlock := c.rlock(normal.readLockRefs(ref), held)
+			defer runlock()
+			job()
+		})
+	}
+}
+
+// read-lock the mutex of every ref in "refs" (except "held", which the caller already has write-locked, so trying to read-lock it too would deadlock), returning a function that releases them all again
+func (c *childMutexes) rlock(refs []registry.Reference, held string) func() {
+	seenChildren := map[string]bool{}
+	if held != "" {
+		seenChildren[held] = true
+	}
+	var locks []*sync.RWMutex
+	for _, lockRef := range refs {
+		key := childMutexKey(lockRef)
+		if key == "" || seenChildren[key] {
+			continue
+		}
+		seenChildren[key] = true
+		lock := c.get(key)
+		lock.RLock()
+		locks = append(locks, lock)
+	}
+	return func() {
+		for _, lock := range locks {
+			lock.RUnlock()
+		}
+	}
+}
+
+// every ref a job for "ref" needs to read-lock before it starts: the ref itself (if some other ref of the same input got the write lock first), any "children" of raw data we might still be in the process of pushing (from a previously parallel job), lookups, and whatever we're copying from
+func (normal inputNormalized) readLockRefs(ref registry.Reference) []registry.Reference {
+	refs := []registry.Reference{ref}
+
+	// if it's a raw data job we need to parse the raw data and see if any of the "children" are objects we're still in the process of pushing (from a previously parallel job)
+	if len(normal.Data) > 2 { // needs to at least be bigger than "{}" for us to care (anything else either doesn't have data or can't have children)
+		// explicitly ignoring errors because this might not actually be JSON (or even a manifest at all!); this is best-effort
+		// TODO optimize this by checking whether normal.Data matches "^\s*{.+}\s*$" first so we have some assurance it might work before we go further?
+		manifestChildren, _ := registry.ParseManifestChildren(normal.Data)
+		childDescs := []ocispec.Descriptor{}
+		childDescs = append(childDescs, manifestChildren.Manifests...)
+		if manifestChildren.Config != nil {
+			childDescs = append(childDescs, *manifestChildren.Config)
+		}
+		childDescs = append(childDescs, manifestChildren.Layers...)
+		for _, childDesc := range childDescs {
+			childRef := ref
+			childRef.Digest = childDesc.Digest
+			refs = append(refs, childRef)
+
+			// these read locks are cheap, so let's be aggressive with our "lookup" refs too
+			if lookupRef, ok := normal.Lookup[childDesc.Digest]; ok {
+				lookupRef.Digest = childDesc.Digest
+				refs = append(refs, lookupRef)
+			}
+			if fallbackRef, ok := normal.Lookup[""]; ok {
+				fallbackRef.Digest = childDesc.Digest
+				refs = append(refs, fallbackRef)
+			}
+		}
+	}
+	// we don't *know* that all the lookup references are children, but if any of them have an explicit digest, let's treat them as potential children too (which is fair, because they *are* explicit potential references that it's sane to make sure exist)
+	for digest, lookupRef := range normal.Lookup {
+		refs = append(refs, lookupRef)
+		if digest != lookupRef.Digest {
+			lookupRef.Digest = digest
+			refs = append(refs, lookupRef)
+		}
+	}
+	// if we're going to do a copy, we need to *also* include the artifact we're copying in our list (which for a digest-pinned "tag" is the very repo@digest we're writing, hence "held" in [childMutexes.rlock])
+	if normal.CopyFrom != nil {
+		refs = append(refs, *normal.CopyFrom)
+	}
+
+	return refs
+}
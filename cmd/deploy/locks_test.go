@@ -0,0 +1,136 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker-library/meta-scripts/registry"
+)
+
+func TestChildMutexesSchedule(t *testing.T) {
+	digest := "sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d"
+
+	for _, x := range []struct {
+		name string
+		raw  inputRaw
+	}{
+		{
+			// a digest-pinned retag copies from the very repo@digest it write-locks for its first tag
+			name: "tag copyFrom digest",
+			raw:  inputRaw{Type: typeTag, CopyFrom: "localhost:5000/foo@" + digest, Tags: []string{"bar", "baz"}},
+		},
+		{
+			name: "tag refs digest",
+			raw:  inputRaw{Type: typeTag, CopyFrom: "localhost:5000/foo:latest", Refs: []string{"localhost:5000/foo:bar@" + digest, "localhost:5000/foo:baz@" + digest}},
+		},
+		{
+			name: "manifest",
+			raw:  inputRaw{Type: typeManifest, Refs: []string{"localhost:5000/foo:bar@" + digest}, Lookup: map[string]string{"": "localhost:5000/foo"}},
+		},
+	} {
+		x := x // https://github.com/golang/go/issues/60078
+		for _, parallel := range []bool{false, true} {
+			parallel := parallel // https://github.com/golang/go/issues/60078
+			name := x.name
+			if parallel {
+				name += " (parallel)"
+			}
+			t.Run(name, func(t *testing.T) {
+				normal, err := NormalizeInput(x.raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var (
+					mutex sync.Mutex
+					ran   []registry.Reference
+					wg    sync.WaitGroup
+				)
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					locks := &childMutexes{}
+					locks.schedule(normal, func(ref registry.Reference) func() {
+						wg.Add(1)
+						return func() {
+							defer wg.Done()
+							mutex.Lock()
+							defer mutex.Unlock()
+							ran = append(ran, ref)
+						}
+					}, func(f func()) {
+						if parallel {
+							go f()
+						} else {
+							f()
+						}
+					})
+					wg.Wait()
+				}()
+
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("deadlock: jobs never finished")
+				}
+
+				if len(ran) != len(normal.Refs) {
+					t.Fatalf("expected %d jobs, got %d: %v", len(normal.Refs), len(ran), ran)
+				}
+				for _, ref := range normal.Refs {
+					if !slices.Contains(ran, ref) {
+						t.Errorf("job for %s never ran", ref)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestChildMutexesChildrenFirst(t *testing.T) {
+	// a parent whose data references a child pushed by an earlier (still running) input must wait for that child to finish
+	child := "sha256:1a51828d59323e0e02522c45652b6a7a44a032b464b06d574f067d2358b0e9f1"
+	childInput, err := NormalizeInput(inputRaw{Type: typeBlob, Refs: []string{"localhost:5000/foo@" + child}, Data: []byte(`"YnVmZnkgdGhlIHZhbXBpcmUgc2xheWVyCg=="`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parentInput, err := NormalizeInput(inputRaw{Type: typeManifest, Refs: []string{"localhost:5000/foo:latest"}, Data: []byte(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","schemaVersion":2,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"` + child + `","size":25},"layers":[]}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locks := &childMutexes{}
+	release := make(chan struct{})
+	childDone := false
+	parentDone := make(chan bool)
+	goRun := func(f func()) { go f() }
+
+	locks.schedule(childInput, func(registry.Reference) func() {
+		return func() {
+			<-release
+			childDone = true
+		}
+	}, goRun)
+	locks.schedule(parentInput, func(registry.Reference) func() {
+		return func() {
+			parentDone <- childDone
+		}
+	}, goRun)
+
+	select {
+	case <-parentDone:
+		t.Fatal("parent ran before its child was done")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case sawChild := <-parentDone:
+		if !sawChild {
+			t.Fatal("parent did not see its child as done")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock: parent never ran")
+	}
+}
@@ -4,14 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"os/signal"
 	"sync"
 
+	"github.com/docker-library/meta-scripts/jq"
 	"github.com/docker-library/meta-scripts/registry"
-
-	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 func main() {
@@ -26,6 +25,16 @@ func main() {
 
 		// --parallel
 		parallel bool
+
+		// --plan, --plan=json, --plan=text
+		planFormat string
+
+		// --validate
+		validate bool
+
+		// --from-builds builds.json --arch amd64
+		fromBuilds string
+		arch       string
 	)
 	for len(args) > 0 {
 		arg := args[0]
@@ -38,35 +47,77 @@ func main() {
 		case "--parallel":
 			parallel = true
 
+		case "--plan", "--plan=json":
+			planFormat = "json"
+
+		case "--plan=text":
+			planFormat = "text"
+
+		case "--validate":
+			validate = true
+
+		case "--from-builds", "--arch":
+			if len(args) < 1 {
+				panic("missing value for " + arg)
+			}
+			if arg == "--from-builds" {
+				fromBuilds = args[0]
+			} else {
+				arch = args[0]
+			}
+			args = args[1:]
+
 		default:
 			panic("unknown argument: " + arg)
 		}
 	}
 
+	if dryRun && planFormat != "" {
+		panic("--dry-run and --plan are mutually exclusive")
+	}
+	if validate && (dryRun || planFormat != "" || parallel) {
+		panic("--validate is mutually exclusive with --dry-run, --plan, and --parallel")
+	}
+	// "--plan" is effectively a more verbose "--dry-run" (the full child walk, but still without pushing anything)
+	ordered := dryRun || planFormat != ""
+
 	// TODO the best we can do on whether or not this actually updated tags is "yes, definitely (we had to copy some children)" and "maybe (we didn't have to copy any children)", but we should maybe still output those so we can trigger put-shared based on them (~immediately on "definitely" and with some medium delay on "maybe")
 
 	// see "input.go" and "inputRaw" for details on the expected JSON input format
 
-	// we pass through "jq" to pretty-print any JSON-form data fields with sane whitespace
-	jq := exec.Command("jq", "--tab", "del(.data), .data")
-	jq.Stdin = os.Stdin
-	jq.Stderr = os.Stderr
-
-	stdout, err := jq.StdoutPipe()
-	if err != nil {
-		panic(err)
+	var input io.Reader = os.Stdin
+	if fromBuilds != "" || arch != "" {
+		if fromBuilds == "" || arch == "" {
+			panic("--from-builds and --arch must be used together")
+		}
+		// instead of reading the output of "deploy.jq" on stdin, generate the equivalent ourselves (see the "deploy" package)
+		objects, err := deployObjectsFromBuilds(fromBuilds, arch)
+		if err != nil {
+			panic(err)
+		}
+		input = objects
 	}
-	if err := jq.Start(); err != nil {
-		panic(err)
+
+	if validate {
+		// normalize and cross-check every object in the input without ever touching a registry (and report *all* problems, not just the first)
+		problems := validateInputs(input)
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "❌ %d problem(s) found\n", len(problems))
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "✅ no problems found")
+		return
 	}
 
-	// a set of RWMutex objects for synchronizing the pushing of "child" objects before their parents later in the list of documents
-	// for every RWMutex, it will be *write*-locked during push, and *read*-locked during reading (which means we won't limit the parallelization of multiple parents after a given child is pushed, but we will stop parents from being pushed before their children)
-	childMutexes := sync.Map{}
+	// see "locks.go"
+	locks := &childMutexes{}
 	wg := sync.WaitGroup{}
 
 	var dryRunOuts chan chan []byte
-	if dryRun {
+	if ordered {
 		// we want to allow parallel, but want the output to be in-order so we resynchronize output with a channel of channels (technically this also limits parallelization, but hopefully this limit is generous enough that it doesn't matter)
 		dryRunOuts = make(chan chan []byte, 100000)
 
@@ -86,14 +137,19 @@ func main() {
 		}()
 	}
 
-	dec := json.NewDecoder(stdout)
+	dec := json.NewDecoder(input)
 	for dec.More() {
 		var raw inputRaw
 		if err := dec.Decode(&raw); err != nil {
 			panic(err)
 		}
-		if err := dec.Decode(&raw.Data); err != nil {
-			panic(err)
+		if raw.Data != nil {
+			// pretty-print any JSON-form data fields with sane whitespace (exactly the way "jq --tab" would, which is what the output of "deploy.jq" is expected to look like)
+			data, err := jq.Tab(raw.Data)
+			if err != nil {
+				panic(err)
+			}
+			raw.Data = data
 		}
 
 		normal, err := NormalizeInput(raw)
@@ -116,115 +172,46 @@ func main() {
 		successPrefix := "✅ "
 		failurePrefix := "❌ "
 
-		// locks are per-digest, but refs might be 20 tags on the same digest, so we need to get one write lock per repo@digest and release it when the first tag completes, and every other tag needs a read lock
-		seenRefs := map[string]bool{}
-
-		for _, ref := range normal.Refs {
-			ref := ref // https://github.com/golang/go/issues/60078
-
-			necessaryReadLockRefs := []registry.Reference{}
-
-			// before parallelization, collect the pushing "child" mutex we need to lock for writing right away (but only for the first entry)
-			var mutex *sync.RWMutex
-			if ref.Digest != "" {
-				lockRef := ref
-				lockRef.Tag = ""
-				lockRefStr := lockRef.String()
-				if seenRefs[lockRefStr] {
-					// if we've already seen this specific ref for this input, we need a read lock, not a write lock (since they're per-repo@digest)
-					necessaryReadLockRefs = append(necessaryReadLockRefs, lockRef)
-				} else {
-					seenRefs[lockRefStr] = true
-					lock, _ := childMutexes.LoadOrStore(lockRefStr, &sync.RWMutex{})
-					mutex = lock.(*sync.RWMutex)
-					// if we have a "child" mutex, lock it immediately so we don't create a race between inputs
-					mutex.Lock() // (this gets unlocked in the goroutine below)
-					// this is sane to lock here because interdependent inputs are required to be in-order (children first), so if this hangs it's 100% a bug in the input order
-				}
-			}
-
+		locks.schedule(normal, func(ref registry.Reference) func() {
 			// make a (deep) copy of "normal" so that we can use it in a goroutine ("normal.do" is not safe for concurrent invocation)
 			normal := normal.clone()
 
 			var dryRunOut chan []byte
-			if dryRun {
+			if ordered {
 				dryRunOut = make(chan []byte, 1)
 				dryRunOuts <- dryRunOut
 			}
 
 			wg.Add(1)
-			// (making a function instead of direct "go func() ..." so we can support the --parallel toggle)
-			f := func() {
+			return func() {
 				defer wg.Done()
 
-				if mutex != nil {
-					defer mutex.Unlock()
-				}
-
-				if dryRun {
+				if ordered {
 					defer close(dryRunOut)
 				}
 
-				// before we start this job (parallelized), if it's a raw data job we need to parse the raw data and see if any of the "children" are objects we're still in the process of pushing (from a previously parallel job)
-				if len(normal.Data) > 2 { // needs to at least be bigger than "{}" for us to care (anything else either doesn't have data or can't have children)
-					// explicitly ignoring errors because this might not actually be JSON (or even a manifest at all!); this is best-effort
-					// TODO optimize this by checking whether normal.Data matches "^\s*{.+}\s*$" first so we have some assurance it might work before we go further?
-					manifestChildren, _ := registry.ParseManifestChildren(normal.Data)
-					childDescs := []ocispec.Descriptor{}
-					childDescs = append(childDescs, manifestChildren.Manifests...)
-					if manifestChildren.Config != nil {
-						childDescs = append(childDescs, *manifestChildren.Config)
-					}
-					childDescs = append(childDescs, manifestChildren.Layers...)
-					for _, childDesc := range childDescs {
-						childRef := ref
-						childRef.Digest = childDesc.Digest
-						necessaryReadLockRefs = append(necessaryReadLockRefs, childRef)
-
-						// these read locks are cheap, so let's be aggressive with our "lookup" refs too
-						if lookupRef, ok := normal.Lookup[childDesc.Digest]; ok {
-							lookupRef.Digest = childDesc.Digest
-							necessaryReadLockRefs = append(necessaryReadLockRefs, lookupRef)
-						}
-						if fallbackRef, ok := normal.Lookup[""]; ok {
-							fallbackRef.Digest = childDesc.Digest
-							necessaryReadLockRefs = append(necessaryReadLockRefs, fallbackRef)
-						}
-					}
-				}
-				// we don't *know* that all the lookup references are children, but if any of them have an explicit digest, let's treat them as potential children too (which is fair, because they *are* explicit potential references that it's sane to make sure exist)
-				for digest, lookupRef := range normal.Lookup {
-					necessaryReadLockRefs = append(necessaryReadLockRefs, lookupRef)
-					if digest != lookupRef.Digest {
-						lookupRef.Digest = digest
-						necessaryReadLockRefs = append(necessaryReadLockRefs, lookupRef)
-					}
-				}
-				// if we're going to do a copy, we need to *also* include the artifact we're copying in our list
-				if normal.CopyFrom != nil {
-					necessaryReadLockRefs = append(necessaryReadLockRefs, *normal.CopyFrom)
-				}
-				// ok, we've built up a list, let's start grabbing (ro) mutexes
-				seenChildren := map[string]bool{}
-				for _, lockRef := range necessaryReadLockRefs {
-					lockRef.Tag = ""
-					if lockRef.Digest == "" {
-						continue
-					}
-					lockRefStr := lockRef.String()
-					if seenChildren[lockRefStr] {
-						continue
-					}
-					seenChildren[lockRefStr] = true
-					lock, _ := childMutexes.LoadOrStore(lockRefStr, &sync.RWMutex{})
-					lock.(*sync.RWMutex).RLock()
-					defer lock.(*sync.RWMutex).RUnlock()
-				}
-
 				logText := ref.StringWithKnownDigest(refsDigest) + logSuffix
 				fmt.Fprintln(os.Stderr, startedPrefix+logText)
 
-				if dryRun {
+				if planFormat != "" {
+					plan, err := normal.plan(ctx, ref)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "%s -- ERROR: %v\n", failurePrefix+ref.String()+logSuffix, err)
+						panic(err) // TODO exit in a more clean way (we can't use "os.Exit" because that causes *more* errors 😭)
+					}
+					var j []byte
+					if planFormat == "text" {
+						j = []byte(planText(plan))
+					} else {
+						j, err = json.MarshalIndent(plan, "", "\t")
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "%s -- JSON ERROR: %v\n", failurePrefix+ref.String()+logSuffix, err)
+							panic(err) // TODO exit in a more clean way (we can't use "os.Exit" because that causes *more* errors 😭)
+						}
+					}
+					dryRunOut <- j
+					fmt.Fprintln(os.Stderr, successPrefix+logText)
+				} else if dryRun {
 					needsDeploy, err := normal.dryRun(ctx, ref)
 					if err != nil {
 						fmt.Fprintf(os.Stderr, "%s -- ERROR: %v\n", failurePrefix+ref.String()+logSuffix, err)
@@ -257,15 +244,17 @@ func main() {
 					fmt.Fprintln(os.Stderr, successPrefix+logText)
 				}
 			}
+		}, func(f func()) {
+			// (a function instead of direct "go func() ..." so we can support the --parallel toggle)
 			if parallel {
 				go f()
 			} else {
 				f()
 			}
-		}
+		})
 	}
 
-	if dryRun {
+	if ordered {
 		close(dryRunOuts)
 	}
 
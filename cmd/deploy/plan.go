@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker-library/meta-scripts/registry"
+)
+
+// a human-readable version of [registry.Plan] (for "--plan=text")
+func planText(plan registry.Plan) string {
+	var b strings.Builder
+
+	if plan.Tag != nil {
+		b.WriteString(plan.Tag.Ref.String())
+		switch {
+		case plan.Tag.From == "":
+			fmt.Fprintf(&b, ": new tag -> %s\n", plan.Tag.To)
+		case plan.Tag.Moves():
+			fmt.Fprintf(&b, ": %s -> %s\n", plan.Tag.From, plan.Tag.To)
+		default:
+			fmt.Fprintf(&b, ": unchanged (%s)\n", plan.Tag.To)
+		}
+	} else {
+		b.WriteString(plan.Ref.String())
+		b.WriteString(":\n")
+	}
+
+	var walk func(obj registry.PlanObject, depth int)
+	walk = func(obj registry.PlanObject, depth int) {
+		b.WriteString(strings.Repeat("\t", depth))
+		fmt.Fprintf(&b, "%s %s (%d bytes): %s", obj.Type, obj.Ref.Digest, obj.Size, obj.Action)
+		if obj.From != nil {
+			fmt.Fprintf(&b, " from %s", obj.From.StringWithKnownDigest(obj.Ref.Digest))
+		}
+		b.WriteString("\n")
+		for _, child := range obj.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(plan.PlanObject, 1)
+
+	fmt.Fprintf(&b, "\tcross-registry copy: %d bytes", plan.CopyBytes())
+
+	return b.String()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/docker-library/meta-scripts/jq"
+	"github.com/docker-library/meta-scripts/registry"
+
+	"cuelabs.dev/go/oci/ociregistry"
+)
+
+// a single problem found by "validateInputs" (see "--validate")
+type validateProblem struct {
+	Index int // which object in the input stream (zero-based)
+	Err   error
+}
+
+func (p validateProblem) String() string {
+	return fmt.Sprintf("object %d: %v", p.Index, p.Err)
+}
+
+// where (and as what) an object gets pushed by the input stream
+type validatePushed struct {
+	index      int
+	deployType deployType
+	digest     ociregistry.Digest // (only used for tags)
+}
+
+// "--validate": read an entire stream of input objects, normalize every one of them, and check them for consistency against each other, returning every problem we find (without *ever* touching a registry)
+func validateInputs(input io.Reader) []validateProblem {
+	var (
+		problems []validateProblem
+		normals  []inputNormalized
+		indexes  []int // the stream index of each entry in "normals" (objects that fail to normalize are skipped)
+	)
+
+	dec := json.NewDecoder(input)
+	for i := 0; dec.More(); i++ {
+		var raw inputRaw
+		if err := dec.Decode(&raw); err != nil {
+			// a decode error means we've lost our place in the stream, so this is the end of the road
+			return append(problems, validateProblem{i, fmt.Errorf("failed to parse JSON: %w", err)})
+		}
+		if raw.Data != nil {
+			data, err := jq.Tab(raw.Data)
+			if err != nil {
+				problems = append(problems, validateProblem{i, err})
+				continue
+			}
+			raw.Data = data
+		}
+		normal, err := NormalizeInput(raw)
+		if err != nil {
+			problems = append(problems, validateProblem{i, err})
+			continue
+		}
+		normals = append(normals, normal)
+		indexes = append(indexes, i)
+	}
+
+	// every repository that this stream pushes anything to (so we know which lookups we can actually check)
+	repos := map[string]bool{}
+	for _, normal := range normals {
+		for _, ref := range normal.Refs {
+			repos[validateRepo(ref)] = true
+		}
+	}
+
+	var (
+		pushed  = map[string]validatePushed{}             // "repo@digest" => first object that pushes it
+		tagged  = map[string]validatePushed{}             // "repo:tag" => first object that tags it
+		digests = map[ociregistry.Digest]validatePushed{} // digest => first object that pushes it (for type consistency, regardless of repository)
+	)
+
+	for n, normal := range normals {
+		i := indexes[n]
+		problem := func(err error) {
+			problems = append(problems, validateProblem{i, err})
+		}
+		debugId := normal.Refs[0]
+		digest := normal.Refs[0].Digest
+		objType := normal.Type
+		if objType == typeTag {
+			objType = typeManifest
+		}
+
+		// checks whether "ref" (which must have a digest) is something this stream pushes (and thus has to push earlier, since inputs are required to be in order, children first), given the type we expect it to be
+		checkRef := func(what string, ref registry.Reference, expectedType deployType) {
+			if prev, ok := digests[ref.Digest]; ok && prev.deployType != expectedType {
+				problem(fmt.Errorf("%s: %s %s is a %s, but object %d pushes it as a %s", debugId, what, ref, expectedType, prev.index, prev.deployType))
+			}
+			if !repos[validateRepo(ref)] {
+				// not a repository we push to, so we can't know anything more about it without asking a registry
+				return
+			}
+			if _, ok := pushed[validateRef(ref)]; ok {
+				return
+			}
+			for m := n + 1; m < len(normals); m++ {
+				for _, later := range normals[m].Refs {
+					if validateRef(later) == validateRef(ref) {
+						problem(fmt.Errorf("%s: %s %s is not pushed until object %d (children must come first)", debugId, what, ref, indexes[m]))
+						return
+					}
+				}
+			}
+			problem(fmt.Errorf("%s: %s %s is never pushed", debugId, what, ref))
+		}
+
+		if digest != "" {
+			if prev, ok := digests[digest]; ok && prev.deployType != objType {
+				problem(fmt.Errorf("%s: pushed as a %s, but object %d pushes %s as a %s", debugId, objType, prev.index, digest, prev.deployType))
+			}
+		}
+
+		type child struct {
+			digest     ociregistry.Digest
+			deployType deployType
+		}
+		var childList []child
+		if normal.Type == typeManifest && normal.Data != nil {
+			children, err := registry.ParseManifestChildren(normal.Data)
+			if err != nil {
+				problem(fmt.Errorf("%s: failed to parse manifest children: %w", debugId, err))
+			}
+			for _, c := range children.Manifests {
+				childList = append(childList, child{c.Digest, typeManifest})
+			}
+			if children.Config != nil {
+				childList = append(childList, child{children.Config.Digest, typeBlob})
+			}
+			for _, c := range children.Layers {
+				childList = append(childList, child{c.Digest, typeBlob})
+			}
+		}
+
+		// (sorted so our output is stable)
+		lookupDigests := make([]ociregistry.Digest, 0, len(normal.Lookup))
+		for d := range normal.Lookup {
+			lookupDigests = append(lookupDigests, d)
+		}
+		slices.Sort(lookupDigests)
+		for _, d := range lookupDigests {
+			lookupRef := normal.Lookup[d]
+			if d == "" || (normal.CopyFrom != nil && normal.CopyFrom.Digest == d) || slices.ContainsFunc(childList, func(c child) bool { return c.digest == d }) {
+				// these all get checked below (where we know what type they should be)
+				continue
+			}
+			// lookups for things that aren't children aren't necessarily wrong, but if they point at one of our own repositories, they'd better exist
+			lookupRef.Tag = ""
+			lookupRef.Digest = d
+			if repos[validateRepo(lookupRef)] {
+				if _, ok := pushed[validateRef(lookupRef)]; !ok {
+					problem(fmt.Errorf("%s: lookup %s is never pushed (before this object)", debugId, lookupRef))
+				}
+			}
+		}
+
+		if normal.CopyFrom != nil && normal.CopyFrom.Digest != "" {
+			checkRef("copyFrom", *normal.CopyFrom, objType)
+		}
+
+		for _, c := range childList {
+			what := "child " + string(c.deployType)
+			if lookupRef, ok := normal.Lookup[c.digest]; ok {
+				lookupRef.Tag = ""
+				lookupRef.Digest = c.digest
+				checkRef(what, lookupRef, c.deployType)
+				continue
+			}
+			if fallbackRef, ok := normal.Lookup[""]; ok {
+				fallbackRef.Tag = ""
+				fallbackRef.Digest = c.digest
+				checkRef(what, fallbackRef, c.deployType)
+				continue
+			}
+			// no lookup at all means the child has to already be in every repository we're pushing to
+			for _, ref := range normal.Refs {
+				childRef := ref
+				childRef.Tag = ""
+				childRef.Digest = c.digest
+				if _, ok := pushed[validateRef(childRef)]; !ok {
+					problem(fmt.Errorf("%s: %s %s is not in lookup (and not pushed before this object)", debugId, what, childRef))
+				} else {
+					checkRef(what, childRef, c.deployType)
+				}
+			}
+		}
+
+		for _, ref := range normal.Refs {
+			if ref.Tag != "" {
+				tagRef := ref
+				tagRef.Digest = ""
+				key := tagRef.String()
+				if prev, ok := tagged[key]; ok && prev.index != i {
+					if prev.digest == "" || digest == "" {
+						problem(fmt.Errorf("%s: also targeted by object %d (and at least one of them does not have a known digest)", tagRef, prev.index))
+					} else if prev.digest != digest {
+						problem(fmt.Errorf("%s: conflicting digests: %s (object %d) vs %s", tagRef, prev.digest, prev.index, digest))
+					}
+				} else if !ok {
+					tagged[key] = validatePushed{index: i, deployType: objType, digest: digest}
+				}
+			}
+			if ref.Digest != "" {
+				key := validateRef(ref)
+				if _, ok := pushed[key]; !ok {
+					pushed[key] = validatePushed{index: i, deployType: objType}
+				}
+			}
+		}
+		if digest != "" {
+			if _, ok := digests[digest]; !ok {
+				digests[digest] = validatePushed{index: i, deployType: objType}
+			}
+		}
+	}
+
+	// normalization problems were found in an earlier pass, so put everything back in input order
+	slices.SortStableFunc(problems, func(a, b validateProblem) int {
+		return a.Index - b.Index
+	})
+
+	return problems
+}
+
+// "host/repo"
+func validateRepo(ref registry.Reference) string {
+	ref.Tag = ""
+	ref.Digest = ""
+	return ref.String()
+}
+
+// "host/repo@digest"
+func validateRef(ref registry.Reference) string {
+	ref.Tag = ""
+	return ref.String()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	godigest "github.com/opencontainers/go-digest"
+)
+
+func TestValidateInputsGolden(t *testing.T) {
+	// the output of "deploy.jq" should always be valid
+	for _, dir := range []string{"deploy-all", "deploy-amd64"} {
+		dir := dir // https://github.com/golang/go/issues/60078
+		t.Run(dir, func(t *testing.T) {
+			golden, err := os.ReadFile("../../.test/" + dir + "/out.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// "deploy.jq" output is an array, not a stream, so we need to pull it apart first
+			var objects []json.RawMessage
+			if err := json.Unmarshal(golden, &objects); err != nil {
+				t.Fatal(err)
+			}
+			if len(objects) == 0 {
+				t.Fatal("no objects to validate")
+			}
+			var stream bytes.Buffer
+			for _, obj := range objects {
+				stream.Write(obj)
+				stream.WriteByte('\n')
+			}
+			if problems := validateInputs(&stream); len(problems) != 0 {
+				t.Fatalf("unexpected problems: %v", problems)
+			}
+		})
+	}
+
+	// ... and so should our own equivalent of it
+	objects, err := deployObjectsFromBuilds("../../.test/deploy-amd64/in.json", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := validateInputs(objects); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+}
+
+func TestValidateInputs(t *testing.T) {
+	buffy := "sha256:1a51828d59323e0e02522c45652b6a7a44a032b464b06d574f067d2358b0e9f1" // "buffy the vampire slayer\n"
+	hello := godigest.FromString("hello\n")
+
+	input := strings.Join([]string{
+		// 0: a plain blob push (no problems)
+		`{"type":"blob","refs":["localhost:5000/example@` + buffy + `"],"data":"YnVmZnkgdGhlIHZhbXBpcmUgc2xheWVyCg=="}`,
+		// 1: the config is pushed above, but the layer is nowhere to be found
+		`{"type":"manifest","refs":["localhost:5000/example:image"],"data":{"mediaType":"application/vnd.oci.image.manifest.v1+json","schemaVersion":2,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"` + buffy + `","size":25},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"sha256:25be82253336f0b8c4347bc4ecbbcdc85d0e0f118ccf8dc2e119c0a47a0a486e","size":1}]}}`,
+		// 2+3: the same tag, two different objects
+		`{"type":"manifest","refs":["localhost:5000/example:latest"],"data":{"mediaType":"application/vnd.oci.image.index.v1+json","schemaVersion":2,"manifests":[]}}`,
+		`{"type":"manifest","refs":["localhost:5000/example:latest"],"data":{"mediaType":"application/vnd.oci.image.index.v1+json","schemaVersion":2,"manifests":[],"annotations":{"foo":"bar"}}}`,
+		// 4: copying something from our own repository that nothing pushes
+		`{"type":"manifest","refs":["localhost:5000/example:copy@sha256:9ef42f1d602fb423fad935aac1caa0cfdbce1ad7edce64d080a4eb7b13f7cd9d"],"lookup":{"":"localhost:5000/example"}}`,
+		// 5: a "manifest" child that is actually the blob from object 0
+		`{"type":"manifest","refs":["localhost:5000/example:mismatch"],"lookup":{"":"localhost:5000/example"},"data":{"mediaType":"application/vnd.oci.image.index.v1+json","schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"` + buffy + `","size":25}]}}`,
+		// 6: not even valid on its own
+		`{"refs":["localhost:5000/example"]}`,
+		// 7+8: out of order (the copy needs the push to happen first)
+		`{"type":"blob","refs":["localhost:5000/other@` + hello.String() + `"],"lookup":{"":"localhost:5000/example"}}`,
+		`{"type":"blob","refs":["localhost:5000/example@` + hello.String() + `"],"data":"aGVsbG8K"}`,
+	}, "\n")
+
+	want := []struct {
+		index int
+		err   string
+	}{
+		{1, "is not in lookup"},
+		{3, "conflicting digests"},
+		{4, "is never pushed"},
+		{5, "is a manifest, but object 0 pushes it as a blob"},
+		{6, "missing type"},
+		{7, "is not pushed until object 8"},
+	}
+
+	problems := validateInputs(strings.NewReader(input))
+	if len(problems) != len(want) {
+		t.Fatalf("expected %d problems, got %d: %v", len(want), len(problems), problems)
+	}
+	for i, w := range want {
+		if p := problems[i]; p.Index != w.index || !strings.Contains(p.Err.Error(), w.err) {
+			t.Errorf("expected problem %d to be object %d containing %q, got: %s", i, w.index, w.err, p)
+		}
+	}
+}
+
+func TestValidateInputsBadJSON(t *testing.T) {
+	problems := validateInputs(strings.NewReader(`{"type":"blob"} {`))
+	if len(problems) == 0 {
+		t.Fatal("expected problems")
+	}
+	if last := problems[len(problems)-1]; last.Index != 1 || !strings.Contains(last.Err.Error(), "failed to parse JSON") {
+		t.Errorf("unexpected final problem: %s", last)
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"sync"
 
 	"github.com/docker-library/meta-scripts/registry"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 func main() {
@@ -24,6 +26,11 @@ func main() {
 
 	args := os.Args[1:]
 
+	var (
+		platform *ocispec.Platform
+		inspect  bool
+	)
+
 	var (
 		parallel = false
 		wg       sync.WaitGroup
@@ -44,18 +51,97 @@ func main() {
 		case "--head":
 			opts.Head = true
 			continue
+		case "--inspect":
+			inspect = true
+			continue
+		case "--diff":
+			// "--diff refA refB" compares two (synthesized) indexes, architecture by architecture (see "registry.DiffIndex")
+			if len(args) < 2 {
+				panic("--diff requires two references")
+			}
+			if opts != zeroOpts || platform != nil || inspect {
+				panic("--diff cannot be combined with --type, --head, --platform, etc")
+			}
+			refs := args[:2]
+			args = args[2:]
+			diff := func() {
+				var indexes [2]*ocispec.Index
+				for i, img := range refs {
+					ref, err := registry.ParseRef(img)
+					if err != nil {
+						panic(err)
+					}
+					// a missing reference is just an empty index (so a new or deleted tag is a diff of "everything added" or "everything removed")
+					indexes[i], err = registry.SynthesizeIndex(ctx, ref, nil)
+					if err != nil {
+						panic(err)
+					}
+				}
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "\t")
+				if err := e.Encode(registry.DiffIndex(indexes[0], indexes[1])); err != nil {
+					panic(err)
+				}
+			}
+			if parallel {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					diff()
+				}()
+			} else {
+				diff()
+			}
+			continue
+		case "--platform":
+			// either a bashbrew architecture ("arm64v8") or an OCI platform ("linux/arm64/v8")
+			if len(args) < 1 {
+				panic("--platform requires a value")
+			}
+			p, err := registry.ParsePlatform(args[0])
+			if err != nil {
+				panic(err)
+			}
+			platform = &p
+			args = args[1:]
+			continue
 		}
 
-		do := func(opts registry.LookupOptions) {
+		do := func(opts registry.LookupOptions, platform *ocispec.Platform, inspect bool) {
 			ref, err := registry.ParseRef(img)
 			if err != nil {
 				panic(err)
 			}
 
 			var obj any
-			if opts == zeroOpts {
+			if platform != nil || inspect {
+				if opts != zeroOpts {
+					panic("--platform and --inspect cannot be combined with --type, --head, etc")
+				}
+				if platform != nil {
+					// returns just the single best matching image descriptor (or null)
+					desc, err := registry.ResolvePlatform(ctx, ref, *platform)
+					if err != nil {
+						panic(err)
+					}
+					if desc != nil {
+						obj = desc
+						ref.Digest = desc.Digest // (for --inspect)
+					} else {
+						inspect = false // nothing to inspect
+					}
+				}
+				if inspect {
+					// manifest, config (history, env, labels, etc), and compressed/uncompressed sizes (or null)
+					inspection, err := registry.InspectImage(ctx, ref)
+					if err != nil {
+						panic(err)
+					}
+					obj = inspection
+				}
+			} else if opts == zeroOpts {
 				// if we have no explicit type and didn't request a HEAD, invoke SynthesizeIndex instead of Lookup
-				obj, err = registry.SynthesizeIndex(ctx, ref)
+				obj, err = registry.SynthesizeIndex(ctx, ref, nil)
 				if err != nil {
 					panic(err)
 				}
@@ -99,21 +185,23 @@ func main() {
 
 		if parallel {
 			wg.Add(1)
-			go func(opts registry.LookupOptions) {
+			go func(opts registry.LookupOptions, platform *ocispec.Platform, inspect bool) {
 				defer wg.Done()
 				// TODO synchronize output so that it still arrives in-order?  maybe the randomness is part of the charm?
-				do(opts)
-			}(opts)
+				do(opts, platform, inspect)
+			}(opts, platform, inspect)
 		} else {
-			do(opts)
+			do(opts, platform, inspect)
 		}
 
 		// reset state
 		opts = zeroOpts
+		platform = nil
+		inspect = false
 	}
 
-	if opts != zeroOpts {
-		panic("dangling --type, --head, etc (without a following reference for it to apply to)")
+	if opts != zeroOpts || platform != nil || inspect {
+		panic("dangling --type, --head, --platform, --inspect, etc (without a following reference for it to apply to)")
 	}
 
 	if parallel {
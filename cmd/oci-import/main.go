@@ -0,0 +1,42 @@
+package main
+
+// a Go version of "helpers/oci-import.sh" (see "ociimport")
+
+// usage:
+//  .../oci-import temp <<<'{"buildId":"...","build":{...},"source":{"entries":[{"Builder":"oci-import","GitCommit":...},...],...}}'
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/docker-library/meta-scripts/ociimport"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: oci-import <target-directory> < build.json")
+		os.Exit(2)
+	}
+	target := os.Args[1] // target directory to put OCI layout into (must not exist!)
+
+	// stdin: JSON of the full "builds.json" object
+	var build ociimport.Build
+	dec := json.NewDecoder(os.Stdin)
+	if err := dec.Decode(&build); err != nil {
+		panic(err)
+	}
+	if dec.More() {
+		panic("expected exactly one build object on stdin")
+	}
+
+	if err := ociimport.Import(ctx, build, target, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
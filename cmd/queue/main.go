@@ -0,0 +1,142 @@
+package main
+
+// the equivalent of the "Queue" stage of "Jenkinsfile.trigger" (see "get_arch_queue", "jobs_record", and "filter_skips_queue" in "jenkins.jq" and the "queue" package), outputting the same three lines: the filtered queue, the new jobs record (to become "past-jobs.json"), and the number of skipped builds
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker-library/meta-scripts/om"
+	"github.com/docker-library/meta-scripts/queue"
+)
+
+func main() {
+	var (
+		args = os.Args[1:]
+
+		// --arch amd64
+		arch = os.Getenv("BASHBREW_ARCH")
+
+		// --past-jobs past-jobs.json
+		pastJobsFile string
+
+		// --now 1700000000 (unix seconds; mostly useful for testing)
+		now = time.Now()
+
+		// --backoff 0,0,1h,2h,4h,8h,16h,32h
+		backoff = queue.DefaultBackoff
+
+		buildsFile string
+	)
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: queue [--arch arch] [--past-jobs past-jobs.json] [--now seconds] [--backoff 0,0,1h,...] builds.json")
+		os.Exit(2)
+	}
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+
+		var val string
+		if flag, v, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(flag, "--") {
+			arg, val = flag, v
+		} else if strings.HasPrefix(arg, "--") {
+			if len(args) < 1 {
+				usage()
+			}
+			val = args[0]
+			args = args[1:]
+		}
+
+		switch arg {
+		case "--arch":
+			arch = val
+
+		case "--past-jobs":
+			pastJobsFile = val
+
+		case "--now":
+			seconds, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid --now %q: %v\n", val, err)
+				os.Exit(2)
+			}
+			now = time.Unix(0, int64(seconds*float64(time.Second)))
+
+		case "--backoff":
+			backoff = nil
+			for _, s := range strings.Split(val, ",") {
+				d, err := time.ParseDuration(s)
+				if err != nil || d < 0 {
+					fmt.Fprintf(os.Stderr, "error: invalid --backoff %q: %q\n", val, s)
+					os.Exit(2)
+				}
+				backoff = append(backoff, d)
+			}
+
+		default:
+			if strings.HasPrefix(arg, "--") || buildsFile != "" {
+				usage()
+			}
+			buildsFile = arg
+		}
+	}
+	if buildsFile == "" || arch == "" {
+		usage()
+	}
+
+	b, err := os.ReadFile(buildsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	builds, err := queue.ParseBuilds(b)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s: failed to parse: %v\n", buildsFile, err)
+		os.Exit(1)
+	}
+
+	var pastJobs om.OrderedMap[queue.Job]
+	if pastJobsFile != "" {
+		b, err := os.ReadFile(pastJobsFile)
+		if os.IsNotExist(err) {
+			// (no record yet, so every build is a first attempt)
+			fmt.Fprintf(os.Stderr, "NOTE: %s does not exist; assuming no past jobs\n", pastJobsFile)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		} else if err := json.Unmarshal(b, &pastJobs); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: failed to parse: %v\n", pastJobsFile, err)
+			os.Exit(1)
+		}
+	}
+
+	archQueue, err := queue.ArchQueue(builds, arch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	jobs, err := queue.JobsRecord(archQueue, pastJobs, now, backoff)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	filtered, err := queue.FilterSkipsQueue(archQueue, jobs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// one compact document per line, just like "jq --compact-output" (which is what "Jenkinsfile.trigger" splits on)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for _, v := range []any{filtered, jobs, len(archQueue.Keys()) - len(filtered)} {
+		if err := enc.Encode(v); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+// a Go version of "helpers/oci-validate.sh" that works on both OCI image layouts and registry references (see "ocivalidate")
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/docker-library/meta-scripts/ocivalidate"
+	"github.com/docker-library/meta-scripts/registry"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var (
+		opts    ocivalidate.Options
+		jsonOut = false
+	)
+
+	args := os.Args[1:]
+flags:
+	for len(args) > 0 {
+		switch args[0] {
+		case "--json":
+			// one JSON object per validation failure ("ocivalidate.Error")
+			jsonOut = true
+		case "--skip-layers":
+			opts.SkipLayerContent = true
+		default:
+			break flags
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: validate [--json] [--skip-layers] <oci-layout-dir | oci:/path/to/layout[:tag] | registry/repo:tag[@digest]> [...]")
+		os.Exit(2)
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	failed := false
+	for _, arg := range args {
+		var err error
+		if fi, statErr := os.Stat(arg); statErr == nil && fi.IsDir() {
+			// a plain directory is an OCI image layout
+			err = ocivalidate.ValidateLayout(ctx, arg, &opts)
+		} else {
+			ref, parseErr := registry.ParseRef(arg)
+			if parseErr != nil {
+				panic(parseErr)
+			}
+			err = ocivalidate.Validate(ctx, ref, &opts)
+		}
+
+		var errs ocivalidate.Errors
+		if errors.As(err, &errs) {
+			failed = true
+			for _, valErr := range errs {
+				if jsonOut {
+					if err := e.Encode(valErr); err != nil {
+						panic(err)
+					}
+				} else {
+					fmt.Println(valErr.Error())
+				}
+			}
+			fmt.Fprintf(os.Stderr, "❌ %s: %d problem(s) found\n", arg, len(errs))
+		} else if err != nil {
+			panic(err)
+		} else {
+			fmt.Fprintf(os.Stderr, "✅ %s\n", arg)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,224 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker-library/meta-scripts/jq"
+	"github.com/docker-library/meta-scripts/om"
+	"github.com/docker-library/meta-scripts/registry"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// this is a port of "deploy.jq" (converting "builds.json" into input objects for "cmd/deploy"); the "oci.jq" bits it relies on live in the "jq" package
+
+// a generic JSON object (with preserved key ordering)
+type jsonObject = om.OrderedMap[json.RawMessage]
+
+// the subset of a "builds.json" entry that we need for generating deploy objects
+type Build struct {
+	Build struct {
+		Arch     string `json:"arch"`
+		Resolved *struct {
+			Manifests []jsonObject `json:"manifests"`
+		} `json:"resolved"`
+	} `json:"build"`
+	Source struct {
+		Arches map[string]struct {
+			Tags     []string `json:"tags"`
+			ArchTags []string `json:"archTags"`
+		} `json:"arches"`
+	} `json:"source"`
+}
+
+// parse a full "builds.json" document (preserving the order of the builds, which affects the order of our output)
+func ParseBuilds(b []byte) (om.OrderedMap[Build], error) {
+	var builds om.OrderedMap[Build]
+	err := json.Unmarshal(b, &builds)
+	return builds, err
+}
+
+// map of "tag" to list of (generic JSON) OCI descriptors, in the order the tags were first seen (see [TaggedManifests])
+type Tagged = om.OrderedMap[[]jsonObject]
+
+// port of "tagged_manifests" from "deploy.jq" (only builds that are resolved and match "selector" are included, and "tags" returns the list of tags a given build should be pushed to)
+func TaggedManifests(builds om.OrderedMap[Build], selector func(Build) bool, tags func(Build) []string) (Tagged, error) {
+	var tagged Tagged
+	for _, buildId := range builds.Keys() {
+		build := builds.Get(buildId)
+		if build.Build.Resolved == nil || !selector(build) {
+			continue
+		}
+
+		manifests := []jsonObject{}
+		for _, m := range build.Build.Resolved.Manifests {
+			// as an extra protection against cross-architecture "bleeding" ("riscv64" infra pushing "amd64" images, for example), filter the list of manifests to those whose architecture matches the architecture it is supposed to be for
+			// (see the longer comment in "deploy.jq" for why this matters even though "cmd/builds" *also* does this filtering)
+			annotations, err := getAnnotations(m)
+			if err != nil {
+				return tagged, fmt.Errorf("%s: %w", buildId, err)
+			}
+			if annotations[registry.AnnotationBashbrewArch] != build.Build.Arch {
+				continue
+			}
+			manifests = append(manifests, m)
+		}
+
+		for _, tag := range tags(build) {
+			tagged.Set(tag, append(tagged.Get(tag), manifests...))
+		}
+	}
+	return tagged, nil
+}
+
+// port of "arch_tagged_manifests" from "deploy.jq" (the arch-specific tags of every build of the given architecture)
+func ArchTaggedManifests(builds om.OrderedMap[Build], arch string) (Tagged, error) {
+	return TaggedManifests(builds, func(build Build) bool {
+		return build.Build.Arch == arch
+	}, func(build Build) []string {
+		return build.Source.Arches[build.Build.Arch].ArchTags
+	})
+}
+
+// an input object for "cmd/deploy" (see "inputRaw" over there for what each of these fields means); the field order here matches "deploy.jq" (which matters for "deploy.json" diffs / filtering)
+type Object struct {
+	Type   string                `json:"type"`
+	Refs   []string              `json:"refs"`
+	Lookup om.OrderedMap[string] `json:"lookup"`
+	Data   Index                 `json:"data"`
+}
+
+// a minimal [ocispec.Index] whose "manifests" preserve their exact (normalized) JSON
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []jsonObject `json:"manifests"`
+}
+
+// port of "deploy_objects" from "deploy.jq": every set of tags that have an identical list of manifests get combined into a single index (in the order the first tag of each set was seen)
+func Objects(tagged Tagged) ([]Object, error) {
+	var objects om.OrderedMap[*Object]
+	for _, ref := range tagged.Keys() {
+		manifests := []jsonObject{}
+		for _, m := range tagged.Get(ref) {
+			m, err := jq.NormalizeDescriptor(m) // normalized platforms *and* normalized field ordering
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", ref, err)
+			}
+			manifests = append(manifests, m)
+		}
+		manifests, err := jq.SortManifests(manifests)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ref, err)
+		}
+
+		digests := make([]string, len(manifests))
+		for i, m := range manifests {
+			digests[i], err = getString(m, "digest")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", ref, err)
+			}
+		}
+		key := strings.Join(digests, "\n")
+
+		if obj := objects.Get(key); obj != nil {
+			obj.Refs = append(obj.Refs, ref)
+			continue
+		}
+
+		obj := &Object{
+			Type: "manifest",
+			Refs: []string{ref},
+			Data: Index{
+				SchemaVersion: 2,
+				MediaType:     ocispec.MediaTypeImageIndex,
+				Manifests:     []jsonObject{},
+			},
+		}
+
+		if len(manifests) > 0 {
+			// if the first item in our list is a Docker media type, our list should probably be too (see also [registry.SynthesizeIndex])
+			if mediaType, err := getString(manifests[0], "mediaType"); err != nil {
+				return nil, fmt.Errorf("%s: %w", ref, err)
+			} else if mediaType == mediaTypeDockerImageManifest {
+				obj.Data.MediaType = mediaTypeDockerManifestList
+			}
+		}
+
+		for i, m := range manifests {
+			// add appropriate "lookup" values for copying child objects properly
+			annotations, err := getAnnotations(m)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", ref, err)
+			}
+			refName, ok := annotations[ocispec.AnnotationRefName]
+			if !ok {
+				return nil, fmt.Errorf("%s: %s: missing %q annotation (not from registry.SynthesizeIndex?)", ref, digests[i], ocispec.AnnotationRefName)
+			}
+			obj.Lookup.Set(digests[i], strings.TrimSuffix(refName, "@"+digests[i]))
+
+			// ... and then strip that annotation from the manifest in our index
+			var mAnnotations jsonObject
+			if err := json.Unmarshal(m.Get("annotations"), &mAnnotations); err != nil {
+				return nil, fmt.Errorf("%s: %s: failed to parse annotations: %w", ref, digests[i], err)
+			}
+			mAnnotations.Delete(ocispec.AnnotationRefName)
+			b, err := json.Marshal(mAnnotations)
+			if err != nil {
+				return nil, err
+			}
+			m = cloneObject(m) // (so we don't modify a descriptor that's potentially shared with another object)
+			m.Set("annotations", b)
+			obj.Data.Manifests = append(obj.Data.Manifests, m)
+		}
+
+		objects.Set(key, obj)
+	}
+
+	ret := make([]Object, 0, len(objects.Keys()))
+	for _, key := range objects.Keys() {
+		ret = append(ret, *objects.Get(key))
+	}
+	return ret, nil
+}
+
+// https://github.com/distribution/distribution/blob/v3.0.0/docs/content/spec/manifest-v2-2.md (see also the matching unexported constants in the "registry" package)
+const (
+	mediaTypeDockerManifestList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerImageManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// a shallow copy of the given object (such that modifying the keys of the copy does not modify the original)
+func cloneObject(obj jsonObject) jsonObject {
+	var ret jsonObject
+	for _, key := range obj.Keys() {
+		ret.Set(key, obj.Get(key))
+	}
+	return ret
+}
+
+// returns the value of the given key as a string (or the empty string if it is missing or null)
+func getString(obj jsonObject, key string) (string, error) {
+	var s *string
+	if raw := obj.Get(key); raw != nil {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", fmt.Errorf("failed to parse %q: %w", key, err)
+		}
+	}
+	if s == nil {
+		return "", nil
+	}
+	return *s, nil
+}
+
+func getAnnotations(obj jsonObject) (map[string]string, error) {
+	var annotations map[string]string
+	if raw := obj.Get("annotations"); raw != nil {
+		if err := json.Unmarshal(raw, &annotations); err != nil {
+			return nil, fmt.Errorf("failed to parse annotations: %w", err)
+		}
+	}
+	return annotations, nil
+}
@@ -0,0 +1,101 @@
+package deploy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/docker-library/meta-scripts/deploy"
+	"github.com/docker-library/meta-scripts/om"
+)
+
+// compare our output to the output of "deploy.jq" (see ".test/deploy-*/test.jq")
+func assertGolden(t *testing.T, objects []deploy.Object, goldenFile string) {
+	t.Helper()
+
+	golden, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	if err := json.Compact(&want, golden); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("output does not match %s\ngot:\n%s\n\nexpected:\n%s", goldenFile, got, want.Bytes())
+	}
+}
+
+func readBuilds(t *testing.T, file string) om.OrderedMap[deploy.Build] {
+	t.Helper()
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builds, err := deploy.ParseBuilds(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return builds
+}
+
+func TestObjectsAll(t *testing.T) {
+	builds := readBuilds(t, "../.test/deploy-all/in.json")
+
+	// tagged_manifests(true; .source.arches[.build.arch].tags, .source.arches[.build.arch].archTags)
+	tagged, err := deploy.TaggedManifests(builds, func(deploy.Build) bool {
+		return true
+	}, func(build deploy.Build) []string {
+		arch := build.Source.Arches[build.Build.Arch]
+		return append(append([]string{}, arch.Tags...), arch.ArchTags...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := deploy.Objects(tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, objects, "../.test/deploy-all/out.json")
+}
+
+func TestObjectsArch(t *testing.T) {
+	builds := readBuilds(t, "../.test/deploy-amd64/in.json")
+
+	tagged, err := deploy.ArchTaggedManifests(builds, "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := deploy.Objects(tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, objects, "../.test/deploy-amd64/out.json")
+}
+
+func TestArchBleeding(t *testing.T) {
+	// an "amd64" build whose resolved index somehow contains an "arm64v8" image should never deploy that "arm64v8" image (see ".test/deploy-arch-guard/test.jq")
+	builds := readBuilds(t, "../.test/deploy-arch-guard/in.json")
+
+	tagged, err := deploy.ArchTaggedManifests(builds, "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := deploy.Objects(tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, objects, "../.test/deploy-arch-guard/out.json")
+}
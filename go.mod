@@ -5,6 +5,7 @@ go 1.21
 
 require (
 	cuelabs.dev/go/oci/ociregistry v0.0.0-20240214163758-5ebe80b0a9a6
+	github.com/containerd/containerd v1.6.19
 	github.com/docker-library/bashbrew v0.1.11
 	github.com/opencontainers/go-digest v1.0.0
 	github.com/opencontainers/image-spec v1.1.0
@@ -12,7 +13,6 @@ require (
 )
 
 require (
-	github.com/containerd/containerd v1.6.19 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/sirupsen/logrus v1.9.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
@@ -0,0 +1,402 @@
+// Package jq holds byte-for-byte Go ports of the bits of our jq code (and of jq itself) that more than one of our tools relies on for exact output, so that none of them has to depend on another tool's package to get it.
+package jq
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker-library/meta-scripts/om"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// this file is a port of the bits of "oci.jq" (and "sort.jq") that "deploy.jq" and "helpers/oci-import.sh" rely on (see the "deploy" and "ociimport" packages) -- descriptors are handled as (ordered) generic JSON objects instead of [ocispec.Descriptor] so that the field ordering (and any fields we don't know about) round-trip exactly the same way they do in jq
+
+// a generic JSON object (with preserved key ordering)
+type jsonObject = om.OrderedMap[json.RawMessage]
+
+// https://github.com/opencontainers/image-spec/blob/v1.1.1/image-index.md#:~:text=generate%20an%20error.-,platform%20object,-This%20OPTIONAL%20property
+var defaultVariants = map[string]string{
+	// see "normalize_platform" in "oci.jq" for the links/notes that justify these
+	"arm/":   "v7",
+	"arm64/": "v8",
+}
+
+// port of "normalize_platform" from "oci.jq"
+func normalizePlatform(platform jsonObject) (jsonObject, error) {
+	arch, err := getString(platform, "architecture")
+	if err != nil {
+		return platform, err
+	}
+	variant, err := getString(platform, "variant")
+	if err != nil {
+		return platform, err
+	}
+	if def, ok := defaultVariants[arch+"/"+variant]; ok {
+		b, err := json.Marshal(def)
+		if err != nil {
+			return platform, err
+		}
+		platform.Set("variant", b)
+	}
+
+	platform = sortKeys(platform, []string{"os", "architecture", "variant", "os.version"}, nil)
+
+	// "map_values(select(.))" (drop any null/false values)
+	for _, key := range platform.Keys() {
+		switch string(platform.Get(key)) {
+		case "null", "false":
+			platform.Delete(key)
+		}
+	}
+
+	return platform, nil
+}
+
+// port of "normalize_descriptor" from "oci.jq"
+func NormalizeDescriptor(desc jsonObject) (jsonObject, error) {
+	desc = cloneObject(desc) // om.OrderedMap is a reference type, and we don't want to modify our input (which might be shared by multiple tags)
+
+	if raw := desc.Get("platform"); desc.Has("platform") && !isFalsy(raw) {
+		var platform jsonObject
+		if err := json.Unmarshal(raw, &platform); err != nil {
+			return desc, fmt.Errorf("failed to parse platform: %w", err)
+		}
+		platform, err := normalizePlatform(platform)
+		if err != nil {
+			return desc, err
+		}
+		b, err := json.Marshal(platform)
+		if err != nil {
+			return desc, err
+		}
+		desc.Set("platform", b)
+	}
+
+	if desc.Has("annotations") {
+		var annotations jsonObject
+		if err := json.Unmarshal(desc.Get("annotations"), &annotations); err != nil {
+			return desc, fmt.Errorf("failed to parse annotations: %w", err)
+		}
+		b, err := json.Marshal(sortKeys(annotations, nil, nil))
+		if err != nil {
+			return desc, err
+		}
+		desc.Set("annotations", b)
+	}
+
+	return sortKeys(desc, []string{
+		"mediaType",
+		"artifactType",
+		"digest",
+		"size",
+		"platform",
+		"annotations",
+	}, []string{
+		"urls",
+		"data",
+	}), nil
+}
+
+// port of "normalize_manifest" from "oci.jq" (works on both indexes and image manifests)
+func NormalizeManifest(manifest jsonObject) (jsonObject, error) {
+	manifest = cloneObject(manifest)
+
+	if manifest.Has("manifests") {
+		var manifests []jsonObject
+		if err := json.Unmarshal(manifest.Get("manifests"), &manifests); err != nil {
+			return manifest, fmt.Errorf("failed to parse manifests: %w", err)
+		}
+		for i := range manifests {
+			var err error
+			manifests[i], err = NormalizeDescriptor(manifests[i])
+			if err != nil {
+				return manifest, err
+			}
+		}
+		manifests, err := SortManifests(manifests)
+		if err != nil {
+			return manifest, err
+		}
+		b, err := json.Marshal(manifests)
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Set("manifests", b)
+	}
+
+	if manifest.Has("config") {
+		var config jsonObject
+		if err := json.Unmarshal(manifest.Get("config"), &config); err != nil {
+			return manifest, fmt.Errorf("failed to parse config: %w", err)
+		}
+		config, err := NormalizeDescriptor(config)
+		if err != nil {
+			return manifest, err
+		}
+		b, err := json.Marshal(config)
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Set("config", b)
+	}
+
+	if manifest.Has("layers") {
+		var layers []jsonObject
+		if err := json.Unmarshal(manifest.Get("layers"), &layers); err != nil {
+			return manifest, fmt.Errorf("failed to parse layers: %w", err)
+		}
+		for i := range layers {
+			var err error
+			layers[i], err = NormalizeDescriptor(layers[i])
+			if err != nil {
+				return manifest, err
+			}
+		}
+		b, err := json.Marshal(layers)
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Set("layers", b)
+	}
+
+	if manifest.Has("annotations") {
+		var annotations jsonObject
+		if err := json.Unmarshal(manifest.Get("annotations"), &annotations); err != nil {
+			return manifest, fmt.Errorf("failed to parse annotations: %w", err)
+		}
+		b, err := json.Marshal(sortKeys(annotations, nil, nil))
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Set("annotations", b)
+	}
+
+	return sortKeys(manifest, []string{
+		"schemaVersion",
+		"mediaType",
+		"artifactType",
+		"manifests",        // image index
+		"config", "layers", // image manifest
+	}, []string{
+		"subject",
+		"annotations",
+	}), nil
+}
+
+// port of "sort_manifests" from "oci.jq" (sort by platform, then make sure attestation manifests are next to their subject)
+func SortManifests(manifests []jsonObject) ([]jsonObject, error) {
+	type sortable struct {
+		desc jsonObject
+		key  any
+	}
+
+	items := make([]sortable, len(manifests))
+	for i, m := range manifests {
+		var platform ocispec.Platform
+		if raw := m.Get("platform"); !isFalsy(raw) {
+			if err := json.Unmarshal(raw, &platform); err != nil {
+				return nil, fmt.Errorf("failed to parse platform: %w", err)
+			}
+		}
+		items[i] = sortable{desc: m, key: sortSplitPlatform(platform)}
+	}
+	slices.SortStableFunc(items, func(a, b sortable) int {
+		return jqCompare(a.key, b.key)
+	})
+
+	// "sort_attestations"
+	digests := make([]string, len(items))
+	for i, item := range items {
+		dig, err := getString(item.desc, "digest")
+		if err != nil {
+			return nil, err
+		}
+		digests[i] = dig
+	}
+	for i := range items {
+		annotations, err := getAnnotations(items[i].desc)
+		if err != nil {
+			return nil, err
+		}
+		target, isAttestation := annotations["vnd.docker.reference.digest"]
+		if !isAttestation {
+			target = digests[i]
+		}
+		idx := slices.Index(digests, target)
+		if idx < 0 {
+			return nil, fmt.Errorf("%s: attestation subject %s not found", digests[i], target)
+		}
+		idx *= 2
+		if isAttestation {
+			idx++
+		}
+		items[i].key = float64(idx)
+	}
+	slices.SortStableFunc(items, func(a, b sortable) int {
+		return jqCompare(a.key, b.key)
+	})
+
+	ret := make([]jsonObject, len(items))
+	for i, item := range items {
+		ret[i] = item.desc
+	}
+	return ret, nil
+}
+
+// port of "sort_split_platform" from "oci.jq"
+func sortSplitPlatform(platform ocispec.Platform) any {
+	return []any{
+		sortSplitPref(platform.OS, []string{"linux"}, nil),
+		sortSplitPref(platform.Architecture, []string{"amd64", "arm64"}, nil),
+		sortSplitDesc(sortSplitNatural(platform.Variant)),
+		sortSplitDesc(sortSplitNatural(platform.OSVersion)),
+	}
+}
+
+// port of "_sort_by_key(sort_split_pref($top; $bottom))" (with a nil $top and $bottom, this is just sorting by key)
+func sortKeys(obj jsonObject, top, bottom []string) jsonObject {
+	keys := obj.Keys()
+	slices.SortStableFunc(keys, func(a, b string) int {
+		return jqCompare(sortSplitPref(a, top, bottom), sortSplitPref(b, top, bottom))
+	})
+	var ret jsonObject
+	for _, key := range keys {
+		ret.Set(key, obj.Get(key))
+	}
+	return ret
+}
+
+// port of "sort_split_pref" from "sort.jq"
+func sortSplitPref(o string, top, bottom []string) any {
+	if i := slices.Index(top, o); i >= 0 {
+		return []any{float64(i), o}
+	}
+	// items in $bottom get ($top | length) + 1 + index in $bottom, items in neither get ($top | length)
+	return []any{float64(len(top) + slices.Index(bottom, o) + 1), o}
+}
+
+var naturalRegex = regexp.MustCompile(`[0-9]+|[^0-9]+|^$`)
+
+// port of "sort_split_natural" from "sort.jq"
+func sortSplitNatural(s string) any {
+	ret := []any{}
+	for _, bit := range naturalRegex.FindAllString(s, -1) {
+		if n, err := strconv.ParseFloat(bit, 64); err == nil && bit[0] >= '0' && bit[0] <= '9' {
+			ret = append(ret, n)
+		} else {
+			ret = append(ret, bit)
+		}
+	}
+	return ret
+}
+
+// port of "sort_split_desc" from "sort.jq"
+func sortSplitDesc(v any) any {
+	switch v := v.(type) {
+	case float64:
+		return -v
+	case string:
+		// https://stackoverflow.com/a/74058663/433558
+		ret := []any{}
+		for _, r := range v {
+			ret = append(ret, -float64(r))
+		}
+		return append(ret, float64(0)) // the "0" here helps us with the empty string case; [ "a", "b", "c", "" ]
+	case []any:
+		ret := make([]any, len(v))
+		for i := range v {
+			ret[i] = sortSplitDesc(v[i])
+		}
+		return ret
+	default:
+		panic(fmt.Sprintf("cannot reverse sort type %T: %v", v, v))
+	}
+}
+
+// compares two values the way jq does (numbers < strings < arrays); only supports the types our "sort_split_*" functions generate
+func jqCompare(a, b any) int {
+	typeOrder := func(v any) int {
+		switch v.(type) {
+		case float64:
+			return 0
+		case string:
+			return 1
+		case []any:
+			return 2
+		default:
+			panic(fmt.Sprintf("unsupported type for comparison %T: %v", v, v))
+		}
+	}
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return ta - tb
+	}
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case []any:
+		b := b.([]any)
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if c := jqCompare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(b)
+	}
+	panic("unreachable")
+}
+
+// a shallow copy of the given object (such that modifying the keys of the copy does not modify the original)
+func cloneObject(obj jsonObject) jsonObject {
+	var ret jsonObject
+	for _, key := range obj.Keys() {
+		ret.Set(key, obj.Get(key))
+	}
+	return ret
+}
+
+// jq's definition of "falsy" is only null and false (which is also true of a missing key)
+func isFalsy(raw json.RawMessage) bool {
+	switch string(raw) {
+	case "", "null", "false":
+		return true
+	}
+	return false
+}
+
+// returns the value of the given key as a string (or the empty string if it is missing or null)
+func getString(obj jsonObject, key string) (string, error) {
+	var s *string
+	if raw := obj.Get(key); raw != nil {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", fmt.Errorf("failed to parse %q: %w", key, err)
+		}
+	}
+	if s == nil {
+		return "", nil
+	}
+	return *s, nil
+}
+
+func getAnnotations(obj jsonObject) (map[string]string, error) {
+	var annotations map[string]string
+	if raw := obj.Get("annotations"); raw != nil {
+		if err := json.Unmarshal(raw, &annotations); err != nil {
+			return nil, fmt.Errorf("failed to parse annotations: %w", err)
+		}
+	}
+	return annotations, nil
+}
@@ -0,0 +1,129 @@
+package jq
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func readGolden(t *testing.T, file string, v any) []byte {
+	t.Helper()
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != nil {
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, b); err != nil {
+		t.Fatal(err)
+	}
+	return compact.Bytes()
+}
+
+// see ".test/oci-sort-manifests/test.jq"
+func TestSortManifests(t *testing.T) {
+	var in []jsonObject
+	readGolden(t, "../.test/oci-sort-manifests/in.json", &in)
+	want := readGolden(t, "../.test/oci-sort-manifests/out.json", nil)
+
+	for i := range in {
+		var err error
+		in[i], err = NormalizeDescriptor(in[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := SortManifests(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", got, want)
+	}
+}
+
+// see ".test/oci-sort-platforms/test.jq"
+func TestSortPlatforms(t *testing.T) {
+	var platforms []jsonObject
+	want := readGolden(t, "../.test/oci-sort-platforms/out.json", &platforms)
+
+	// reverse the golden output so we have something to actually sort (and normalize it again, which should be a no-op)
+	slices.Reverse(platforms)
+	type sortable struct {
+		platform jsonObject
+		key      any
+	}
+	items := []sortable{}
+	for _, p := range platforms {
+		p, err := normalizePlatform(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var platform ocispec.Platform
+		if err := json.Unmarshal(b, &platform); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, sortable{platform: p, key: sortSplitPlatform(platform)})
+	}
+	slices.SortStableFunc(items, func(a, b sortable) int {
+		return jqCompare(a.key, b.key)
+	})
+	platforms = platforms[:0]
+	for _, item := range items {
+		platforms = append(platforms, item.platform)
+	}
+
+	got, err := json.Marshal(platforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", got, want)
+	}
+}
+
+func TestNormalizePlatform(t *testing.T) {
+	for _, x := range []struct {
+		in   string
+		want string
+	}{
+		{`{"architecture":"arm","os":"linux"}`, `{"os":"linux","architecture":"arm","variant":"v7"}`},
+		{`{"variant":null,"architecture":"arm64","os":"linux"}`, `{"os":"linux","architecture":"arm64","variant":"v8"}`},
+		{`{"variant":"v6","architecture":"arm","os":"linux"}`, `{"os":"linux","architecture":"arm","variant":"v6"}`},
+		{`{"os.version":"10.0.17763.5576","os":"windows","architecture":"amd64","variant":null}`, `{"os":"windows","architecture":"amd64","os.version":"10.0.17763.5576"}`},
+		{`{"zzz":false,"os.features":["win32k"],"os":"windows","architecture":"amd64"}`, `{"os":"windows","architecture":"amd64","os.features":["win32k"]}`},
+	} {
+		var in jsonObject
+		if err := json.Unmarshal([]byte(x.in), &in); err != nil {
+			t.Fatal(err)
+		}
+		out, err := normalizePlatform(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != x.want {
+			t.Errorf("normalizePlatform(%s)\ngot:\n%s\n\nexpected:\n%s", x.in, got, x.want)
+		}
+	}
+}
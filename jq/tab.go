@@ -0,0 +1,201 @@
+package jq
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/docker-library/meta-scripts/om"
+)
+
+// historically, "cmd/deploy" piped its input through "jq --tab" so that any JSON-form "data" was pretty-printed with sane whitespace (and thus pushed with the exact same bytes / digest as what "deploy.jq" users saw in "deploy.json"); this is a byte-for-byte compatible reimplementation of that output format so we no longer need jq at runtime (and so a jq upgrade can't silently change the digests of what we push); "ociimport" uses it too, for the exact bytes of the index "oci-import.sh" would write
+//
+// the returned bytes do *not* include the trailing newline jq would add (see the "normal.Data" newline handling in "NormalizeInput" in "cmd/deploy")
+func Tab(raw json.RawMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tabValue(&buf, raw, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func tabValue(buf *bytes.Buffer, raw json.RawMessage, depth int) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return fmt.Errorf("unexpected end of JSON input")
+	}
+
+	switch raw[0] {
+	case '{':
+		// jq objects keep the position of the first instance of a duplicated key, but the value of the last (which is exactly what om.OrderedMap does too)
+		var obj om.OrderedMap[json.RawMessage]
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return err
+		}
+		keys := obj.Keys()
+		if len(keys) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			tabIndent(buf, depth+1)
+			writeString(buf, key)
+			buf.WriteString(": ")
+			if err := tabValue(buf, obj.Get(key), depth+1); err != nil {
+				return err
+			}
+		}
+		tabIndent(buf, depth)
+		buf.WriteByte('}')
+
+	case '[':
+		var arr []json.RawMessage
+		if err := json.Unmarshal(raw, &arr); err != nil {
+			return err
+		}
+		if len(arr) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteByte('[')
+		for i, val := range arr {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			tabIndent(buf, depth+1)
+			if err := tabValue(buf, val, depth+1); err != nil {
+				return err
+			}
+		}
+		tabIndent(buf, depth)
+		buf.WriteByte(']')
+
+	case '"':
+		var str string
+		if err := json.Unmarshal(raw, &str); err != nil {
+			return err
+		}
+		writeString(buf, str)
+
+	case 't', 'f', 'n':
+		if !json.Valid(raw) {
+			return fmt.Errorf("invalid JSON literal: %q", string(raw))
+		}
+		buf.Write(raw)
+
+	default:
+		var num json.Number
+		if err := json.Unmarshal(raw, &num); err != nil {
+			return err
+		}
+		str, err := formatNumber(num)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(str)
+	}
+
+	return nil
+}
+
+func tabIndent(buf *bytes.Buffer, depth int) {
+	buf.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		buf.WriteByte('\t')
+	}
+}
+
+// https://github.com/jqlang/jq/blob/jq-1.6/src/jv_print.c#L67-L117 ("jvp_dump_string"); notably, jq does *not* escape "<", ">", "&", U+2028, or U+2029 like [json.Marshal] does
+func writeString(buf *bytes.Buffer, str string) {
+	buf.WriteByte('"')
+	for _, r := range str {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				// (invalid UTF-8 was already converted to U+FFFD by encoding/json, which is also what jq does)
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// https://github.com/jqlang/jq/blob/jq-1.6/src/jv_dtoa.c#L4219-L4279 ("jvp_dtoa_fmt"); jq parses every number as a double and then prints the shortest representation that round-trips, switching to exponent notation for very small or very large values
+func formatNumber(num json.Number) (string, error) {
+	f, err := strconv.ParseFloat(string(num), 64)
+	if err != nil {
+		var numErr *strconv.NumError
+		if !(errors.As(err, &numErr) && numErr.Err == strconv.ErrRange) {
+			return "", err
+		}
+		// jq normalizes infinities to DBL_MAX (and ParseFloat returns +/-Inf on overflow, which is what we want to clamp); underflow already returns (signed) zero
+	}
+	if math.IsInf(f, 1) {
+		f = math.MaxFloat64
+	} else if math.IsInf(f, -1) {
+		f = -math.MaxFloat64
+	}
+
+	// "-d.ddddde±XX" -> sign, digits, decimal point position
+	e := strconv.FormatFloat(f, 'e', -1, 64)
+	var sign string
+	if e[0] == '-' {
+		sign = "-"
+		e = e[1:]
+	}
+	mantissa, exp, _ := strings.Cut(e, "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	decpt, err := strconv.Atoi(exp)
+	if err != nil {
+		return "", err
+	}
+	decpt++ // "d.ddd" has the decimal point after the first digit
+
+	var b strings.Builder
+	b.WriteString(sign)
+	switch {
+	case decpt <= -4 || decpt > len(digits)+15:
+		b.WriteString(digits[:1])
+		if len(digits) > 1 {
+			b.WriteByte('.')
+			b.WriteString(digits[1:])
+		}
+		fmt.Fprintf(&b, "e%+03d", decpt-1)
+	case decpt <= 0:
+		b.WriteString("0.")
+		b.WriteString(strings.Repeat("0", -decpt))
+		b.WriteString(digits)
+	case decpt >= len(digits):
+		b.WriteString(digits)
+		b.WriteString(strings.Repeat("0", decpt-len(digits)))
+	default:
+		b.WriteString(digits[:decpt])
+		b.WriteByte('.')
+		b.WriteString(digits[decpt:])
+	}
+	return b.String(), nil
+}
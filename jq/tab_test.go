@@ -0,0 +1,149 @@
+package jq
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestTabGolden(t *testing.T) {
+	// every one of these files was generated by "jq --tab", so re-formatting them should be a no-op
+	for _, file := range []string{
+		"../.test/deploy-all/in.json",
+		"../.test/deploy-all/out.json",
+		"../.test/deploy-amd64/out.json",
+		"../.test/builds.json",
+		"../.test/provenance/out.json",
+		"../.test/oci-sort-manifests/out.json",
+	} {
+		file := file // https://github.com/golang/go/issues/60078
+		t.Run(file, func(t *testing.T) {
+			t.Parallel()
+
+			golden, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := Tab(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, '\n')
+			if !bytes.Equal(out, golden) {
+				t.Fatalf("Tab(%s) does not match jq output", file)
+			}
+
+			// and again, but compacted first (to make sure we're not just relying on the input whitespace)
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, golden); err != nil {
+				t.Fatal(err)
+			}
+			out, err = Tab(compact.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, '\n')
+			if !bytes.Equal(out, golden) {
+				t.Fatalf("Tab(compact %s) does not match jq output", file)
+			}
+		})
+	}
+}
+
+func TestTabGoldenData(t *testing.T) {
+	// the "data" of each deploy object, re-indented the way "jq --tab '.data'" would, should be exactly what was embedded in "deploy.jq" output (modulo indentation depth)
+	for _, file := range []string{
+		"../.test/deploy-all/out.json",
+		"../.test/deploy-amd64/out.json",
+	} {
+		golden, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var objs []struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(golden, &objs); err != nil {
+			t.Fatal(err)
+		}
+		if len(objs) == 0 {
+			t.Fatalf("%s: no deploy objects?", file)
+		}
+
+		for i, obj := range objs {
+			data, err := Tab(obj.Data)
+			if err != nil {
+				t.Fatalf("%s[%d]: %v", file, i, err)
+			}
+			// "data" lives two levels deep in the golden file (array -> object -> data)
+			nested := bytes.ReplaceAll(data, []byte("\n"), []byte("\n\t\t"))
+			if !bytes.Contains(golden, append([]byte("\t\t\"data\": "), nested...)) {
+				t.Errorf("%s[%d]: formatted data not found in golden file:\n%s", file, i, data)
+			}
+		}
+	}
+}
+
+func TestTab(t *testing.T) {
+	for _, x := range []struct {
+		in   string
+		want string
+	}{
+		// https://github.com/jqlang/jq/blob/jq-1.6/src/jv_print.c (see notes on "writeString")
+		{`"\u007f\u0000\u001f\b\f\n\r\t/<>& 😀é"`, "\"\\u007f\\u0000\\u001f\\b\\f\\n\\r\\t/<>& 😀é\""},
+		{`{"b":{},"c":[],"d":[{}],"e":null,"f":true,"g":false}`, "{\n\t\"b\": {},\n\t\"c\": [],\n\t\"d\": [\n\t\t{}\n\t],\n\t\"e\": null,\n\t\"f\": true,\n\t\"g\": false\n}"},
+		{`{"a":1,"b":2,"a":3}`, "{\n\t\"a\": 3,\n\t\"b\": 2\n}"},
+		{` "str" `, `"str"`},
+		{`null`, `null`},
+
+		// https://github.com/jqlang/jq/blob/jq-1.6/src/jv_dtoa.c (see notes on "formatNumber")
+		{`1.0`, `1`},
+		{`1.5`, `1.5`},
+		{`100`, `100`},
+		{`1e3`, `1000`},
+		{`1E-7`, `1e-07`},
+		{`0.0001`, `0.0001`},
+		{`0.00001234`, `1.234e-05`},
+		{`123456789012345678901234`, `123456789012345690000000`},
+		{`1e16`, `1e+16`},
+		{`1e17`, `1e+17`},
+		{`12345678901234567`, `12345678901234568`},
+		{`-0`, `-0`},
+		{`0`, `0`},
+		{`0.1`, `0.1`},
+		{`3.14159265358979323846`, `3.141592653589793`},
+		{`1.7976931348623157e309`, `1.7976931348623157e+308`},
+		{`-1e400`, `-1.7976931348623157e+308`},
+		{`1.5e300`, `1.5e+300`},
+		{`[1,2]`, "[\n\t1,\n\t2\n]"},
+	} {
+		x := x // https://github.com/golang/go/issues/60078
+		t.Run(x.in, func(t *testing.T) {
+			out, err := Tab(json.RawMessage(x.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != x.want {
+				t.Fatalf("got:\n%s\n\nexpected:\n%s", out, x.want)
+			}
+		})
+	}
+}
+
+func TestTabErrors(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`{`,
+		`[1,`,
+		`"unterminated`,
+		`nope`,
+		`1.2.3`,
+	} {
+		if out, err := Tab(json.RawMessage(in)); err == nil {
+			t.Errorf("expected error for %q, got: %s", in, out)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package ociimport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// https://github.com/git/git/blob/v2.39.5/Documentation/git-ls-tree.txt (see also "S_IFLNK", "S_IFGITLINK", etc in git's "cache.h")
+const (
+	gitModeSymlink = "120000"
+	gitModeGitlink = "160000"
+)
+
+// how many symlinks we're willing to follow while resolving a single path (same as Linux's MAXSYMLINKS)
+const gitMaxSymlinks = 40
+
+func gitCmd(ctx context.Context, gitDir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", gitDir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	return cmd
+}
+
+// run a git command and return its stdout (with stderr folded into the error, if any)
+func gitOutput(ctx context.Context, gitDir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := gitCmd(ctx, gitDir, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// "bashbrew fetch", but just enough of it to get a single commit into the (bare) git cache, returning the full commit hash
+//
+// https://github.com/docker-library/bashbrew/blob/5152c0df682515cbe7ac62b68bcea4278856429f/cmd/bashbrew/git.go#L52-L80
+func gitFetch(ctx context.Context, gitDir, gitRepo, gitFetch, gitCommit string) (string, error) {
+	if _, err := gitOutput(ctx, ".", "init", "--quiet", "--bare", gitDir); err != nil {
+		return "", err
+	}
+	if _, err := gitOutput(ctx, gitDir, "config", "gc.auto", "0"); err != nil {
+		return "", err
+	}
+
+	commit := func() (string, error) {
+		out, err := gitOutput(ctx, gitDir, "rev-parse", "--verify", gitCommit+"^{commit}")
+		return strings.TrimSpace(string(out)), err
+	}
+	if c, err := commit(); err == nil {
+		return c, nil
+	}
+	if _, err := gitOutput(ctx, gitDir, "fetch", "--quiet", gitRepo, gitCommit+":"); err != nil {
+		if _, err2 := gitOutput(ctx, gitDir, "fetch", "--quiet", gitRepo, gitFetch+":"); err2 != nil {
+			return "", errors.Join(err, err2)
+		}
+	}
+	return commit()
+}
+
+type gitTreeEntry struct {
+	mode   string
+	object string
+}
+
+// the (recursive) contents of a single git tree, which we read (and resolve symlinks within) without ever writing any of it to disk, so nothing in the tree can ever point us outside of it
+type gitTree struct {
+	gitDir  string
+	name    string                  // "commit:directory/" (for error messages)
+	entries map[string]gitTreeEntry // "path/to/file" => entry
+}
+
+// list the contents of "directory" in "commit"
+func gitListTree(ctx context.Context, gitDir, commit, directory string) (*gitTree, error) {
+	treeish := commit + ":"
+	if directory != "." {
+		treeish += directory + "/"
+	}
+	out, err := gitOutput(ctx, gitDir, "ls-tree", "-r", "-z", treeish)
+	if err != nil {
+		return nil, err
+	}
+	tree := &gitTree{
+		gitDir:  gitDir,
+		name:    treeish,
+		entries: map[string]gitTreeEntry{},
+	}
+	for _, line := range bytes.Split(out, []byte{0}) {
+		if len(line) == 0 {
+			continue
+		}
+		// "<mode> SP <type> SP <object> TAB <file>"
+		meta, file, ok := strings.Cut(string(line), "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("%s: unexpected ls-tree output: %q", treeish, line)
+		}
+		tree.entries[file] = gitTreeEntry{mode: fields[0], object: fields[2]}
+	}
+	return tree, nil
+}
+
+// is "p" a clean, relative path that stays inside the directory it's relative to?
+func isLocalPath(p string) bool {
+	return p != "" && !path.IsAbs(p) && path.Clean(p) == p && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// resolve "name" (following any symlinks, including in intermediate directories) to a regular file in the tree, refusing to ever leave the tree
+func (t *gitTree) resolve(ctx context.Context, name string) (gitTreeEntry, string, error) {
+	if path.IsAbs(name) {
+		return gitTreeEntry{}, "", fmt.Errorf("%s%s: absolute paths are not allowed", t.name, name)
+	}
+	var (
+		parts    = strings.Split(name, "/")
+		cur      = "" // the (resolved) path we've walked so far
+		symlinks = 0
+	)
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if cur == "" {
+				return gitTreeEntry{}, "", fmt.Errorf("%s%s: path escapes the directory", t.name, name)
+			}
+			cur = path.Dir(cur)
+			if cur == "." {
+				cur = ""
+			}
+			continue
+		}
+		next := path.Join(cur, part)
+		entry, ok := t.entries[next]
+		if !ok || entry.mode != gitModeSymlink {
+			cur = next
+			continue
+		}
+		if symlinks++; symlinks > gitMaxSymlinks {
+			return gitTreeEntry{}, "", fmt.Errorf("%s%s: too many levels of symbolic links", t.name, name)
+		}
+		target, err := gitOutput(ctx, t.gitDir, "cat-file", "blob", entry.object)
+		if err != nil {
+			return gitTreeEntry{}, "", err
+		}
+		if path.IsAbs(string(target)) {
+			return gitTreeEntry{}, "", fmt.Errorf("%s%s: symlink %s points to an absolute path (%q)", t.name, name, next, target)
+		}
+		// the symlink target is relative to the directory the symlink lives in (which is "cur"), so we just splice it into the remaining parts and keep walking
+		parts = append(strings.Split(string(target), "/"), parts...)
+	}
+
+	entry, ok := t.entries[cur]
+	if !ok {
+		return gitTreeEntry{}, cur, fmt.Errorf("%s%s: %w", t.name, name, os.ErrNotExist)
+	}
+	if entry.mode == gitModeGitlink {
+		return gitTreeEntry{}, cur, fmt.Errorf("%s%s: submodules are not supported", t.name, name)
+	}
+	return entry, cur, nil
+}
+
+// open the (resolved) file "name" for reading
+func (t *gitTree) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	entry, _, err := t.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	cmd := gitCmd(ctx, t.gitDir, "cat-file", "blob", entry.object)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &gitBlobReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+func (t *gitTree) readFile(ctx context.Context, name string) ([]byte, error) {
+	r, err := t.open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	return b, err
+}
+
+type gitBlobReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *gitBlobReader) Close() error {
+	// drain whatever's left so "git cat-file" doesn't die of SIGPIPE (and we get an accurate exit code)
+	_, _ = io.Copy(io.Discard, r.ReadCloser)
+	return r.cmd.Wait()
+}
@@ -0,0 +1,438 @@
+// Package ociimport is a Go port of "helpers/oci-import.sh" (the equivalent of "docker build" for "Builder: oci-import"): it extracts an OCI image layout from a git commit, normalizes it the same way "oci-import.sh" does, and writes a clean (validated) OCI image layout that is ready to deploy.
+//
+// Unlike "oci-import.sh", nothing from the git repository is ever extracted to disk as-is; files are read directly from the git object store (resolving symlinks ourselves and refusing any that point outside the build directory), and only the blobs actually referenced by the imported index are copied into the new layout.
+package ociimport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker-library/meta-scripts/jq"
+	"github.com/docker-library/meta-scripts/ocivalidate"
+	"github.com/docker-library/meta-scripts/om"
+	"github.com/docker-library/meta-scripts/registry"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// https://github.com/docker-library/bashbrew/blob/4e0ea8d8aba49d54daf22bd8415fabba65dc83ee/cmd/bashbrew/oci-builder.go#L90-L91
+const Builder = "oci-import"
+
+// the subset of a "builds.json" entry that we need for importing
+type Build struct {
+	BuildID string `json:"buildId"`
+	Build   struct {
+		Arch    string            `json:"arch"`
+		Parents map[string]string `json:"parents"`
+	} `json:"build"`
+	Source struct {
+		Entries []struct {
+			GitRepo         string `json:"GitRepo"`
+			GitFetch        string `json:"GitFetch"`
+			GitCommit       string `json:"GitCommit"`
+			Directory       string `json:"Directory"`
+			File            string `json:"File"`
+			Builder         string `json:"Builder"`
+			SourceDateEpoch int64  `json:"SOURCE_DATE_EPOCH"`
+		} `json:"entries"`
+		Arches map[string]struct {
+			Tags          []string        `json:"tags"`
+			LastStageFrom string          `json:"lastStageFrom"`
+			Platform      json.RawMessage `json:"platform"`
+		} `json:"arches"`
+	} `json:"source"`
+}
+
+type Options struct {
+	// the (bare) git repository to fetch into / read from; created if it doesn't exist (see [DefaultGitCache])
+	GitCache string
+}
+
+// "~/.cache/bashbrew/git" (respecting BASHBREW_CACHE and XDG_CACHE_HOME, just like bashbrew does)
+func DefaultGitCache() (string, error) {
+	cache := os.Getenv("BASHBREW_CACHE")
+	if cache == "" {
+		xdg := os.Getenv("XDG_CACHE_HOME")
+		if xdg == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			xdg = filepath.Join(home, ".cache")
+		}
+		cache = filepath.Join(xdg, "bashbrew")
+	}
+	return filepath.Join(cache, "git"), nil
+}
+
+// import the given build into a new OCI image layout at "target" (which must not exist yet)
+func Import(ctx context.Context, build Build, target string, opts *Options) error {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.GitCache == "" {
+		var err error
+		o.GitCache, err = DefaultGitCache()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(build.Source.Entries) == 0 {
+		return fmt.Errorf("%s: missing source entries", build.BuildID)
+	}
+	entry := build.Source.Entries[0]
+	if entry.Builder != Builder {
+		return fmt.Errorf("%s: unsupported builder %q (expected %q)", build.BuildID, entry.Builder, Builder)
+	}
+	for _, s := range []struct{ name, value string }{
+		{"GitRepo", entry.GitRepo},
+		{"GitFetch", entry.GitFetch},
+		{"GitCommit", entry.GitCommit},
+	} {
+		if s.value == "" {
+			return fmt.Errorf("%s: missing %s", build.BuildID, s.name)
+		}
+		if strings.HasPrefix(s.value, "-") {
+			// don't let anything be interpreted as a git flag
+			return fmt.Errorf("%s: invalid %s: %q", build.BuildID, s.name, s.value)
+		}
+	}
+	if entry.Directory != "." && !isLocalPath(entry.Directory) {
+		return fmt.Errorf("%s: invalid Directory: %q", build.BuildID, entry.Directory)
+	}
+	if !isLocalPath(entry.File) {
+		return fmt.Errorf("%s: invalid File: %q", build.BuildID, entry.File)
+	}
+
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("%s: target already exists", target)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	commit, err := gitFetch(ctx, o.GitCache, entry.GitRepo, entry.GitFetch, entry.GitCommit)
+	if err != nil {
+		return fmt.Errorf("%s: failed to fetch %s (%s): %w", build.BuildID, entry.GitRepo, entry.GitCommit, err)
+	}
+	tree, err := gitListTree(ctx, o.GitCache, commit, entry.Directory)
+	if err != nil {
+		return fmt.Errorf("%s: %w", build.BuildID, err)
+	}
+
+	// validate "oci-layout"
+	layoutBytes, err := tree.readFile(ctx, ocispec.ImageLayoutFile)
+	if err != nil {
+		return err
+	}
+	var layout ocispec.ImageLayout
+	if err := json.Unmarshal(layoutBytes, &layout); err != nil {
+		return fmt.Errorf("%s%s: %w", tree.name, ocispec.ImageLayoutFile, err)
+	}
+	if layout.Version != ocispec.ImageLayoutVersion {
+		return fmt.Errorf("%s%s: unsupported imageLayoutVersion %q", tree.name, ocispec.ImageLayoutFile, layout.Version)
+	}
+
+	file, err := tree.readFile(ctx, entry.File)
+	if err != nil {
+		return err
+	}
+	index, err := NormalizeIndex(build, entry.File, file)
+	if err != nil {
+		return fmt.Errorf("%s%s: %w", tree.name, entry.File, err)
+	}
+
+	var manifest ocispec.Index
+	if err := json.Unmarshal(index, &manifest); err != nil {
+		return err
+	}
+
+	c := &copier{
+		tree:   tree,
+		target: target,
+		seen:   map[ociregistry.Digest]bool{},
+	}
+	for _, desc := range manifest.Manifests {
+		if err := c.copy(ctx, desc, false); err != nil {
+			return err
+		}
+	}
+
+	// now that we have the exact index we want to push, let's push it down into a blob and make a new appropriate "index.json" that points to it
+	layoutClient := registry.OCILayout()
+	indexDesc, err := layoutClient.PushBlob(ctx, target, ociregistry.Descriptor{
+		MediaType: manifest.MediaType,
+		Digest:    godigest.FromBytes(index),
+		Size:      int64(len(index)),
+	}, bytes.NewReader(index))
+	if err != nil {
+		return err
+	}
+	indexJSON, err := marshalTab(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{{
+			MediaType: indexDesc.MediaType,
+			Digest:    indexDesc.Digest,
+			Size:      indexDesc.Size,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(target, "index.json"), indexJSON, 0o644); err != nil {
+		return err
+	}
+
+	return ocivalidate.ValidateLayout(ctx, target, nil)
+}
+
+// the equivalent of "jq --tab" (tab indentation, no HTML escaping, trailing newline)
+func marshalTab(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// given the contents of the "File:" from the build's source, returns the exact bytes of the index that "oci-import.sh" would create (upgraded to an index if "File:" isn't "index.json", with maintainer-provided URLs/annotations/data purged and our platform and build annotations injected)
+func NormalizeIndex(build Build, fileName string, file []byte) ([]byte, error) {
+	var index om.OrderedMap[json.RawMessage]
+	if err := json.Unmarshal(file, &index); err != nil {
+		return nil, err
+	}
+
+	// https://github.com/docker-library/bashbrew/blob/4e0ea8d8aba49d54daf22bd8415fabba65dc83ee/cmd/bashbrew/oci-builder.go#L116
+	if fileName != "index.json" {
+		manifests, err := json.Marshal([]json.RawMessage{file})
+		if err != nil {
+			return nil, err
+		}
+		index = om.OrderedMap[json.RawMessage]{}
+		index.Set("schemaVersion", json.RawMessage(`2`))
+		index.Set("mediaType", json.RawMessage(`"`+ocispec.MediaTypeImageIndex+`"`))
+		index.Set("manifests", manifests)
+	}
+
+	if mediaType := index.Get("mediaType"); mediaType == nil || string(mediaType) == "null" {
+		index.Set("mediaType", json.RawMessage(`"`+ocispec.MediaTypeImageIndex+`"`))
+	}
+	var header struct {
+		SchemaVersion int    `json:"schemaVersion"`
+		MediaType     string `json:"mediaType"`
+	}
+	if b, err := json.Marshal(index); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(b, &header); err != nil {
+		return nil, err
+	}
+	if header.SchemaVersion != 2 {
+		return nil, fmt.Errorf("unsupported schemaVersion %d", header.SchemaVersion)
+	}
+	if header.MediaType != ocispec.MediaTypeImageIndex {
+		return nil, fmt.Errorf("unsupported mediaType %q", header.MediaType)
+	}
+
+	var manifests []om.OrderedMap[json.RawMessage]
+	if err := json.Unmarshal(index.Get("manifests"), &manifests); err != nil {
+		return nil, fmt.Errorf("failed to parse manifests: %w", err)
+	}
+	if len(manifests) != 1 {
+		// TODO allow upstream attestation in the future?
+		return nil, fmt.Errorf("expected exactly 1 manifest, got %d", len(manifests))
+	}
+	desc := manifests[0]
+
+	// purge maintainer-provided URLs / annotations (https://github.com/docker-library/bashbrew/blob/4e0ea8d8aba49d54daf22bd8415fabba65dc83ee/cmd/bashbrew/oci-builder.go#L146-L147)
+	// (also purge maintainer-provided "data" fields here, since including that in the index is a bigger conversation/decision)
+	desc.Delete("urls")
+	desc.Delete("data")
+	desc.Delete("annotations")
+
+	var descCheck ocispec.Descriptor
+	if b, err := json.Marshal(desc); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(b, &descCheck); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest descriptor: %w", err)
+	}
+	switch descCheck.MediaType {
+	case ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageIndex:
+	default:
+		return nil, fmt.Errorf("unsupported manifest mediaType %q", descCheck.MediaType)
+	}
+	if err := descCheck.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest digest %q: %w", descCheck.Digest, err)
+	}
+
+	// make sure "platform" is correct
+	arch, ok := build.Source.Arches[build.Build.Arch]
+	if !ok || len(arch.Platform) == 0 || string(arch.Platform) == "null" {
+		return nil, fmt.Errorf("missing platform for arch %q", build.Build.Arch)
+	}
+	desc.Set("platform", arch.Platform)
+
+	// inject our build annotations
+	annotations, err := json.Marshal(BuildAnnotations(build))
+	if err != nil {
+		return nil, err
+	}
+	desc.Set("annotations", annotations)
+
+	b, err := json.Marshal([]om.OrderedMap[json.RawMessage]{desc})
+	if err != nil {
+		return nil, err
+	}
+	index.Set("manifests", b)
+
+	index, err = jq.NormalizeManifest(index)
+	if err != nil {
+		return nil, err
+	}
+
+	// the exact output of "jq --tab" (which, unlike encoding/json, does not escape "<", ">", "&", etc, so any annotation or URL containing those would otherwise get a different digest)
+	compact, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	out, err := jq.Tab(compact)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// port of "build_annotations" from "meta.jq" (with the GitRepo as the build URL, like "oci-import.sh" uses)
+func BuildAnnotations(build Build) map[string]string {
+	entry := build.Source.Entries[0]
+	arch := build.Source.Arches[build.Build.Arch]
+
+	annotations := map[string]string{
+		// https://github.com/opencontainers/image-spec/blob/v1.1.0/annotations.md#pre-defined-annotation-keys
+		ocispec.AnnotationSource:   entry.GitRepo,
+		ocispec.AnnotationRevision: entry.GitCommit,
+		ocispec.AnnotationCreated:  time.Unix(entry.SourceDateEpoch, 0).UTC().Format("2006-01-02T15:04:05Z"),
+
+		registry.AnnotationBashbrewArch: build.Build.Arch,
+	}
+
+	// TODO come up with less assuming values here? (Docker Hub assumption, tag ordering assumption)
+	for _, tag := range arch.Tags {
+		repo, version, ok := strings.Cut(tag, ":")
+		if !ok {
+			continue
+		}
+		annotations[ocispec.AnnotationVersion] = version[strings.LastIndex(version, ":")+1:]
+		if strings.Contains(repo, "/") {
+			repo = "r/" + repo
+		} else {
+			repo = "_/" + repo
+		}
+		annotations[ocispec.AnnotationURL] = "https://hub.docker.com/" + repo
+		break
+	}
+
+	if arch.LastStageFrom != "" {
+		annotations[ocispec.AnnotationBaseImageName] = arch.LastStageFrom
+		if digest := build.Build.Parents[arch.LastStageFrom]; digest != "" {
+			annotations[ocispec.AnnotationBaseImageDigest] = digest
+		}
+	}
+
+	// strip off anything missing a value (possibly "source", "url", "version", "base.digest", etc)
+	for k, v := range annotations {
+		if v == "" {
+			delete(annotations, k)
+		}
+	}
+
+	return annotations
+}
+
+// copies blobs from the git tree into the target layout (children first, verifying everything along the way)
+type copier struct {
+	tree   *gitTree
+	target string
+	seen   map[ociregistry.Digest]bool
+}
+
+func (c *copier) copy(ctx context.Context, desc ocispec.Descriptor, optional bool) error {
+	if c.seen[desc.Digest] {
+		return nil
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %q: %w", desc.Digest, err)
+	}
+	blobPath := ocispec.ImageBlobsDir + "/" + desc.Digest.Algorithm().String() + "/" + desc.Digest.Encoded()
+
+	r, err := c.tree.open(ctx, blobPath)
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer r.Close()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageIndex, "application/vnd.docker.distribution.manifest.v2+json", "application/vnd.docker.distribution.manifest.list.v2+json":
+		// manifests need to be parsed so we can copy their children too (and are small enough to read fully)
+		b, err := io.ReadAll(io.LimitReader(r, 4*1024*1024+1))
+		if err != nil {
+			return err
+		}
+		children, err := registry.ParseManifestChildren(b)
+		if err != nil {
+			return fmt.Errorf("%s: failed to parse manifest: %w", desc.Digest, err)
+		}
+		var subject struct {
+			Subject *ocispec.Descriptor `json:"subject"`
+		}
+		if err := json.Unmarshal(b, &subject); err != nil {
+			return fmt.Errorf("%s: failed to parse manifest: %w", desc.Digest, err)
+		}
+		for _, child := range children.Manifests {
+			if err := c.copy(ctx, child, false); err != nil {
+				return err
+			}
+		}
+		if children.Config != nil {
+			if err := c.copy(ctx, *children.Config, false); err != nil {
+				return err
+			}
+		}
+		for _, child := range children.Layers {
+			if err := c.copy(ctx, child, false); err != nil {
+				return err
+			}
+		}
+		if subject.Subject != nil {
+			// "subject" is allowed to dangle, but if it's included, we should include it too
+			if err := c.copy(ctx, *subject.Subject, true); err != nil {
+				return err
+			}
+		}
+		r = io.NopCloser(bytes.NewReader(b))
+	}
+
+	if _, err := registry.OCILayout().PushBlob(ctx, c.target, desc, r); err != nil {
+		return fmt.Errorf("%s: %w", blobPath, err)
+	}
+	c.seen[desc.Digest] = true
+	return nil
+}
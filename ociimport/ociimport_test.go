@@ -0,0 +1,305 @@
+package ociimport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker-library/meta-scripts/ocivalidate"
+
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// the build object from ".test/oci-import/out.sh"
+func testBuild(t *testing.T) Build {
+	t.Helper()
+	b, err := os.ReadFile("../.test/oci-import/out.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, js, ok := strings.Cut(string(b), "build='")
+	if !ok {
+		t.Fatal("failed to find build object in out.sh")
+	}
+	js, _, _ = strings.Cut(js, "'\n")
+	var build Build
+	if err := json.Unmarshal([]byte(js), &build); err != nil {
+		t.Fatal(err)
+	}
+	return build
+}
+
+// the index blob that ".test/oci-import/test.sh" generates with "oci-import.sh" has to be byte-for-byte identical to ours
+func TestNormalizeIndexGolden(t *testing.T) {
+	build := testBuild(t)
+
+	want, err := os.ReadFile("../.test/oci-import/temp/blobs/sha256/166d2948c01a6ec70e44b073b0a4c56a3d7c4a4b8fd390d9ebfcb16a3ecf658e")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// (approximately) the upstream "index.json", with some extra maintainer-provided junk that should get purged
+	in := `{"schemaVersion":2,"manifests":[{"urls":["https://example.com"],"size":610,"digest":"sha256:4be429a5fbb2e71ae7958bfa558bc637cf3a61baf40a708cb8fff532b39e52d0","mediaType":"application/vnd.oci.image.manifest.v1+json","annotations":{"org.opencontainers.image.ref.name":"latest"}}]}`
+	got, err := NormalizeIndex(build, "index.json", []byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("index mismatch:\n%s\nvs\n%s", got, want)
+	}
+	if d := godigest.FromBytes(got); d != "sha256:166d2948c01a6ec70e44b073b0a4c56a3d7c4a4b8fd390d9ebfcb16a3ecf658e" {
+		t.Fatalf("unexpected digest: %s", d)
+	}
+
+	// "File:" pointing to a bare descriptor gets upgraded to an index
+	got, err = NormalizeIndex(build, "descriptor.json", []byte(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:4be429a5fbb2e71ae7958bfa558bc637cf3a61baf40a708cb8fff532b39e52d0","size":610}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("index mismatch:\n%s\nvs\n%s", got, want)
+	}
+
+	for name, in := range map[string]string{
+		"two manifests":   `{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:4be429a5fbb2e71ae7958bfa558bc637cf3a61baf40a708cb8fff532b39e52d0","size":610},{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:4be429a5fbb2e71ae7958bfa558bc637cf3a61baf40a708cb8fff532b39e52d0","size":610}]}`,
+		"bad media type":  `{"schemaVersion":2,"manifests":[{"mediaType":"application/octet-stream","digest":"sha256:4be429a5fbb2e71ae7958bfa558bc637cf3a61baf40a708cb8fff532b39e52d0","size":610}]}`,
+		"bad digest":      `{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:../../../etc/passwd","size":610}]}`,
+		"schemaVersion 1": `{"schemaVersion":1,"manifests":[]}`,
+	} {
+		if _, err := NormalizeIndex(build, "index.json", []byte(in)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// jq doesn't escape "<", ">", or "&" the way encoding/json does by default, so neither can we (or the digest wouldn't match what "oci-import.sh" creates)
+func TestNormalizeIndexNoHTMLEscaping(t *testing.T) {
+	build := testBuild(t)
+	build.Source.Entries[0].GitRepo = "https://example.com/<repo>?a=1&b=2"
+
+	got, err := NormalizeIndex(build, "descriptor.json", []byte(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:4be429a5fbb2e71ae7958bfa558bc637cf3a61baf40a708cb8fff532b39e52d0","size":610}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(got, []byte(`"org.opencontainers.image.source": "https://example.com/<repo>?a=1&b=2"`)) {
+		t.Fatalf("expected unescaped source annotation:\n%s", got)
+	}
+}
+
+func testGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+}
+
+// a git repository with a small (but valid) OCI layout in "layout/", returning the repository and the commit
+func testRepo(t *testing.T, setup func(dir string)) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	testGit(t, repo, "init", "--quiet")
+	dir := filepath.Join(repo, "layout")
+
+	write := func(name string, b []byte) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blob := func(mediaType string, b []byte) ocispec.Descriptor {
+		t.Helper()
+		d := godigest.FromBytes(b)
+		write("blobs/"+d.Algorithm().String()+"/"+d.Encoded(), b)
+		return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(b))}
+	}
+	jsonBlob := func(mediaType string, v any) ocispec.Descriptor {
+		t.Helper()
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blob(mediaType, b)
+	}
+
+	uncompressed := []byte("not really a tarball")
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write(uncompressed)
+	gzw.Close()
+	layer := blob(ocispec.MediaTypeImageLayerGzip, gz.Bytes())
+	config := jsonBlob(ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: ocispec.Platform{Architecture: "amd64", OS: "linux"},
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []godigest.Digest{godigest.FromBytes(uncompressed)}},
+	})
+	manifest := jsonBlob(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	manifest.Annotations = map[string]string{ocispec.AnnotationRefName: "latest"}
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("index.json", index)
+	write(ocispec.ImageLayoutFile, []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	// junk that should *not* end up in our output
+	write("unreferenced.txt", []byte("hello"))
+
+	if setup != nil {
+		setup(dir)
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "--quiet", "-m", "test")
+	out, err := exec.Command("git", "-C", repo, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, strings.TrimSpace(string(out))
+}
+
+func testImport(t *testing.T, setup func(dir string)) (string, error) {
+	t.Helper()
+	repo, commit := testRepo(t, setup)
+
+	build := testBuild(t)
+	build.Source.Entries[0].GitRepo = repo
+	build.Source.Entries[0].GitFetch = "HEAD"
+	build.Source.Entries[0].GitCommit = commit
+	build.Source.Entries[0].Directory = "layout"
+
+	target := filepath.Join(t.TempDir(), "target")
+	return target, Import(context.Background(), build, target, &Options{
+		GitCache: filepath.Join(t.TempDir(), "git"),
+	})
+}
+
+func TestImport(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		target, err := testImport(t, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ocivalidate.ValidateLayout(context.Background(), target, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(target, "unreferenced.txt")); err == nil {
+			t.Fatal("unreferenced.txt was copied")
+		}
+
+		var index ocispec.Index
+		b, err := os.ReadFile(filepath.Join(target, "index.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &index); err != nil {
+			t.Fatal(err)
+		}
+		if len(index.Manifests) != 1 || index.Manifests[0].MediaType != ocispec.MediaTypeImageIndex {
+			t.Fatalf("unexpected index.json: %s", b)
+		}
+		b, err = os.ReadFile(filepath.Join(target, "blobs", "sha256", index.Manifests[0].Digest.Encoded()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &index); err != nil {
+			t.Fatal(err)
+		}
+		if len(index.Manifests) != 1 {
+			t.Fatalf("unexpected index: %s", b)
+		}
+		desc := index.Manifests[0]
+		if desc.Platform == nil || desc.Platform.OS != "linux" || desc.Platform.Architecture != "amd64" {
+			t.Errorf("unexpected platform: %+v", desc.Platform)
+		}
+		if _, ok := desc.Annotations[ocispec.AnnotationRefName]; ok {
+			t.Errorf("maintainer-provided annotations were not purged: %+v", desc.Annotations)
+		}
+		if got := desc.Annotations[ocispec.AnnotationVersion]; got != "1.36.1" {
+			t.Errorf("unexpected version annotation: %q", got)
+		}
+	})
+
+	t.Run("symlink inside", func(t *testing.T) {
+		_, err := testImport(t, func(dir string) {
+			if err := os.Rename(filepath.Join(dir, "index.json"), filepath.Join(dir, "real-index.json")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("real-index.json", filepath.Join(dir, "index.json")); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("symlink escape", func(t *testing.T) {
+		_, err := testImport(t, func(dir string) {
+			// point "blobs" outside the build directory (at a copy of the real blobs, so the only thing wrong is the escape)
+			if err := os.Rename(filepath.Join(dir, "blobs"), filepath.Join(dir, "..", "blobs")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("../blobs", filepath.Join(dir, "blobs")); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if err == nil || !strings.Contains(err.Error(), "escapes") {
+			t.Fatalf("expected path escape error, got: %v", err)
+		}
+	})
+
+	t.Run("absolute symlink", func(t *testing.T) {
+		_, err := testImport(t, func(dir string) {
+			if err := os.Remove(filepath.Join(dir, "index.json")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("/etc/passwd", filepath.Join(dir, "index.json")); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if err == nil || !strings.Contains(err.Error(), "absolute") {
+			t.Fatalf("expected absolute symlink error, got: %v", err)
+		}
+	})
+
+	t.Run("target exists", func(t *testing.T) {
+		target := t.TempDir()
+		err := Import(context.Background(), testBuild(t), target, &Options{GitCache: filepath.Join(t.TempDir(), "git")})
+		if err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Fatalf("expected target exists error, got: %v", err)
+		}
+	})
+
+	t.Run("bad File", func(t *testing.T) {
+		build := testBuild(t)
+		build.Source.Entries[0].File = "../index.json"
+		err := Import(context.Background(), build, filepath.Join(t.TempDir(), "target"), &Options{GitCache: filepath.Join(t.TempDir(), "git")})
+		if err == nil || !strings.Contains(err.Error(), "invalid File") {
+			t.Fatalf("expected invalid File error, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,272 @@
+package ocivalidate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// https://github.com/opencontainers/image-spec/blob/v1.1.1/media-types.md
+// https://github.com/distribution/distribution/blob/v3.0.0/docs/content/spec/manifest-v2-2.md#media-types
+var (
+	mediaTypesIndex  = []string{ocispec.MediaTypeImageIndex, "application/vnd.docker.distribution.manifest.list.v2+json"}
+	mediaTypesImage  = []string{ocispec.MediaTypeImageManifest, "application/vnd.docker.distribution.manifest.v2+json"}
+	mediaTypesConfig = []string{ocispec.MediaTypeImageConfig, "application/vnd.docker.container.image.v1+json"}
+	mediaTypesLayer  = []string{ocispec.MediaTypeImageLayer, ocispec.MediaTypeImageLayerGzip, "application/vnd.docker.image.rootfs.diff.tar", "application/vnd.docker.image.rootfs.diff.tar.gzip"}
+)
+
+// https://github.com/moby/buildkit/blob/c6145c2423de48f891862ac02f9b2653864d3c9e/docs/attestations/attestation-storage.md
+const (
+	mediaTypeInToto             = "application/vnd.in-toto+json"
+	artifactTypeAttestation     = "application/vnd.docker.attestation.manifest.v1+json" // https://github.com/moby/buildkit/pull/5573/files#r2069525281
+	annotationReferenceType     = "vnd.docker.reference.type"
+	annotationReferenceDigest   = "vnd.docker.reference.digest"
+	annotationInTotoPredicate   = "in-toto.io/predicate-type"
+	referenceTypeAttestation    = "attestation-manifest"
+	manifestSizeLimit           = 4 * 1024 * 1024 // https://github.com/opencontainers/distribution-spec/pull/293#issuecomment-1452780554
+	configSizeMinimum           = 2               // "{}"
+	manifestSizeMinimumExcluded = 2               // "{}" plus *some* content
+)
+
+var inTotoPredicateTypes = []string{
+	"https://slsa.dev/provenance/v0.2",
+	"https://spdx.dev/Document",
+}
+
+// a descriptor that has passed [validator.descriptor] (at least far enough to be fetched)
+type descriptor struct {
+	ocispec.Descriptor
+
+	// whether "data" was set (which also means Descriptor.Data is the decoded content)
+	hasData bool
+}
+
+// https://github.com/opencontainers/image-spec/blob/v1.1.1/descriptor.md#digests
+var digestRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+
+var digestEncodedRegexps = map[string]*regexp.Regexp{
+	"sha256": regexp.MustCompile(`^[a-f0-9]{64}$`),
+	"sha512": regexp.MustCompile(`^[a-f0-9]{128}$`),
+	"blake3": regexp.MustCompile(`^[a-f0-9]{64}$`), // https://github.com/opencontainers/image-spec/pull/1240
+}
+
+// https://datatracker.ietf.org/doc/html/rfc4648#section-4
+var base64Regexp = regexp.MustCompile(`^[A-Za-z0-9+/]*=*$`)
+
+// parse JSON such that we can validate types ourselves (and numbers stay [json.Number])
+func decodeJSON(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var ret any
+	if err := dec.Decode(&ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// validate_oci_digest
+func (v *validator) digest(object, path string, val any) (ociregistry.Digest, bool) {
+	s, ok := val.(string)
+	if !ok {
+		v.errorf(object, path, val, "digest must be a string")
+		return "", false
+	}
+	if !digestRegexp.MatchString(s) {
+		v.errorf(object, path, val, "invalid digest syntax")
+		return "", false
+	}
+	algorithm, encoded, _ := strings.Cut(s, ":")
+	re, ok := digestEncodedRegexps[algorithm]
+	if !ok {
+		v.errorf(object, path, val, "unsupported digest algorithm %q (valid: sha256, sha512, blake3)", algorithm)
+		return "", false
+	}
+	if !re.MatchString(encoded) {
+		v.errorf(object, path, val, "the encoded portion MUST match /%s/", re.String()[1:len(re.String())-1])
+		return "", false
+	}
+	return ociregistry.Digest(s), true
+}
+
+// validate_oci_annotations_haver
+func (v *validator) annotations(object, path string, obj map[string]any) map[string]string {
+	val, ok := obj["annotations"]
+	if !ok {
+		return nil
+	}
+	path = pathKey(path, "annotations")
+	m, ok := val.(map[string]any)
+	if !ok {
+		v.errorf(object, path, val, "if present, annotations must be an object")
+		return nil
+	}
+	ret := make(map[string]string, len(m))
+	for k, av := range m {
+		s, ok := av.(string)
+		if !ok {
+			v.errorf(object, pathKey(path, k), av, "annotation values must be strings")
+			continue
+		}
+		ret[k] = s
+	}
+	// TODO validate that keys are not bare words (reverse DNS or vendor/bar)
+	return ret
+}
+
+func (v *validator) optionalString(object, path string, obj map[string]any, key string, nonEmpty bool) (string, bool) {
+	val, ok := obj[key]
+	if !ok {
+		return "", false
+	}
+	s, ok := val.(string)
+	if !ok || (nonEmpty && s == "") {
+		if nonEmpty {
+			v.errorf(object, pathKey(path, key), val, "%s must be a non-empty string", key)
+		} else {
+			v.errorf(object, pathKey(path, key), val, "%s must be a string", key)
+		}
+		return "", false
+	}
+	return s, true
+}
+
+func (v *validator) optionalStrings(object, path string, obj map[string]any, key string) []string {
+	val, ok := obj[key]
+	if !ok {
+		return nil
+	}
+	arr, ok := val.([]any)
+	if !ok {
+		v.errorf(object, pathKey(path, key), val, "%s must be an array", key)
+		return nil
+	}
+	ret := []string{}
+	for i, item := range arr {
+		s, ok := item.(string)
+		if !ok {
+			v.errorf(object, pathIndex(pathKey(path, key), i), item, "%s must only contain strings", key)
+			continue
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// validate_oci_descriptor (returns false if the descriptor is too broken to use any further)
+func (v *validator) descriptor(object, path string, val any) (descriptor, bool) {
+	var desc descriptor
+
+	obj, ok := val.(map[string]any)
+	if !ok {
+		v.errorf(object, path, val, "descriptor must be an object")
+		return desc, false
+	}
+	usable := true
+
+	if mediaType, ok := v.optionalString(object, path, obj, "mediaType", false); ok {
+		desc.MediaType = mediaType
+	} else if _, has := obj["mediaType"]; !has {
+		v.errorf(object, pathKey(path, "mediaType"), nil, "mediaType must be a string")
+	}
+
+	if digest, ok := v.digest(object, pathKey(path, "digest"), obj["digest"]); ok {
+		desc.Digest = digest
+	} else {
+		usable = false
+	}
+
+	if size, ok := obj["size"].(json.Number); !ok {
+		v.errorf(object, pathKey(path, "size"), obj["size"], "size must be numeric")
+		usable = false
+	} else if i, err := size.Int64(); err != nil {
+		v.errorf(object, pathKey(path, "size"), obj["size"], "size must be whole")
+		usable = false
+	} else if i < 0 {
+		v.errorf(object, pathKey(path, "size"), obj["size"], "size must not be negative")
+		usable = false
+	} else {
+		desc.Size = i
+	}
+
+	if _, has := obj["urls"]; has {
+		desc.URLs = v.optionalStrings(object, path, obj, "urls")
+		if len(desc.URLs) > 0 {
+			// TODO are there cases where we should allow urls?
+			v.errorf(object, pathKey(path, "urls"), obj["urls"], "urls are not allowed")
+		}
+	}
+
+	desc.Annotations = v.annotations(object, path, obj)
+
+	if data, ok := v.optionalString(object, path, obj, "data", false); ok {
+		dataPath := pathKey(path, "data")
+		if !base64Regexp.MatchString(data) {
+			v.errorf(object, dataPath, nil, "data must be valid base64")
+		} else if dataSize := (desc.Size + 2) / 3 * 4; usable && int64(len(data)) != dataSize {
+			v.errorf(object, dataPath, nil, "given size of %d, data should be %d characters long (with padding), not %d", desc.Size, dataSize, len(data))
+		} else if decoded, err := base64.StdEncoding.DecodeString(data); err != nil {
+			v.errorf(object, dataPath, nil, "data must be valid base64: %v", err)
+		} else {
+			desc.Data = decoded
+			desc.hasData = true
+		}
+	}
+
+	if artifactType, ok := v.optionalString(object, path, obj, "artifactType", false); ok {
+		desc.ArtifactType = artifactType
+	}
+
+	// https://github.com/opencontainers/image-spec/blob/v1.1.1/image-index.md#image-index-property-descriptions
+	if val, has := obj["platform"]; has {
+		platformPath := pathKey(path, "platform")
+		if platform, ok := val.(map[string]any); !ok {
+			v.errorf(object, platformPath, val, "platform must be an object")
+		} else {
+			desc.Platform = &ocispec.Platform{}
+			for _, key := range []string{"architecture", "os"} {
+				if _, has := platform[key]; !has {
+					v.errorf(object, pathKey(platformPath, key), nil, "%s must be a non-empty string", key)
+				}
+			}
+			desc.Platform.Architecture, _ = v.optionalString(object, platformPath, platform, "architecture", true)
+			desc.Platform.OS, _ = v.optionalString(object, platformPath, platform, "os", true)
+			desc.Platform.OSVersion, _ = v.optionalString(object, platformPath, platform, "os.version", true)
+			desc.Platform.OSFeatures = v.optionalStrings(object, platformPath, platform, "os.features")
+			desc.Platform.Variant, _ = v.optionalString(object, platformPath, platform, "variant", true)
+			v.optionalStrings(object, platformPath, platform, "features")
+		}
+	}
+
+	return desc, usable
+}
+
+// the list of descriptors at obj[key] (which must be an array)
+func (v *validator) descriptors(object, path string, obj map[string]any, key string) ([]any, string) {
+	path = pathKey(path, key)
+	arr, ok := obj[key].([]any)
+	if !ok {
+		v.errorf(object, path, obj[key], "%s must be an array", key)
+		return nil, path
+	}
+	return arr, path
+}
+
+// validate_IN
+func (v *validator) in(object, path string, val string, valid ...string) bool {
+	for _, s := range valid {
+		if val == s {
+			return true
+		}
+	}
+	if val == "" {
+		v.errorf(object, path, nil, "missing (valid: %s)", strings.Join(valid, ", "))
+	} else {
+		v.errorf(object, path, val, "invalid (valid: %s)", strings.Join(valid, ", "))
+	}
+	return false
+}
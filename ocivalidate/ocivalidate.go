@@ -0,0 +1,159 @@
+// Package ocivalidate is a Go port of "helpers/oci-validate.sh" (and the "validate_oci_*" functions of "oci.jq"): it verifies OCI objects (indexes, images, configs, layers) as thoroughly as possible, either in an OCI image layout on disk or in a remote registry.
+package ocivalidate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker-library/meta-scripts/registry"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	"github.com/opencontainers/go-digest"
+)
+
+// a single validation failure
+type Error struct {
+	// which object the problem was found in ("oci:/path/to/layout@sha256:xxx", "/path/to/layout/index.json", etc)
+	Object string `json:"object"`
+
+	// where in the object the problem was found, in jq syntax (".manifests[0].size", etc); empty for problems with the object as a whole
+	Path string `json:"path,omitempty"`
+
+	// the offending value, if there is one
+	Value json.RawMessage `json:"value,omitempty"`
+
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Object)
+	if e.Path != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Path)
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Message)
+	if e.Value != nil {
+		b.WriteString(" (value: ")
+		b.Write(e.Value)
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// all the problems found by a single validation run (see [Validate] and [ValidateLayout])
+type Errors []Error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+type Options struct {
+	// don't download layers (their descriptors are still validated, and their existence is verified with HEAD requests, but their digests and diff_ids are not); useful for validating large remote images
+	SkipLayerContent bool
+}
+
+// validates the object at the given reference (and everything it references, recursively); the returned error is an [Errors] if the only problems are validation failures
+//
+// for OCI image layouts, a reference without a tag or digest (`oci:/path/to/layout`) validates the whole layout (see [ValidateLayout])
+func Validate(ctx context.Context, ref registry.Reference, opts *Options) error {
+	if ref.Host == registry.OCILayoutHost && ref.Tag == "" && ref.Digest == "" {
+		return ValidateLayout(ctx, ref.Repository, opts)
+	}
+
+	v := newValidator(ref, opts)
+
+	r, err := registry.Lookup(ctx, ref, &registry.LookupOptions{Head: true})
+	if err != nil {
+		return fmt.Errorf("%s: lookup failed: %w", ref, err)
+	}
+	if r == nil {
+		return Errors{{Object: ref.String(), Message: "not found"}}
+	}
+	desc := r.Descriptor()
+	r.Close()
+
+	v.manifest(ctx, ref.String(), "", descriptor{Descriptor: desc}, manifestOptions{root: true})
+	return v.result()
+}
+
+// a validation run against a single repository (or layout)
+type validator struct {
+	opts Options
+	repo registry.Reference // Tag and Digest are always empty
+
+	seen   map[ociregistry.Digest]bool          // manifests we've already validated
+	layers map[ociregistry.Digest]digest.Digest // layers we've already verified => uncompressed digest (empty if unknown)
+	errs   Errors
+	err    error // a non-validation error (network failure, etc) which aborts the run
+}
+
+func newValidator(ref registry.Reference, opts *Options) *validator {
+	v := &validator{
+		repo: ref,
+		seen: map[ociregistry.Digest]bool{},
+
+		layers: map[ociregistry.Digest]digest.Digest{},
+	}
+	v.repo.Tag = ""
+	v.repo.Digest = ""
+	if opts != nil {
+		v.opts = *opts
+	}
+	return v
+}
+
+func (v *validator) result() error {
+	if v.err != nil {
+		return v.err
+	}
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+func (v *validator) errorf(object, path string, value any, format string, args ...any) {
+	e := Error{
+		Object:  object,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	}
+	if value != nil {
+		if raw, ok := value.(json.RawMessage); ok {
+			e.Value = raw
+		} else if b, err := json.Marshal(value); err == nil {
+			e.Value = b
+		}
+	}
+	v.errs = append(v.errs, e)
+}
+
+// the name of the given digest in the repository we're validating
+func (v *validator) object(digest ociregistry.Digest) string {
+	ref := v.repo
+	ref.Digest = digest
+	return ref.String()
+}
+
+// jq-style path building: pathKey(".foo", "bar") == ".foo.bar", pathKey("", "os.version") == `["os.version"]`
+func pathKey(path, key string) string {
+	for _, c := range key {
+		if !(c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')) {
+			b, _ := json.Marshal(key)
+			return path + "[" + string(b) + "]"
+		}
+	}
+	return path + "." + key
+}
+
+func pathIndex(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
@@ -0,0 +1,328 @@
+package ocivalidate_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker-library/meta-scripts/ocivalidate"
+	"github.com/docker-library/meta-scripts/registry"
+
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// an OCI image layout directory that we write by hand (so we can write invalid things)
+type testLayout string
+
+func newTestLayout(t *testing.T) testLayout {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "layout")
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ocispec.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return testLayout(dir)
+}
+
+func (l testLayout) blob(t *testing.T, mediaType string, b []byte) ocispec.Descriptor {
+	t.Helper()
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    godigest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	if err := os.WriteFile(l.blobPath(desc), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func (l testLayout) blobPath(desc ocispec.Descriptor) string {
+	return filepath.Join(string(l), "blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+}
+
+func (l testLayout) json(t *testing.T, mediaType string, v any) ocispec.Descriptor {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l.blob(t, mediaType, b)
+}
+
+func (l testLayout) index(t *testing.T, descs ...ocispec.Descriptor) {
+	t.Helper()
+	b, _ := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: descs,
+	})
+	if err := os.WriteFile(filepath.Join(string(l), "index.json"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type testImageOptions struct {
+	diffID  godigest.Digest           // override the layer's diff_id in the config
+	layer   func(*ocispec.Descriptor) // modify the layer descriptor before it goes into the manifest
+	subject *ocispec.Descriptor
+}
+
+// writes an image (config, one gzip layer, manifest) and returns the manifest descriptor (with platform)
+func (l testLayout) image(t *testing.T, opts testImageOptions) ocispec.Descriptor {
+	t.Helper()
+
+	uncompressed := []byte("some layer content (not actually a tarball)")
+	layer := l.blob(t, ocispec.MediaTypeImageLayerGzip, gzipBytes(t, uncompressed))
+	if opts.layer != nil {
+		opts.layer(&layer)
+	}
+
+	diffID := godigest.FromBytes(uncompressed)
+	if opts.diffID != "" {
+		diffID = opts.diffID
+	}
+	platform := ocispec.Platform{Architecture: "amd64", OS: "linux"}
+	config := l.json(t, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: platform,
+		RootFS: ocispec.RootFS{
+			Type:    "layers",
+			DiffIDs: []godigest.Digest{diffID},
+		},
+	})
+
+	manifest := l.json(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+		Subject:   opts.subject,
+	})
+	manifest.Platform = &platform
+	return manifest
+}
+
+func validationErrors(t *testing.T, err error) ocivalidate.Errors {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var errs ocivalidate.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("unexpected non-validation error: %v", err)
+	}
+	return errs
+}
+
+func expectError(t *testing.T, err error, substr string) {
+	t.Helper()
+	errs := validationErrors(t, err)
+	for _, e := range errs {
+		if strings.Contains(e.Error(), substr) {
+			return
+		}
+	}
+	t.Fatalf("expected an error containing %q, got: %v", substr, err)
+}
+
+func TestValidateLayout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("valid", func(t *testing.T) {
+		l := newTestLayout(t)
+		image := l.image(t, testImageOptions{})
+		index := l.json(t, ocispec.MediaTypeImageIndex, ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: []ocispec.Descriptor{image},
+		})
+		index.Annotations = map[string]string{ocispec.AnnotationRefName: "latest"}
+		l.index(t, index)
+
+		if err := ocivalidate.ValidateLayout(ctx, string(l), nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// the same thing via a reference to the tag (remote-style)
+		ref, err := registry.ParseRef("oci:" + string(l) + ":latest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ocivalidate.Validate(ctx, ref, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("not a layout", func(t *testing.T) {
+		err := ocivalidate.ValidateLayout(ctx, t.TempDir(), nil)
+		expectError(t, err, "oci-layout: missing")
+		expectError(t, err, "index.json: missing")
+	})
+
+	t.Run("diff_id mismatch", func(t *testing.T) {
+		l := newTestLayout(t)
+		l.index(t, l.image(t, testImageOptions{diffID: godigest.FromString("wrong")}))
+		expectError(t, ocivalidate.ValidateLayout(ctx, string(l), nil), "diff_id mismatch")
+
+		// we can't notice if we don't download layers
+		if err := ocivalidate.ValidateLayout(ctx, string(l), &ocivalidate.Options{SkipLayerContent: true}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("corrupted layer", func(t *testing.T) {
+		l := newTestLayout(t)
+		image := l.image(t, testImageOptions{layer: func(desc *ocispec.Descriptor) {
+			if err := os.WriteFile(l.blobPath(*desc), []byte("corrupted!"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}})
+		l.index(t, image)
+		err := ocivalidate.ValidateLayout(ctx, string(l), nil)
+		expectError(t, err, "size mismatch")
+		expectError(t, err, "digest mismatch")
+	})
+
+	t.Run("bad descriptors", func(t *testing.T) {
+		l := newTestLayout(t)
+		image := l.image(t, testImageOptions{layer: func(desc *ocispec.Descriptor) {
+			desc.MediaType = "application/octet-stream"
+			desc.URLs = []string{"https://example.com/layer"}
+		}})
+		image.Platform = &ocispec.Platform{Architecture: "arm64", OS: "linux"}
+		l.index(t, image)
+		err := ocivalidate.ValidateLayout(ctx, string(l), nil)
+		expectError(t, err, ".layers[0].mediaType: invalid")
+		expectError(t, err, ".layers[0].urls: urls are not allowed")
+		expectError(t, err, ".architecture: does not match descriptor platform architecture")
+	})
+
+	t.Run("inline data", func(t *testing.T) {
+		l := newTestLayout(t)
+		image := l.image(t, testImageOptions{layer: func(desc *ocispec.Descriptor) {
+			b, err := os.ReadFile(l.blobPath(*desc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			desc.Data = b
+			// the blob only exists inline now
+			if err := os.Remove(l.blobPath(*desc)); err != nil {
+				t.Fatal(err)
+			}
+		}})
+		l.index(t, image)
+		if err := ocivalidate.ValidateLayout(ctx, string(l), nil); err != nil {
+			t.Fatal(err)
+		}
+
+		l = newTestLayout(t)
+		image = l.image(t, testImageOptions{layer: func(desc *ocispec.Descriptor) {
+			desc.Data = bytes.Repeat([]byte{'x'}, int(desc.Size))
+		}})
+		l.index(t, image)
+		expectError(t, ocivalidate.ValidateLayout(ctx, string(l), nil), ".layers[0].data: digest mismatch")
+	})
+
+	t.Run("subject", func(t *testing.T) {
+		l := newTestLayout(t)
+		subject := l.image(t, testImageOptions{diffID: godigest.FromString("wrong")})
+		subject.Platform = nil
+
+		// a dangling subject is fine
+		dangling := subject
+		dangling.Digest = godigest.FromString("dangling")
+		l.index(t, l.image(t, testImageOptions{subject: &dangling}))
+		if err := ocivalidate.ValidateLayout(ctx, string(l), nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// but a subject that exists gets validated too
+		l.index(t, l.image(t, testImageOptions{subject: &subject}))
+		expectError(t, ocivalidate.ValidateLayout(ctx, string(l), nil), "diff_id mismatch")
+	})
+	t.Run("missing subject referenced again", func(t *testing.T) {
+		l := newTestLayout(t)
+		dangling := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    godigest.FromString("dangling"),
+			Size:      1234,
+			Platform:  &ocispec.Platform{Architecture: "amd64", OS: "linux"},
+		}
+		// the first reference is an (optional) subject, but the second one is required, so it still has to be reported
+		l.index(t, l.image(t, testImageOptions{subject: &dangling}), dangling)
+		expectError(t, ocivalidate.ValidateLayout(ctx, string(l), nil), dangling.Digest.String()+" not found")
+	})
+
+	t.Run("attestation annotations", func(t *testing.T) {
+		for _, tc := range []struct {
+			name        string
+			annotations func(subject ocispec.Descriptor) map[string]string
+			err         string
+		}{
+			{
+				name: "digest only",
+				annotations: func(subject ocispec.Descriptor) map[string]string {
+					return map[string]string{"vnd.docker.reference.digest": subject.Digest.String()}
+				},
+				err: `"vnd.docker.reference.type"]: missing (valid: attestation-manifest)`,
+			},
+			{
+				name: "wrong type",
+				annotations: func(subject ocispec.Descriptor) map[string]string {
+					return map[string]string{"vnd.docker.reference.type": "signature", "vnd.docker.reference.digest": subject.Digest.String()}
+				},
+				err: `"vnd.docker.reference.type"]: invalid (valid: attestation-manifest)`,
+			},
+			{
+				name: "type only",
+				annotations: func(subject ocispec.Descriptor) map[string]string {
+					return map[string]string{"vnd.docker.reference.type": "attestation-manifest"}
+				},
+				err: "attestation manifests must reference a subject",
+			},
+			{
+				name: "subject elsewhere",
+				annotations: func(subject ocispec.Descriptor) map[string]string {
+					return map[string]string{"vnd.docker.reference.type": "signature", "vnd.docker.reference.digest": godigest.FromString("elsewhere").String()}
+				},
+				err: "attestation subject is not in this index",
+			},
+		} {
+			tc := tc // https://github.com/golang/go/issues/60078
+			t.Run(tc.name, func(t *testing.T) {
+				l := newTestLayout(t)
+				image := l.image(t, testImageOptions{})
+				attestation := l.image(t, testImageOptions{})
+				attestation.Annotations = tc.annotations(image)
+				l.index(t, image, attestation)
+				err := ocivalidate.ValidateLayout(ctx, string(l), nil)
+				expectError(t, err, tc.err)
+				// either annotation makes it an attestation, so the rest of the attestation rules apply too
+				expectError(t, err, "attestation platform must be unknown/unknown")
+			})
+		}
+	})
+}
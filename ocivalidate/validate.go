@@ -0,0 +1,514 @@
+package ocivalidate
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/docker-library/meta-scripts/registry"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// validates an entire OCI image layout directory ("oci-layout", "index.json", and everything "index.json" references, recursively); the returned error is an [Errors] if the only problems are validation failures
+func ValidateLayout(ctx context.Context, dir string, opts *Options) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	v := newValidator(registry.Reference{Host: registry.OCILayoutHost, Repository: dir}, opts)
+
+	// https://github.com/opencontainers/image-spec/blob/v1.1.1/image-layout.md#oci-layout-file
+	layoutFile := filepath.Join(dir, ocispec.ImageLayoutFile)
+	if b, err := os.ReadFile(layoutFile); errors.Is(err, os.ErrNotExist) {
+		v.errorf(layoutFile, "", nil, "missing (not an OCI image layout?)")
+	} else if err != nil {
+		return err
+	} else if layout, err := decodeJSON(b); err != nil {
+		v.errorf(layoutFile, "", nil, "invalid JSON: %v", err)
+	} else if obj, ok := layout.(map[string]any); !ok {
+		v.errorf(layoutFile, "", layout, "must be an object")
+	} else if version := obj["imageLayoutVersion"]; version != ocispec.ImageLayoutVersion {
+		v.errorf(layoutFile, ".imageLayoutVersion", version, "must be %q", ocispec.ImageLayoutVersion)
+	}
+
+	// https://github.com/opencontainers/image-spec/blob/v1.1.1/image-layout.md#indexjson-file
+	indexFile := filepath.Join(dir, "index.json")
+	if b, err := os.ReadFile(indexFile); errors.Is(err, os.ErrNotExist) {
+		v.errorf(indexFile, "", nil, "missing (not an OCI image layout?)")
+	} else if err != nil {
+		return err
+	} else if index, err := decodeJSON(b); err != nil {
+		v.errorf(indexFile, "", nil, "invalid JSON: %v", err)
+	} else if obj, ok := index.(map[string]any); !ok {
+		v.errorf(indexFile, "", index, "must be an object")
+	} else {
+		// https://github.com/opencontainers/image-spec/blob/v1.1.1/image-layout.md#index-example ("platform" is not required in "index.json")
+		v.index(ctx, indexFile, obj, true)
+	}
+
+	return v.result()
+}
+
+// fetch (and verify) the content of the given descriptor, returning nil if it could not be fetched (or was invalid); if "optional" is set, a missing object is not an error (dangling "subject", etc)
+func (v *validator) fetch(ctx context.Context, object, path string, desc descriptor, lookupType registry.LookupType, optional bool) []byte {
+	if v.err != nil {
+		return nil
+	}
+	ref := v.repo
+	ref.Digest = desc.Digest
+	name := ref.String()
+
+	if desc.hasData {
+		// "data" has to match the descriptor regardless of whether the registry also has the object
+		v.verify(object, pathKey(path, "data"), desc, desc.Data)
+	}
+
+	r, err := registry.Lookup(ctx, ref, &registry.LookupOptions{Type: lookupType})
+	if err != nil {
+		v.err = fmt.Errorf("%s: lookup failed: %w", name, err)
+		return nil
+	}
+	if r == nil {
+		if desc.hasData {
+			// if the object only exists inline, that's fine (and we've already verified it)
+			return desc.Data
+		}
+		if !optional {
+			v.errorf(object, path, nil, "%s not found", name)
+		}
+		return nil
+	}
+	defer r.Close()
+
+	// limit how much we're willing to read into memory (anything larger than the descriptor says is invalid anyhow)
+	b, err := io.ReadAll(io.LimitReader(r, desc.Size+1))
+	if err != nil {
+		v.err = fmt.Errorf("%s: read failed: %w", name, err)
+		return nil
+	}
+	if !v.verify(name, "", desc, b) {
+		return nil
+	}
+	return b
+}
+
+// verify the size and digest of "content" match "desc"
+func (v *validator) verify(object, path string, desc descriptor, content []byte) bool {
+	algorithm := digest.Digest(desc.Digest).Algorithm()
+	if !algorithm.Available() {
+		v.errorf(object, path, nil, "unable to verify %s content (unsupported algorithm)", algorithm)
+		return false
+	}
+	ok := true
+	if size := int64(len(content)); size != desc.Size {
+		v.errorf(object, path, nil, "size mismatch: expected %d, got %d", desc.Size, size)
+		ok = false
+	}
+	if d := algorithm.FromBytes(content); string(d) != string(desc.Digest) {
+		v.errorf(object, path, nil, "digest mismatch: expected %s, got %s", desc.Digest, d)
+		ok = false
+	}
+	return ok
+}
+
+// validate_oci_subject
+func (v *validator) subject(ctx context.Context, object, path string, obj map[string]any) {
+	val, ok := obj["subject"]
+	if !ok {
+		return
+	}
+	path = pathKey(path, "subject")
+	desc, ok := v.descriptor(object, path, val)
+	if !ok {
+		return
+	}
+	if v.in(object, pathKey(path, "mediaType"), desc.MediaType, append(slices.Clone(mediaTypesIndex), mediaTypesImage...)...) {
+		// "subject" is allowed to point to something that doesn't exist (yet), but if it does exist, it'd better be valid
+		v.manifest(ctx, object, path, desc, manifestOptions{optional: true})
+	}
+}
+
+type manifestOptions struct {
+	attestation bool // whether the manifest is a buildkit attestation manifest (which have special rules for their layers)
+	optional    bool // whether it's OK for the manifest to not exist
+	root        bool // whether the descriptor came from the registry instead of a parent object (and thus can't tell us anything about artifactType)
+}
+
+// fetch and validate a manifest (index or image) given the descriptor that points to it
+func (v *validator) manifest(ctx context.Context, parentObject, path string, desc descriptor, opts manifestOptions) {
+	if v.err != nil || v.seen[desc.Digest] {
+		return
+	}
+
+	b := v.fetch(ctx, parentObject, path, desc, registry.LookupTypeManifest, opts.optional)
+	if b == nil {
+		// (not "seen" yet, so that if this was an optional reference, a later required reference to the same digest still gets its "not found")
+		return
+	}
+	v.seen[desc.Digest] = true
+	object := v.object(desc.Digest)
+
+	val, err := decodeJSON(b)
+	if err != nil {
+		v.errorf(object, "", nil, "invalid JSON: %v", err)
+		return
+	}
+	obj, ok := val.(map[string]any)
+	if !ok {
+		v.errorf(object, "", val, "manifest must be an object")
+		return
+	}
+
+	// the descriptor and the object have to agree (when the descriptor says anything at all)
+	mediaType, _ := obj["mediaType"].(string)
+	if desc.MediaType != "" && mediaType != desc.MediaType {
+		v.errorf(object, ".mediaType", obj["mediaType"], "does not match descriptor mediaType %q", desc.MediaType)
+	}
+	artifactType, _ := obj["artifactType"].(string)
+	if !opts.root && artifactType != desc.ArtifactType {
+		v.errorf(object, ".artifactType", obj["artifactType"], "does not match descriptor artifactType %q", desc.ArtifactType)
+	}
+
+	switch {
+	case slices.Contains(mediaTypesIndex, mediaType):
+		v.index(ctx, object, obj, false)
+	case slices.Contains(mediaTypesImage, mediaType):
+		v.image(ctx, object, obj, desc, opts.attestation)
+	default:
+		v.in(object, ".mediaType", mediaType, append(slices.Clone(mediaTypesIndex), mediaTypesImage...)...)
+	}
+}
+
+func (v *validator) schemaVersion(object string, obj map[string]any) {
+	if version, ok := obj["schemaVersion"].(json.Number); !ok || version.String() != "2" {
+		v.errorf(object, ".schemaVersion", obj["schemaVersion"], "schemaVersion must be 2")
+	}
+}
+
+// validate_oci_index
+func (v *validator) index(ctx context.Context, object string, obj map[string]any, platformsOptional bool) {
+	v.schemaVersion(object, obj)
+	mediaType, _ := obj["mediaType"].(string)
+	v.in(object, ".mediaType", mediaType, mediaTypesIndex...)
+	if val, ok := obj["artifactType"]; ok {
+		// TODO are there cases where we should allow artifactType on an index?
+		v.errorf(object, ".artifactType", val, "artifactType is not allowed on an index")
+	}
+
+	arr, path := v.descriptors(object, "", obj, "manifests")
+
+	type child struct {
+		path string
+		desc descriptor
+		opts manifestOptions
+	}
+	var (
+		children []child
+		digests  = map[ociregistry.Digest]bool{}
+	)
+	for i, val := range arr {
+		descPath := pathIndex(path, i)
+		desc, ok := v.descriptor(object, descPath, val)
+		if !ok {
+			continue
+		}
+		digests[desc.Digest] = true
+		children = append(children, child{path: descPath, desc: desc})
+	}
+
+	for i := range children {
+		c := &children[i]
+		desc := c.desc
+		v.in(object, pathKey(c.path, "mediaType"), desc.MediaType, append(slices.Clone(mediaTypesIndex), mediaTypesImage...)...)
+		if desc.Size <= manifestSizeMinimumExcluded {
+			v.errorf(object, pathKey(c.path, "size"), desc.Size, "manifest size must be greater than %d", manifestSizeMinimumExcluded)
+		} else if desc.Size > manifestSizeLimit {
+			v.errorf(object, pathKey(c.path, "size"), desc.Size, "manifest size must be at most %d (4MiB)", manifestSizeLimit)
+		}
+		if desc.Platform == nil && !platformsOptional {
+			v.errorf(object, pathKey(c.path, "platform"), nil, "platform is required")
+		}
+
+		refType, hasRefType := desc.Annotations[annotationReferenceType]
+		refDigest, hasRefDigest := desc.Annotations[annotationReferenceDigest]
+		if hasRefType || hasRefDigest {
+			// https://github.com/moby/buildkit/blob/c6145c2423de48f891862ac02f9b2653864d3c9e/docs/attestations/attestation-storage.md#attestation-manifest-descriptor
+			// (either annotation is enough to make this an attestation descriptor, and then it has to have both -- just like "validate_oci_index" in "oci.jq")
+			c.opts.attestation = true
+			v.in(object, pathKey(pathKey(c.path, "annotations"), annotationReferenceType), refType, referenceTypeAttestation)
+			if desc.MediaType != ocispec.MediaTypeImageManifest {
+				v.errorf(object, pathKey(c.path, "mediaType"), desc.MediaType, "attestation manifests must be %q", ocispec.MediaTypeImageManifest)
+			}
+			if desc.ArtifactType != "" && desc.ArtifactType != artifactTypeAttestation {
+				v.errorf(object, pathKey(c.path, "artifactType"), desc.ArtifactType, "attestation artifactType must be %q (or missing)", artifactTypeAttestation)
+			}
+			refPath := pathKey(pathKey(c.path, "annotations"), annotationReferenceDigest)
+			if !hasRefDigest {
+				v.errorf(object, refPath, nil, "attestation manifests must reference a subject")
+			} else if d, ok := v.digest(object, refPath, refDigest); ok && !digests[d] {
+				v.errorf(object, refPath, refDigest, "attestation subject is not in this index")
+			}
+			if p := desc.Platform; p == nil || p.OS != "unknown" || p.Architecture != "unknown" {
+				v.errorf(object, pathKey(c.path, "platform"), p, "attestation platform must be unknown/unknown")
+			}
+		} else if desc.ArtifactType != "" {
+			// TODO are there cases where we should allow artifactType on a descriptor in an index?
+			v.errorf(object, pathKey(c.path, "artifactType"), desc.ArtifactType, "artifactType is not allowed here")
+		}
+	}
+
+	v.subject(ctx, object, "", obj)
+	v.annotations(object, "", obj)
+
+	for _, c := range children {
+		v.manifest(ctx, object, c.path, c.desc, c.opts)
+	}
+}
+
+// validate_oci_image
+func (v *validator) image(ctx context.Context, object string, obj map[string]any, desc descriptor, attestation bool) {
+	v.schemaVersion(object, obj)
+	mediaType, _ := obj["mediaType"].(string)
+	v.in(object, ".mediaType", mediaType, mediaTypesImage...)
+	if val, ok := obj["artifactType"]; ok && !(attestation && val == artifactTypeAttestation) {
+		// TODO are there cases where we should allow other artifactType values?
+		v.errorf(object, ".artifactType", val, "artifactType is not allowed on an image (except %q on attestations)", artifactTypeAttestation)
+	}
+
+	config, configOK := v.descriptor(object, ".config", obj["config"])
+	if configOK {
+		if config.Size < configSizeMinimum {
+			v.errorf(object, ".config.size", config.Size, "config size must be at least %d", configSizeMinimum)
+		}
+		v.in(object, ".config.mediaType", config.MediaType, mediaTypesConfig...)
+		if config.ArtifactType != "" {
+			v.errorf(object, ".config.artifactType", config.ArtifactType, "artifactType is not allowed here")
+		}
+	}
+
+	arr, path := v.descriptors(object, "", obj, "layers")
+	var (
+		layers     []descriptor
+		layerPaths []string
+	)
+	for i, val := range arr {
+		layerPath := pathIndex(path, i)
+		layer, ok := v.descriptor(object, layerPath, val)
+		if ok {
+			layers = append(layers, layer)
+			layerPaths = append(layerPaths, layerPath)
+		}
+		if attestation {
+			if v.in(object, pathKey(layerPath, "mediaType"), layer.MediaType, mediaTypeInToto) {
+				v.in(object, pathKey(pathKey(layerPath, "annotations"), annotationInTotoPredicate), layer.Annotations[annotationInTotoPredicate], inTotoPredicateTypes...)
+			}
+		} else {
+			v.in(object, pathKey(layerPath, "mediaType"), layer.MediaType, mediaTypesLayer...)
+		}
+		if layer.ArtifactType != "" {
+			v.errorf(object, pathKey(layerPath, "artifactType"), layer.ArtifactType, "artifactType is not allowed here")
+		}
+	}
+
+	v.subject(ctx, object, "", obj)
+	v.annotations(object, "", obj)
+
+	var diffIDs []digest.Digest
+	if configOK {
+		diffIDs = v.config(ctx, object, config, desc.Platform, len(arr), attestation)
+	}
+
+	for i, layer := range layers {
+		var diffID digest.Digest
+		if !attestation && len(diffIDs) == len(arr) {
+			diffID = diffIDs[i]
+		}
+		v.layer(ctx, object, layerPaths[i], layer, diffID)
+	}
+}
+
+// fetch and validate an image config, returning the list of diff_ids (if any)
+func (v *validator) config(ctx context.Context, object string, desc descriptor, platform *ocispec.Platform, numLayers int, attestation bool) []digest.Digest {
+	b := v.fetch(ctx, object, ".config", desc, registry.LookupTypeBlob, false)
+	if b == nil {
+		return nil
+	}
+	if attestation {
+		// attestation configs are just "{...}" with no particular structure (buildkit stuffs a copy of the platform in there, but nothing else)
+		return nil
+	}
+	configObject := v.object(desc.Digest)
+
+	var config ocispec.Image
+	if err := json.Unmarshal(b, &config); err != nil {
+		v.errorf(configObject, "", nil, "invalid image config: %v", err)
+		return nil
+	}
+
+	if config.RootFS.Type != "layers" {
+		v.errorf(configObject, ".rootfs.type", config.RootFS.Type, `rootfs.type must be "layers"`)
+	}
+	if len(config.RootFS.DiffIDs) != numLayers {
+		v.errorf(configObject, ".rootfs.diff_ids", nil, "number of diff_ids (%d) does not match number of layers (%d) in %s", len(config.RootFS.DiffIDs), numLayers, object)
+	}
+	if len(config.History) > 0 {
+		nonEmpty := 0
+		for _, h := range config.History {
+			if !h.EmptyLayer {
+				nonEmpty++
+			}
+		}
+		if nonEmpty != len(config.RootFS.DiffIDs) {
+			v.errorf(configObject, ".history", nil, "number of non-empty history entries (%d) does not match number of diff_ids (%d)", nonEmpty, len(config.RootFS.DiffIDs))
+		}
+	}
+
+	if platform != nil {
+		if config.OS != platform.OS {
+			v.errorf(configObject, ".os", config.OS, "does not match descriptor platform os %q", platform.OS)
+		}
+		if config.Architecture != platform.Architecture {
+			v.errorf(configObject, ".architecture", config.Architecture, "does not match descriptor platform architecture %q", platform.Architecture)
+		}
+		// variant and os.version are frequently missing from one side or the other, so we only compare them when both are set
+		if config.Variant != "" && platform.Variant != "" && config.Variant != platform.Variant {
+			v.errorf(configObject, ".variant", config.Variant, "does not match descriptor platform variant %q", platform.Variant)
+		}
+		if config.OSVersion != "" && platform.OSVersion != "" && config.OSVersion != platform.OSVersion {
+			v.errorf(configObject, `["os.version"]`, config.OSVersion, "does not match descriptor platform os.version %q", platform.OSVersion)
+		}
+	}
+
+	return config.RootFS.DiffIDs
+}
+
+// verify a layer exists (and, unless [Options.SkipLayerContent], that its content matches both the descriptor and the diff_id from the config)
+func (v *validator) layer(ctx context.Context, object, path string, desc descriptor, diffID digest.Digest) {
+	if v.err != nil {
+		return
+	}
+	if desc.hasData {
+		v.verify(object, pathKey(path, "data"), desc, desc.Data)
+	}
+	if diffID != "" && diffID.Validate() != nil {
+		v.errorf(object, path, diffID, "invalid diff_id")
+		diffID = ""
+	}
+
+	// layers are frequently shared between images, so we only download each one once (and remember what it decompresses to, since each config that uses it still needs to agree)
+	uncompressed, seen := v.layers[desc.Digest]
+	if !seen {
+		uncompressed = v.layerContent(ctx, object, path, desc)
+		v.layers[desc.Digest] = uncompressed
+	}
+	if diffID != "" && uncompressed != "" && diffID.Algorithm() == uncompressed.Algorithm() && diffID != uncompressed {
+		ref := v.repo
+		ref.Digest = desc.Digest
+		v.errorf(object, path, nil, "diff_id mismatch: config says %s, uncompressed content of %s is %s", diffID, ref, uncompressed)
+	}
+}
+
+// fetch (or HEAD, if [Options.SkipLayerContent]) and verify a layer, returning the (sha256) digest of its uncompressed content (if we could calculate it)
+func (v *validator) layerContent(ctx context.Context, object, path string, desc descriptor) digest.Digest {
+	ref := v.repo
+	ref.Digest = desc.Digest
+	name := ref.String()
+
+	r, err := registry.Lookup(ctx, ref, &registry.LookupOptions{Type: registry.LookupTypeBlob, Head: v.opts.SkipLayerContent})
+	if err != nil {
+		v.err = fmt.Errorf("%s: lookup failed: %w", name, err)
+		return ""
+	}
+	if r == nil {
+		if !desc.hasData {
+			v.errorf(object, path, nil, "%s not found", name)
+			return ""
+		}
+		r = inlineBlobReader(desc)
+	}
+	defer r.Close()
+
+	if v.opts.SkipLayerContent && !desc.hasData {
+		if d := r.Descriptor(); d.Size != desc.Size {
+			v.errorf(name, "", nil, "size mismatch: expected %d, got %d", desc.Size, d.Size)
+		}
+		return ""
+	}
+
+	algorithm := digest.Digest(desc.Digest).Algorithm()
+	if !algorithm.Available() {
+		v.errorf(name, "", nil, "unable to verify %s content (unsupported algorithm)", algorithm)
+		return ""
+	}
+	var (
+		digester = algorithm.Digester()
+		diffIDer = digest.Canonical.Digester()
+		counter  = &countWriter{}
+		body     = io.TeeReader(r, io.MultiWriter(digester.Hash(), counter))
+		diffErr  error
+	)
+	if strings.HasSuffix(desc.MediaType, "+gzip") || strings.HasSuffix(desc.MediaType, ".gzip") {
+		if gz, err := gzip.NewReader(body); err != nil {
+			diffErr = err
+		} else {
+			_, diffErr = io.Copy(diffIDer.Hash(), gz)
+		}
+	} else {
+		_, diffErr = io.Copy(diffIDer.Hash(), body)
+	}
+	// make sure we read the whole compressed blob (gzip might stop early, especially if the blob isn't valid gzip)
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		v.err = fmt.Errorf("%s: read failed: %w", name, err)
+		return ""
+	}
+
+	ok := true
+	if counter.n != desc.Size {
+		v.errorf(name, "", nil, "size mismatch: expected %d, got %d", desc.Size, counter.n)
+		ok = false
+	}
+	if d := digester.Digest(); string(d) != string(desc.Digest) {
+		v.errorf(name, "", nil, "digest mismatch: expected %s, got %s", desc.Digest, d)
+		ok = false
+	}
+	if diffErr != nil {
+		v.errorf(name, "", nil, "failed to decompress layer: %v", diffErr)
+		ok = false
+	}
+	if !ok {
+		return ""
+	}
+	return diffIDer.Digest()
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// an [ociregistry.BlobReader] for a descriptor's inline "data"
+func inlineBlobReader(desc descriptor) ociregistry.BlobReader {
+	return inlineBlob{Reader: strings.NewReader(string(desc.Data)), desc: desc.Descriptor}
+}
+
+type inlineBlob struct {
+	*strings.Reader
+	desc ociregistry.Descriptor
+}
+
+func (b inlineBlob) Close() error                       { return nil }
+func (b inlineBlob) Descriptor() ociregistry.Descriptor { return b.desc }
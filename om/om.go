@@ -22,7 +22,12 @@ func (m OrderedMap[T]) Get(key string) T {
 	return m.m[key]
 }
 
-// TODO Has()?  two-return form of Get?  (we don't need either right now)
+func (m OrderedMap[T]) Has(key string) bool {
+	_, ok := m.m[key]
+	return ok
+}
+
+// TODO two-return form of Get?  (we don't need it right now)
 
 func (m *OrderedMap[T]) Set(key string, val T) { // TODO make this variadic so it can take an arbitrary number of pairs?  (would be useful for tests, but we don't need something like that right now)
 	if m.m == nil || m.keys == nil {
@@ -35,6 +40,21 @@ func (m *OrderedMap[T]) Set(key string, val T) { // TODO make this variadic so i
 	m.m[key] = val
 }
 
+func (m *OrderedMap[T]) Delete(key string) {
+	if _, ok := m.m[key]; !ok {
+		return
+	}
+	delete(m.m, key)
+	// (we have to make a new slice here so we don't modify the backing array of a copy of this map -- see Keys)
+	keys := make([]string, 0, len(m.keys)-1)
+	for _, k := range m.keys {
+		if k != key {
+			keys = append(keys, k)
+		}
+	}
+	m.keys = keys
+}
+
 func (m *OrderedMap[T]) UnmarshalJSON(b []byte) error {
 	dec := json.NewDecoder(bytes.NewReader(b))
 
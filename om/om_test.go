@@ -53,6 +53,24 @@ func TestOrderedMapSet(t *testing.T) {
 	assert(t, keys[0], "c")
 }
 
+func TestOrderedMapDelete(t *testing.T) {
+	var m om.OrderedMap[string]
+	m.Delete("a") // deleting from an empty map is a no-op
+	assertJSON(t, m, `{}`)
+	m.Set("a", "a")
+	m.Set("b", "b")
+	m.Set("c", "c")
+	assert(t, m.Has("b"), true)
+	m.Delete("b")
+	assert(t, m.Has("b"), false)
+	assert(t, m.Get("b"), "")
+	assertJSON(t, m, `{"a":"a","c":"c"}`)
+	m.Delete("d")
+	assertJSON(t, m, `{"a":"a","c":"c"}`)
+	m.Set("b", "d") // re-adding a deleted key puts it at the end
+	assertJSON(t, m, `{"a":"a","c":"c","b":"d"}`)
+}
+
 func TestOrderedMapUnmarshal(t *testing.T) {
 	var m om.OrderedMap[string]
 	assert(t, json.Unmarshal([]byte(`{}`), &m), nil)
@@ -0,0 +1,285 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/docker-library/meta-scripts/om"
+)
+
+// this is a port of the queue bits of "jenkins.jq" ("get_arch_queue", "jobs_record", and "filter_skips_queue") -- builds and job records are handled as (ordered) generic JSON objects so that the field ordering (and any fields we don't know about, like the "lastTime" and "url" that Jenkins adds to job records) round-trip exactly the same way they do in jq
+
+// a generic JSON object (with preserved key ordering)
+type jsonObject = om.OrderedMap[json.RawMessage]
+
+// a "builds.json" entry (the full object, plus the subset of it that we actually need to look at for queueing purposes)
+type Build struct {
+	BuildID string           `json:"buildId"`
+	Error   *json.RawMessage `json:"error"`
+	Build   struct {
+		Arch     string           `json:"arch"`
+		Resolved *json.RawMessage `json:"resolved"`
+
+		// (only "platform" objects of the parents are interesting, for "windowsVersion")
+		ResolvedParents om.OrderedMap[struct {
+			Manifests []struct {
+				Platform map[string]any `json:"platform"`
+			} `json:"manifests"`
+		}] `json:"resolvedParents"`
+	} `json:"build"`
+	Source struct {
+		Arches map[string]struct {
+			Tags []string `json:"tags"`
+		} `json:"arches"`
+	} `json:"source"`
+
+	object jsonObject
+}
+
+func (b *Build) UnmarshalJSON(data []byte) error {
+	type build Build // (no methods, so this doesn't recurse)
+	if err := json.Unmarshal(data, (*build)(b)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &b.object)
+}
+
+func (b Build) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.object)
+}
+
+// parse a full "builds.json" document (preserving the order of the builds, which affects the order of the queue)
+func ParseBuilds(b []byte) (om.OrderedMap[Build], error) {
+	var builds om.OrderedMap[Build]
+	err := json.Unmarshal(b, &builds)
+	return builds, err
+}
+
+// port of "needs_build" from "meta.jq"
+func (b Build) NeedsBuild() bool {
+	return (b.Build.Resolved == nil || string(*b.Build.Resolved) == "null") && (b.Error == nil || string(*b.Error) == "null")
+}
+
+// the first tag of the build (what Jenkins calls it); nil if it doesn't have any (which is null in jq)
+func (b Build) identifier() *string {
+	if tags := b.Source.Arches[b.Build.Arch].Tags; len(tags) > 0 {
+		return &tags[0]
+	}
+	return nil
+}
+
+// https://learn.microsoft.com/en-us/virtualization/windowscontainers/deploy-containers/base-image-lifecycle
+// https://github.com/microsoft/hcsshim/blob/d9a4231b9d7a03dffdabb6019318fc43eb6ba996/osversion/windowsbuilds.go
+var windowsBuildRegexp = regexp.MustCompile(`^10[.]0[.]([0-9]+)([.]|$)`)
+
+// since this is specifically for GitHub Actions support, this is limited to the underlying versions they actually support
+// https://docs.github.com/en/actions/using-github-hosted-runners/about-github-hosted-runners#supported-runners-and-hardware-resources
+var windowsVersions = map[string]string{
+	"26100": "2025", // https://oci.dag.dev/?image=mcr.microsoft.com/windows/servercore:ltsc2025
+	"20348": "2022", // https://oci.dag.dev/?image=mcr.microsoft.com/windows/servercore:ltsc2022
+	"17763": "2019", // https://oci.dag.dev/?image=mcr.microsoft.com/windows/servercore:ltsc2019
+}
+
+// port of "windows_version" from "jenkins.jq": "2022", "2025", etc (or "unknown") based on the first "os.version" of any parent, or "" if there isn't one (like for Linux); "ok" is false if there was an "os.version" that doesn't look like Windows at all (which in jq results in "empty", and thus the build silently falling out of the queue)
+func (b Build) WindowsVersion() (version string, ok bool) {
+	var osVersion any
+	found := false
+	for _, from := range b.Build.ResolvedParents.Keys() {
+		for _, m := range b.Build.ResolvedParents.Get(from).Manifests {
+			if v, has := m.Platform["os.version"]; has {
+				osVersion, found = v, true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	s, _ := osVersion.(string)
+	if s == "" {
+		return "", true
+	}
+	match := windowsBuildRegexp.FindStringSubmatch(s)
+	if match == nil {
+		return "", false
+	}
+	if version, ok := windowsVersions[match[1]]; ok {
+		return version, true
+	}
+	return "unknown", true
+}
+
+// a build in the queue (see [ArchQueue])
+type Item struct {
+	BuildID    string
+	Identifier *string
+
+	// the full build object, plus "windowsVersion" (when applicable) and "identifier"
+	object jsonObject
+}
+
+func (i Item) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.object)
+}
+
+// port of "get_arch_queue" from "jenkins.jq": every build of the given architecture that still needs to be built (keyed and ordered the same as "builds.json")
+func ArchQueue(builds om.OrderedMap[Build], arch string) (om.OrderedMap[Item], error) {
+	var queue om.OrderedMap[Item]
+	for _, key := range builds.Keys() {
+		build := builds.Get(key)
+		if !build.NeedsBuild() || build.Build.Arch != arch {
+			continue
+		}
+
+		windowsVersion, ok := build.WindowsVersion()
+		if !ok {
+			continue
+		}
+
+		item := Item{
+			BuildID:    build.BuildID,
+			Identifier: build.identifier(),
+		}
+		for _, field := range build.object.Keys() {
+			item.object.Set(field, build.object.Get(field))
+		}
+		if windowsVersion != "" {
+			b, err := json.Marshal(windowsVersion)
+			if err != nil {
+				return queue, err
+			}
+			item.object.Set("windowsVersion", b)
+		}
+		b, err := json.Marshal(item.Identifier)
+		if err != nil {
+			return queue, err
+		}
+		item.object.Set("identifier", b)
+
+		queue.Set(key, item)
+	}
+	return queue, nil
+}
+
+// the delay before each attempt at a build, by how many times it has been attempted already (the last entry applies to every attempt after that)
+type Backoff []time.Duration
+
+// "if we have only tried once or twice, we can try again with no delay", then exponential backoff (maxing out at 32 hours)
+var DefaultBackoff = Backoff{0, 0, 1 * time.Hour, 2 * time.Hour, 4 * time.Hour, 8 * time.Hour, 16 * time.Hour, 32 * time.Hour}
+
+// the delay required after the given number of previous attempts
+func (b Backoff) Interval(count int) time.Duration {
+	if len(b) == 0 {
+		return 0
+	}
+	return b[min(max(count, 0), len(b)-1)]
+}
+
+// a record of a build's attempts ("count", "skip", "identifier", and whatever Jenkins adds like "lastTime", "url", and "firstTime"), as generic JSON so that everything round-trips
+type Job = jsonObject
+
+// port of "jobs_record" from "jenkins.jq": for each build in the queue, its record from "pastJobs" (if any), with "skip" set if not enough time has passed since "lastTime" (see [Backoff]), and "count" incremented otherwise
+func JobsRecord(queue om.OrderedMap[Item], pastJobs om.OrderedMap[Job], now time.Time, backoff Backoff) (om.OrderedMap[Job], error) {
+	nowSeconds := float64(now.UnixNano()) / float64(time.Second)
+
+	var jobs om.OrderedMap[Job]
+	for _, key := range queue.Keys() {
+		item := queue.Get(key)
+
+		var job Job
+		if pastJobs.Has(item.BuildID) {
+			past := pastJobs.Get(item.BuildID)
+			for _, field := range past.Keys() {
+				job.Set(field, past.Get(field))
+			}
+		} else {
+			job.Set("count", json.RawMessage(`0`))
+			job.Set("skip", json.RawMessage(`false`))
+		}
+		identifier, err := json.Marshal(item.Identifier)
+		if err != nil {
+			return jobs, err
+		}
+		job.Set("identifier", identifier)
+
+		var count int
+		if err := unmarshalField(job, "count", &count); err != nil {
+			return jobs, fmt.Errorf("%s: %w", item.BuildID, err)
+		}
+		lastTime := nowSeconds // ".lastTime // $now" (so a job without a "lastTime" is skipped whenever its backoff interval is non-zero, exactly like in jq)
+		if err := unmarshalField(job, "lastTime", &lastTime); err != nil {
+			return jobs, fmt.Errorf("%s: %w", item.BuildID, err)
+		}
+
+		// skip if not enough time has elapsed
+		if nowSeconds < lastTime+backoff.Interval(count).Seconds() {
+			job.Set("skip", json.RawMessage(`true`))
+		} else {
+			job.Set("skip", json.RawMessage(`false`))
+			b, err := json.Marshal(count + 1)
+			if err != nil {
+				return jobs, err
+			}
+			job.Set("count", b)
+		}
+
+		jobs.Set(key, job)
+	}
+	return jobs, nil
+}
+
+// port of "filter_skips_queue" from "jenkins.jq": the queue without anything "jobs" says to skip, sorted by how many times each build has been attempted (so builds that keep failing always live at the bottom of the queue, with the most failing last)
+func FilterSkipsQueue(queue om.OrderedMap[Item], jobs om.OrderedMap[Job]) ([]Item, error) {
+	type sortable struct {
+		item  Item
+		count *float64 // (nil sorts first, just like null does in jq)
+	}
+	var filtered []sortable
+	for _, key := range queue.Keys() {
+		item := queue.Get(key)
+		job := jobs.Get(item.BuildID)
+
+		var skip bool
+		if err := unmarshalField(job, "skip", &skip); err != nil {
+			return nil, fmt.Errorf("%s: %w", item.BuildID, err)
+		}
+		if skip {
+			continue
+		}
+
+		var count *float64
+		if err := unmarshalField(job, "count", &count); err != nil {
+			return nil, fmt.Errorf("%s: %w", item.BuildID, err)
+		}
+		filtered = append(filtered, sortable{item: item, count: count})
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		a, b := filtered[i].count, filtered[j].count
+		if a == nil || b == nil {
+			return a == nil && b != nil
+		}
+		return *a < *b
+	})
+
+	ret := make([]Item, len(filtered))
+	for i, f := range filtered {
+		ret[i] = f.item
+	}
+	return ret, nil
+}
+
+// unmarshals the given field of "obj" into "v" (leaving "v" untouched if the field is missing or null, which is what "//" does in jq)
+func unmarshalField(obj jsonObject, field string, v any) error {
+	raw := obj.Get(field)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("failed to parse %q: %w", field, err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package queue_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker-library/meta-scripts/om"
+	"github.com/docker-library/meta-scripts/queue"
+)
+
+func assertJSON(t *testing.T, v any, want []byte) {
+	t.Helper()
+	got, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, want); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, compact.Bytes()) {
+		t.Fatalf("unexpected output\ngot:\n%s\n\nexpected:\n%s", got, compact.Bytes())
+	}
+}
+
+func buildIDs(items []queue.Item) []string {
+	ret := make([]string, len(items))
+	for i, item := range items {
+		ret[i] = item.BuildID
+	}
+	return ret
+}
+
+func parseBuilds(t *testing.T, s string) om.OrderedMap[queue.Build] {
+	t.Helper()
+	builds, err := queue.ParseBuilds([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return builds
+}
+
+// compare our output to the output of "jenkins.jq" (see ".test/meta-queue/test.jq")
+func TestGolden(t *testing.T) {
+	in, err := os.ReadFile("../.test/meta-queue/in.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var input struct {
+		PastJobs om.OrderedMap[queue.Job] `json:"pastJobs"`
+		Builds   json.RawMessage          `json:"builds"`
+	}
+	if err := json.Unmarshal(in, &input); err != nil {
+		t.Fatal(err)
+	}
+	builds, err := queue.ParseBuilds(input.Builds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "out.json" is a stream of three documents: the filtered queue, the new jobs record, and the number of skipped items
+	out, err := os.Open("../.test/meta-queue/out.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	var golden []json.RawMessage
+	dec := json.NewDecoder(out)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		golden = append(golden, raw)
+	}
+	if len(golden) != 3 {
+		t.Fatalf("expected 3 documents in out.json, got %d", len(golden))
+	}
+
+	archQueue, err := queue.ArchQueue(builds, "arm32v7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := queue.JobsRecord(archQueue, input.PastJobs, time.Unix(0, 0), queue.DefaultBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := queue.FilterSkipsQueue(archQueue, jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertJSON(t, filtered, golden[0])
+	assertJSON(t, jobs, golden[1])
+	assertJSON(t, len(archQueue.Keys())-len(filtered), golden[2])
+}
+
+func TestWindowsVersion(t *testing.T) {
+	builds := parseBuilds(t, `{
+		"linux": { "buildId": "linux", "build": { "arch": "amd64", "resolvedParents": { "debian": { "manifests": [ { "platform": { "os": "linux" } } ] } } } },
+		"ltsc2022": { "buildId": "ltsc2022", "build": { "arch": "windows-amd64", "resolvedParents": {
+			"scratch": { "manifests": [ { "platform": { "os": "windows" } } ] },
+			"servercore": { "manifests": [ { "platform": { "os": "windows", "os.version": "10.0.20348.2227" } } ] },
+			"nanoserver": { "manifests": [ { "platform": { "os": "windows", "os.version": "10.0.26100.1" } } ] }
+		} } },
+		"ltsc2025": { "buildId": "ltsc2025", "build": { "arch": "windows-amd64", "resolvedParents": { "servercore": { "manifests": [ { "platform": { "os": "windows", "os.version": "10.0.26100" } } ] } } } },
+		"future": { "buildId": "future", "build": { "arch": "windows-amd64", "resolvedParents": { "servercore": { "manifests": [ { "platform": { "os": "windows", "os.version": "10.0.99999.1" } } ] } } } },
+		"weird": { "buildId": "weird", "build": { "arch": "windows-amd64", "resolvedParents": { "servercore": { "manifests": [ { "platform": { "os": "windows", "os.version": "11.0" } } ] } } } }
+	}`)
+	for key, want := range map[string]string{
+		"linux":    "",
+		"ltsc2022": "2022", // (the first parent with an "os.version" wins)
+		"ltsc2025": "2025",
+		"future":   "unknown",
+	} {
+		if got, ok := builds.Get(key).WindowsVersion(); !ok || got != want {
+			t.Errorf("%s: expected %q, got %q (%v)", key, want, got, ok)
+		}
+	}
+	if _, ok := builds.Get("weird").WindowsVersion(); ok {
+		t.Error("weird: expected not ok")
+	}
+
+	// builds whose "os.version" isn't recognizable fall out of the queue entirely (just like they do in jq)
+	archQueue, err := queue.ArchQueue(builds, "windows-amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := archQueue.Keys(); len(got) != 3 || archQueue.Has("weird") {
+		t.Fatalf("unexpected queue: %v", got)
+	}
+	assertJSON(t, archQueue.Get("ltsc2025"), []byte(`{
+		"buildId": "ltsc2025",
+		"build": { "arch": "windows-amd64", "resolvedParents": { "servercore": { "manifests": [ { "platform": { "os": "windows", "os.version": "10.0.26100" } } ] } } },
+		"windowsVersion": "2025",
+		"identifier": null
+	}`))
+}
+
+func TestJobsRecord(t *testing.T) {
+	builds := parseBuilds(t, `{
+		"new": { "buildId": "new", "build": { "arch": "amd64", "resolved": null }, "source": { "arches": { "amd64": { "tags": [ "new:1", "new:latest" ] } } } },
+		"recent": { "buildId": "recent", "build": { "arch": "amd64" }, "source": { "arches": { "amd64": { "tags": [ "recent:1" ] } } } },
+		"old": { "buildId": "old", "build": { "arch": "amd64" }, "source": { "arches": { "amd64": { "tags": [ "old:1" ] } } } },
+		"stale": { "buildId": "stale", "build": { "arch": "amd64" }, "source": { "arches": { "amd64": { "tags": [ "stale:1" ] } } } },
+		"done": { "buildId": "done", "build": { "arch": "amd64", "resolved": {} }, "source": { "arches": { "amd64": { "tags": [ "done:1" ] } } } }
+	}`)
+	var pastJobs om.OrderedMap[queue.Job]
+	if err := json.Unmarshal([]byte(`{
+		"recent": { "count": 3, "skip": false, "identifier": "recent:1", "lastTime": 9000, "url": "https://example.com/recent", "firstTime": 1000 },
+		"old": { "count": 1, "skip": false, "identifier": "old:1", "lastTime": 1000.5 },
+		"stale": { "count": 2, "skip": false, "identifier": "stale:1" },
+		"done": { "count": 5, "skip": true, "identifier": "done:1", "lastTime": 0 }
+	}`), &pastJobs); err != nil {
+		t.Fatal(err)
+	}
+
+	archQueue, err := queue.ArchQueue(builds, "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := archQueue.Keys(); len(got) != 4 || archQueue.Has("done") {
+		t.Fatalf("unexpected queue: %v", got)
+	}
+
+	now := time.Unix(10000, 0)
+
+	t.Run("default", func(t *testing.T) {
+		jobs, err := queue.JobsRecord(archQueue, pastJobs, now, queue.DefaultBackoff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertJSON(t, jobs, []byte(`{
+			"new": { "count": 1, "skip": false, "identifier": "new:1" },
+			"recent": { "count": 3, "skip": true, "identifier": "recent:1", "lastTime": 9000, "url": "https://example.com/recent", "firstTime": 1000 },
+			"old": { "count": 2, "skip": false, "identifier": "old:1", "lastTime": 1000.5 },
+			"stale": { "count": 2, "skip": true, "identifier": "stale:1" }
+		}`))
+
+		filtered, err := queue.FilterSkipsQueue(archQueue, jobs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertJSON(t, buildIDs(filtered), []byte(`["new","old"]`))
+	})
+
+	t.Run("custom backoff", func(t *testing.T) {
+		// a single flat delay of 10 minutes, no matter how many attempts
+		jobs, err := queue.JobsRecord(archQueue, pastJobs, now, queue.Backoff{10 * time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		filtered, err := queue.FilterSkipsQueue(archQueue, jobs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// without a "lastTime", jq waits from "now" (".lastTime // $now"), so a non-zero delay skips even brand new builds
+		// (and the rest are sorted by attempts, so the one that has been tried the most is last)
+		assertJSON(t, buildIDs(filtered), []byte(`["old","recent"]`))
+	})
+}
+
+func TestBackoffInterval(t *testing.T) {
+	for count, want := range map[int]time.Duration{
+		-1: 0,
+		0:  0,
+		1:  0,
+		2:  time.Hour,
+		5:  8 * time.Hour,
+		7:  32 * time.Hour,
+		99: 32 * time.Hour,
+	} {
+		if got := queue.DefaultBackoff.Interval(count); got != want {
+			t.Errorf("%d: expected %s, got %s", count, want, got)
+		}
+	}
+	if got := (queue.Backoff{}).Interval(5); got != 0 {
+		t.Errorf("empty: expected 0, got %s", got)
+	}
+}
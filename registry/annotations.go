@@ -3,10 +3,16 @@ package registry
 const (
 	AnnotationBashbrewArch = "com.docker.official-images.bashbrew.arch"
 
+	// set by [SynthesizeIndex] on the index itself (see [ExcludedManifest] and [SynthesizedIndexUpstream])
+	AnnotationExcludedManifests = "com.docker.official-images.meta.excluded-manifests" // JSON list of [ExcludedManifest] objects
+	AnnotationUnmodified        = "com.docker.official-images.meta.unmodified"         // "true" if the list of manifests is exactly the upstream index's list (so the upstream index can be copied as-is), "false" otherwise
+	AnnotationMultipleImages    = "com.docker.official-images.meta.multiple-images"    // (warning) JSON list of [MultipleImagesError] objects (see [MultipleImages])
+
 	// https://github.com/moby/buildkit/blob/c6145c2423de48f891862ac02f9b2653864d3c9e/docs/attestations/attestation-storage.md
 	annotationBuildkitReferenceType            = "vnd.docker.reference.type"
 	annotationBuildkitReferenceTypeAttestation = "attestation-manifest"
 	annotationBuildkitReferenceDigest          = "vnd.docker.reference.digest"
+	artifactTypeBuildkitAttestation            = "application/vnd.docker.attestation.manifest.v1+json" // https://github.com/moby/buildkit/pull/5573/files#r2069525281
 
 	// https://github.com/distribution/distribution/blob/v3.0.0/docs/content/spec/manifest-v2-2.md
 	mediaTypeDockerManifestList  = "application/vnd.docker.distribution.manifest.list.v2+json"
@@ -364,3 +364,8 @@ func (rc *registryCache) MountBlob(ctx context.Context, fromRepo, toRepo string,
 }
 
 // TODO more methods (currently only implements what's actually necessary for SynthesizeIndex and {Ensure,Copy}{Manifest,Blob})
+
+func (rc *registryCache) Referrers(ctx context.Context, repo string, digest ociregistry.Digest, artifactType string) ociregistry.Seq[ociregistry.Descriptor] {
+	// referrers are (by design) a mutable list, so we don't cache them (just pass them through to the upstream registry so our embedded "*ociregistry.Funcs" doesn't make them unsupported)
+	return rc.registry.Referrers(ctx, repo, digest, artifactType)
+}
@@ -17,6 +17,11 @@ import (
 // returns an [ociregistry.Interface] that automatically implements an in-memory cache (see [RegistryCache]) *and* transparent rate limiting + retry (see [registryRateLimiters]/[rateLimitedRetryingDoer]) / `DOCKERHUB_PUBLIC_PROXY` support for Docker Hub (cached such that multiple calls for the same registry transparently return the same client object / in-memory registry cache)
 func Client(host string, opts *ociclient.Options) (ociregistry.Interface, error) {
 	f, _ := clientCache.LoadOrStore(host, sync.OnceValues(func() (ociregistry.Interface, error) {
+		if host == OCILayoutHost {
+			// local directories don't need auth, rate limiting, or caching
+			return OCILayout(), nil
+		}
+
 		authConfig, err := authConfigFunc()
 		if err != nil {
 			return nil, err
@@ -0,0 +1,240 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	godigest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Docker media types for blobs (which only change in the descriptors that point to them when converting to OCI -- the blobs themselves are byte-for-byte compatible, so their digests stay the same)
+//
+// https://github.com/distribution/distribution/blob/v3.0.0/docs/content/spec/manifest-v2-2.md#media-types
+const (
+	mediaTypeDockerImageLayer             = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	mediaTypeDockerImageLayerUncompressed = "application/vnd.docker.image.rootfs.diff.tar"
+	mediaTypeDockerImageForeignLayer      = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+
+	// https://github.com/opencontainers/image-spec/blob/v1.1.0/layer.md#non-distributable-layers (deprecated, but the only faithful equivalent of a Docker "foreign" layer)
+	mediaTypeOCIImageLayerNonDistributableGzip = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+)
+
+// Docker media type => OCI media type
+var dockerToOCIMediaTypes = map[string]string{
+	mediaTypeDockerManifestList:           ocispec.MediaTypeImageIndex,
+	mediaTypeDockerImageManifest:          ocispec.MediaTypeImageManifest,
+	mediaTypeDockerImageConfig:            ocispec.MediaTypeImageConfig,
+	mediaTypeDockerImageLayer:             ocispec.MediaTypeImageLayerGzip,
+	mediaTypeDockerImageLayerUncompressed: ocispec.MediaTypeImageLayer,
+	mediaTypeDockerImageForeignLayer:      mediaTypeOCIImageLayerNonDistributableGzip,
+}
+
+// a single manifest (index or image) converted by [ConvertToOCI]
+type ConvertedManifest struct {
+	Original  ocispec.Descriptor `json:"original"`
+	Converted ocispec.Descriptor `json:"converted"` // identical to Original if nothing needed to change
+
+	Manifest json.RawMessage `json:"-"` // the new manifest contents (what Converted describes)
+}
+
+// the result of [ConvertToOCI]
+type Conversion struct {
+	// every manifest we had to look at, children before their parents (so the last entry is always the manifest we were asked to convert, and pushing them in order is always safe)
+	Manifests []ConvertedManifest `json:"manifests"`
+}
+
+// the converted version of the requested manifest (the last entry of [Conversion.Manifests])
+func (c Conversion) Root() ConvertedManifest {
+	return c.Manifests[len(c.Manifests)-1]
+}
+
+// the mapping table of original digests to converted digests (including any that didn't change)
+func (c Conversion) Mapping() map[ociregistry.Digest]ociregistry.Digest {
+	ret := map[ociregistry.Digest]ociregistry.Digest{}
+	for _, m := range c.Manifests {
+		ret[m.Original.Digest] = m.Converted.Digest
+	}
+	return ret
+}
+
+// rewrites the manifest at the given reference (and recursively, all of its child manifests) from Docker v2 media types to OCI media types, returning the new manifests and a mapping from old digests to new ones (objects that are already fully OCI are left byte-for-byte untouched, so their digests don't change)
+//
+// BuildKit attestation references ("vnd.docker.reference.digest") are rewritten to the new digests of their subjects, but OCI 1.1 "subject" fields (in manifests that aren't children of this one) can't be, so anything attached via the referrers API will still point to the old digests
+//
+// returns nil (and no error) if the reference does not exist; see [Conversion.Ensure] for pushing the result
+func ConvertToOCI(ctx context.Context, ref Reference) (*Conversion, error) {
+	r, err := Lookup(ctx, ref, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed GET: %w", ref, err)
+	}
+	if r == nil {
+		return nil, nil
+	}
+	defer r.Close()
+	desc := r.Descriptor()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed reading manifest: %w", ref, err)
+	}
+
+	client, err := Client(ref.Host, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed getting client: %w", ref, err)
+	}
+
+	c := &converter{
+		client:    client,
+		ref:       ref,
+		converted: map[ociregistry.Digest]ConvertedManifest{},
+	}
+	if _, err := c.convert(ctx, desc, b, 0); err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	return &c.conversion, nil
+}
+
+type converter struct {
+	client     ociregistry.Interface
+	ref        Reference
+	converted  map[ociregistry.Digest]ConvertedManifest // original digest => result (so a manifest that appears more than once only gets converted once)
+	conversion Conversion
+}
+
+func (c *converter) convert(ctx context.Context, desc ocispec.Descriptor, manifest []byte, depth int) (ConvertedManifest, error) {
+	if ret, ok := c.converted[desc.Digest]; ok {
+		return ret, nil
+	}
+
+	ret := ConvertedManifest{
+		Original:  desc,
+		Converted: desc,
+		Manifest:  manifest,
+	}
+
+	var (
+		newManifest any
+		changed     bool
+	)
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, mediaTypeDockerManifestList:
+		if depth > maxNestedIndexDepth {
+			return ret, fmt.Errorf("%s: nested index depth exceeds %d", desc.Digest, maxNestedIndexDepth)
+		}
+		var index ocispec.Index
+		if err := json.Unmarshal(manifest, &index); err != nil {
+			return ret, fmt.Errorf("%s: failed parsing index: %w", desc.Digest, err)
+		}
+		// first pass: convert all the children (so we know their new digests)
+		newDigests := map[ociregistry.Digest]ociregistry.Digest{}
+		for i, child := range index.Manifests {
+			r, err := c.client.GetManifest(ctx, c.ref.Repository, child.Digest)
+			if err != nil {
+				return ret, fmt.Errorf("%s: failed GET: %w", child.Digest, err)
+			}
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return ret, fmt.Errorf("%s: failed reading manifest: %w", child.Digest, err)
+			}
+			converted, err := c.convert(ctx, child, b, depth+1)
+			if err != nil {
+				return ret, err
+			}
+			if converted.Converted.Digest != child.Digest || converted.Converted.MediaType != child.MediaType {
+				child.MediaType = converted.Converted.MediaType
+				child.Digest = converted.Converted.Digest
+				child.Size = converted.Converted.Size
+				child.Data = nil // (if it was embedded, it's now the wrong data)
+				newDigests[converted.Original.Digest] = converted.Converted.Digest
+				index.Manifests[i] = child
+				changed = true
+			}
+		}
+		// second pass: rewrite any attestation references to point at the new digests of their subjects
+		for i, child := range index.Manifests {
+			if subject, ok := newDigests[ociregistry.Digest(child.Annotations[annotationBuildkitReferenceDigest])]; ok {
+				child.Annotations = maps.Clone(child.Annotations)
+				child.Annotations[annotationBuildkitReferenceDigest] = string(subject)
+				index.Manifests[i] = child
+			}
+		}
+		if desc.MediaType == mediaTypeDockerManifestList || index.MediaType == mediaTypeDockerManifestList {
+			changed = true
+		}
+		index.MediaType = ocispec.MediaTypeImageIndex
+		newManifest = index
+
+	case ocispec.MediaTypeImageManifest, mediaTypeDockerImageManifest:
+		var image ocispec.Manifest
+		if err := json.Unmarshal(manifest, &image); err != nil {
+			return ret, fmt.Errorf("%s: failed parsing manifest: %w", desc.Digest, err)
+		}
+		if desc.MediaType == mediaTypeDockerImageManifest || image.MediaType == mediaTypeDockerImageManifest {
+			changed = true
+		}
+		image.MediaType = ocispec.MediaTypeImageManifest
+		if mediaType, ok := dockerToOCIMediaTypes[image.Config.MediaType]; ok {
+			image.Config.MediaType = mediaType
+			changed = true
+		}
+		for i, layer := range image.Layers {
+			if mediaType, ok := dockerToOCIMediaTypes[layer.MediaType]; ok {
+				image.Layers[i].MediaType = mediaType
+				changed = true
+			}
+		}
+		newManifest = image
+
+	default:
+		return ret, fmt.Errorf("%s: unsupported mediaType: %q", desc.Digest, desc.MediaType)
+	}
+
+	if changed {
+		b, err := json.Marshal(newManifest)
+		if err != nil {
+			return ret, fmt.Errorf("%s: %w", desc.Digest, err)
+		}
+		ret.Manifest = b
+		ret.Converted = ocispec.Descriptor{
+			MediaType: dockerToOCIMediaTypes[desc.MediaType],
+			Digest:    godigest.FromBytes(b),
+			Size:      int64(len(b)),
+		}
+		if ret.Converted.MediaType == "" {
+			// (already an OCI media type, but with Docker children)
+			ret.Converted.MediaType = desc.MediaType
+		}
+	}
+
+	c.converted[desc.Digest] = ret
+	c.conversion.Manifests = append(c.conversion.Manifests, ret)
+	return ret, nil
+}
+
+// pushes the converted manifests to the given reference (children by digest, and then the root manifest by whatever "dstRef" specifies) via [EnsureManifest], copying any blobs from "srcRef" (the reference that was converted) as necessary
+func (c Conversion) Ensure(ctx context.Context, srcRef, dstRef Reference) (ociregistry.Descriptor, error) {
+	childRefs := map[ociregistry.Digest]Reference{
+		"": srcRef, // blobs (and any manifests that didn't need to change) can be found in the source
+	}
+	for i, m := range c.Manifests {
+		ref := dstRef
+		if i < len(c.Manifests)-1 {
+			ref.Tag = ""
+			ref.Digest = m.Converted.Digest
+		}
+		desc, err := EnsureManifest(ctx, ref, m.Manifest, m.Converted.MediaType, maps.Clone(childRefs))
+		if err != nil {
+			return desc, err
+		}
+		if m.Converted.Digest != m.Original.Digest {
+			// now that the converted manifest exists in the destination, that's where parents should find it
+			childRefs[m.Converted.Digest] = dstRef
+		}
+	}
+	return c.Root().Converted, nil
+}
@@ -0,0 +1,166 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	"cuelabs.dev/go/oci/ociregistry/ocimem"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ocimem is a little stricter/different than a real registry in a few ways that matter to [EnsureManifest]: it returns a bare error for a manifest push with missing children (where a real registry returns an appropriate error code, which is how we know to copy the children) and it refuses blob pushes without a media type (which [EnsureBlob] doesn't know)
+type testRealisticRegistry struct {
+	ociregistry.Interface
+}
+
+func (r testRealisticRegistry) PushBlob(ctx context.Context, repo string, desc ociregistry.Descriptor, rd io.Reader) (ociregistry.Descriptor, error) {
+	if desc.MediaType == "" {
+		desc.MediaType = "application/octet-stream"
+	}
+	return r.Interface.PushBlob(ctx, repo, desc, rd)
+}
+
+func (r testRealisticRegistry) PushManifest(ctx context.Context, repo string, tag string, contents []byte, mediaType string) (ociregistry.Descriptor, error) {
+	desc, err := r.Interface.PushManifest(ctx, repo, tag, contents, mediaType)
+	if err != nil && strings.HasSuffix(err.Error(), " not found") {
+		err = fmt.Errorf("%w: %v", ociregistry.ErrManifestBlobUnknown, err)
+	}
+	return desc, err
+}
+
+func TestConvertToOCI(t *testing.T) {
+	ctx := context.Background()
+
+	src := testRegistry(t, "convert-src.invalid")
+	dst := ocimem.New()
+	testClient(t, "convert-dst.invalid", testRealisticRegistry{dst})
+
+	pushManifest := func(tag string, mediaType string, manifest any) ocispec.Descriptor {
+		t.Helper()
+		b, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := src.PushManifest(ctx, "test", tag, b, mediaType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+
+	config := testPushBlob(t, src, "test", mediaTypeDockerImageConfig, []byte(`{"architecture":"amd64","os":"linux"}`))
+	layer := testPushBlob(t, src, "test", mediaTypeDockerImageLayer, []byte("not really gzip"))
+	uncompressed := testPushBlob(t, src, "test", mediaTypeDockerImageLayerUncompressed, []byte("not really tar"))
+	image := pushManifest("", mediaTypeDockerImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: mediaTypeDockerImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer, uncompressed},
+	})
+	// attestations are already OCI (so they should stay exactly the same, other than the reference to their subject)
+	attestation, _, _ := testImage(t, src, "test", "", "attestation layer")
+	attestation.Platform = &ocispec.Platform{OS: "unknown", Architecture: "unknown"}
+	attestation.Annotations = map[string]string{
+		annotationBuildkitReferenceType:   annotationBuildkitReferenceTypeAttestation,
+		annotationBuildkitReferenceDigest: string(image.Digest),
+	}
+	image.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	list := pushManifest("list", mediaTypeDockerManifestList, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: mediaTypeDockerManifestList,
+		Manifests: []ocispec.Descriptor{image, attestation},
+	})
+
+	ref, err := ParseRef("convert-src.invalid/test:list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversion, err := ConvertToOCI(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapping := conversion.Mapping()
+	if len(conversion.Manifests) != 3 || len(mapping) != 3 {
+		t.Fatalf("expected list + image + attestation, got %+v", conversion.Manifests)
+	}
+	if mapping[image.Digest] == image.Digest || mapping[list.Digest] == list.Digest {
+		t.Fatalf("expected new digests for Docker objects: %v", mapping)
+	}
+	if mapping[attestation.Digest] != attestation.Digest {
+		t.Fatalf("expected unchanged digest for OCI attestation: %v", mapping)
+	}
+
+	root := conversion.Root()
+	if root.Original.Digest != list.Digest || root.Converted.MediaType != ocispec.MediaTypeImageIndex {
+		t.Fatalf("unexpected root: %+v", root)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(root.Manifest, &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.MediaType != ocispec.MediaTypeImageIndex || len(index.Manifests) != 2 {
+		t.Fatalf("unexpected index: %+v", index)
+	}
+	if m := index.Manifests[0]; m.MediaType != ocispec.MediaTypeImageManifest || m.Digest != mapping[image.Digest] || m.Platform == nil || m.Platform.Architecture != "amd64" {
+		t.Fatalf("unexpected image descriptor: %+v", m)
+	}
+	if m := index.Manifests[1]; m.Digest != attestation.Digest || m.Annotations[annotationBuildkitReferenceDigest] != string(mapping[image.Digest]) {
+		t.Fatalf("unexpected attestation descriptor: %+v", m)
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(conversion.Manifests[0].Manifest, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.MediaType != ocispec.MediaTypeImageManifest || manifest.Config.MediaType != ocispec.MediaTypeImageConfig || manifest.Config.Digest != config.Digest || manifest.Layers[0].MediaType != ocispec.MediaTypeImageLayerGzip || manifest.Layers[0].Digest != layer.Digest || manifest.Layers[1].MediaType != ocispec.MediaTypeImageLayer || manifest.Layers[1].Digest != uncompressed.Digest {
+		t.Fatalf("unexpected image manifest: %+v", manifest)
+	}
+
+	t.Run("Ensure", func(t *testing.T) {
+		dstRef, err := ParseRef("convert-dst.invalid/test:oci")
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := conversion.Ensure(ctx, ref, dstRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if desc.Digest != root.Converted.Digest {
+			t.Fatalf("expected %s, got %s", root.Converted.Digest, desc.Digest)
+		}
+		if d, err := dst.ResolveTag(ctx, "test", "oci"); err != nil || d.Digest != root.Converted.Digest || d.MediaType != ocispec.MediaTypeImageIndex {
+			t.Fatalf("unexpected tag: %v (%v)", d, err)
+		}
+		for _, digest := range []ociregistry.Digest{mapping[image.Digest], attestation.Digest} {
+			if _, err := dst.ResolveManifest(ctx, "test", digest); err != nil {
+				t.Fatalf("missing child manifest %s: %v", digest, err)
+			}
+		}
+		for _, digest := range []ociregistry.Digest{config.Digest, layer.Digest} {
+			if _, err := dst.ResolveBlob(ctx, "test", digest); err != nil {
+				t.Fatalf("missing blob %s: %v", digest, err)
+			}
+		}
+	})
+
+	t.Run("already OCI", func(t *testing.T) {
+		ref, err := ParseRef("convert-src.invalid/test@" + string(attestation.Digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conversion, err := ConvertToOCI(ctx, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root := conversion.Root(); root.Converted.Digest != attestation.Digest {
+			t.Fatalf("expected no change: %+v", root)
+		}
+	})
+}
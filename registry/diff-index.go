@@ -0,0 +1,219 @@
+package registry
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/docker-library/bashbrew/architecture"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// the result of [DiffIndex]
+type IndexDiff struct {
+	Added   []IndexDiffEntry `json:"added,omitempty"`
+	Removed []IndexDiffEntry `json:"removed,omitempty"`
+	Changed []IndexDiffEntry `json:"changed,omitempty"`
+
+	// changes to the annotations of the index itself
+	Annotations *AnnotationsDiff `json:"annotations,omitempty"`
+}
+
+// a single platform/architecture that differs between two indexes (see [IndexDiff])
+type IndexDiffEntry struct {
+	// the bashbrew architecture ([AnnotationBashbrewArch]) or platform of the image, plus the Windows build number (if any)
+	Key string `json:"key"`
+
+	From *ocispec.Descriptor `json:"from,omitempty"` // nil for added entries
+	To   *ocispec.Descriptor `json:"to,omitempty"`   // nil for removed entries
+
+	Attestations *DigestsDiff     `json:"attestations,omitempty"`
+	Annotations  *AnnotationsDiff `json:"annotations,omitempty"`
+}
+
+type DigestsDiff struct {
+	Added   []ociregistry.Digest `json:"added,omitempty"`
+	Removed []ociregistry.Digest `json:"removed,omitempty"`
+}
+
+type AnnotationsDiff struct {
+	Added   map[string]string           `json:"added,omitempty"`
+	Removed map[string]string           `json:"removed,omitempty"`
+	Changed map[string]AnnotationChange `json:"changed,omitempty"`
+}
+
+type AnnotationChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// whether there are no differences at all
+func (d IndexDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && d.Annotations == nil
+}
+
+// compares two indexes (as returned by [SynthesizeIndex], either of which may be nil for "doesn't exist") image by image, matching images up by their bashbrew architecture (or platform, if they don't have one) so that a changed digest for the same architecture is a "change" instead of a removal plus an addition
+//
+// attestations are compared as part of the image they're attached to, and [ocispec.AnnotationRefName] is ignored everywhere (it always differs between two different references, and just records where the object came from)
+func DiffIndex(a, b *ocispec.Index) IndexDiff {
+	var (
+		ret      IndexDiff
+		aEntries = diffIndexEntries(a)
+		bEntries = diffIndexEntries(b)
+		keys     = map[string]bool{}
+	)
+	for key := range aEntries {
+		keys[key] = true
+	}
+	for key := range bEntries {
+		keys[key] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	slices.Sort(sortedKeys)
+
+	for _, key := range sortedKeys {
+		aEntry, aOk := aEntries[key]
+		bEntry, bOk := bEntries[key]
+		entry := IndexDiffEntry{Key: key}
+		if aOk {
+			entry.From = &aEntry.image
+		}
+		if bOk {
+			entry.To = &bEntry.image
+		}
+		entry.Attestations = diffDigests(aEntry.attestations, bEntry.attestations)
+		switch {
+		case !aOk:
+			ret.Added = append(ret.Added, entry)
+		case !bOk:
+			ret.Removed = append(ret.Removed, entry)
+		default:
+			entry.Annotations = diffAnnotations(aEntry.image.Annotations, bEntry.image.Annotations)
+			if aEntry.image.Digest != bEntry.image.Digest || entry.Attestations != nil || entry.Annotations != nil {
+				ret.Changed = append(ret.Changed, entry)
+			}
+		}
+	}
+
+	var aAnnotations, bAnnotations map[string]string
+	if a != nil {
+		aAnnotations = a.Annotations
+	}
+	if b != nil {
+		bAnnotations = b.Annotations
+	}
+	ret.Annotations = diffAnnotations(aAnnotations, bAnnotations)
+
+	return ret
+}
+
+type diffIndexEntry struct {
+	image        ocispec.Descriptor
+	attestations []ociregistry.Digest
+}
+
+func diffIndexEntries(index *ocispec.Index) map[string]diffIndexEntry {
+	ret := map[string]diffIndexEntry{}
+	if index == nil {
+		return ret
+	}
+	keys := map[ociregistry.Digest]string{} // image digest => key (for attestations)
+	for _, m := range index.Manifests {
+		if m.Annotations[annotationBuildkitReferenceType] == annotationBuildkitReferenceTypeAttestation {
+			continue
+		}
+		key := diffIndexKey(m)
+		if _, ok := ret[key]; ok {
+			// "first match SHOULD win" (see also [MultipleImages])
+			continue
+		}
+		ret[key] = diffIndexEntry{image: m}
+		keys[m.Digest] = key
+	}
+	for _, m := range index.Manifests {
+		if m.Annotations[annotationBuildkitReferenceType] != annotationBuildkitReferenceTypeAttestation {
+			continue
+		}
+		key, ok := keys[ociregistry.Digest(m.Annotations[annotationBuildkitReferenceDigest])]
+		if !ok {
+			continue
+		}
+		entry := ret[key]
+		entry.attestations = append(entry.attestations, m.Digest)
+		ret[key] = entry
+	}
+	return ret
+}
+
+func diffIndexKey(m ocispec.Descriptor) string {
+	key := m.Annotations[AnnotationBashbrewArch]
+	if key == "" {
+		if m.Platform == nil {
+			// nothing better to go on
+			return string(m.Digest)
+		}
+		key = architecture.OCIPlatform(*m.Platform).String()
+	}
+	if m.Platform != nil && m.Platform.OSVersion != "" {
+		// only the build number, so that (for example) a new monthly Windows patch shows up as a "change" of the same image instead of a removal and an addition
+		key += " (os.version " + windowsBuild(m.Platform.OSVersion) + ")"
+	}
+	return key
+}
+
+func diffDigests(a, b []ociregistry.Digest) *DigestsDiff {
+	var ret DigestsDiff
+	for _, d := range b {
+		if !slices.Contains(a, d) {
+			ret.Added = append(ret.Added, d)
+		}
+	}
+	for _, d := range a {
+		if !slices.Contains(b, d) {
+			ret.Removed = append(ret.Removed, d)
+		}
+	}
+	if len(ret.Added) == 0 && len(ret.Removed) == 0 {
+		return nil
+	}
+	return &ret
+}
+
+func diffAnnotations(a, b map[string]string) *AnnotationsDiff {
+	a, b = maps.Clone(a), maps.Clone(b)
+	delete(a, ocispec.AnnotationRefName)
+	delete(b, ocispec.AnnotationRefName)
+
+	var ret AnnotationsDiff
+	for k, bv := range b {
+		av, ok := a[k]
+		switch {
+		case !ok:
+			if ret.Added == nil {
+				ret.Added = map[string]string{}
+			}
+			ret.Added[k] = bv
+		case av != bv:
+			if ret.Changed == nil {
+				ret.Changed = map[string]AnnotationChange{}
+			}
+			ret.Changed[k] = AnnotationChange{From: av, To: bv}
+		}
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			if ret.Removed == nil {
+				ret.Removed = map[string]string{}
+			}
+			ret.Removed[k] = av
+		}
+	}
+	if ret.Added == nil && ret.Removed == nil && ret.Changed == nil {
+		return nil
+	}
+	return &ret
+}
@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"slices"
+	"testing"
+
+	godigest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestDiffIndex(t *testing.T) {
+	image := func(digest, arch string, platform ocispec.Platform, annotations map[string]string) ocispec.Descriptor {
+		desc := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    godigest.FromString(digest),
+			Platform:  &platform,
+			Annotations: map[string]string{
+				AnnotationBashbrewArch:    arch,
+				ocispec.AnnotationRefName: "example.com/" + digest, // should be ignored
+			},
+		}
+		for k, v := range annotations {
+			desc.Annotations[k] = v
+		}
+		return desc
+	}
+	attestation := func(digest string, subject ocispec.Descriptor) ocispec.Descriptor {
+		return ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    godigest.FromString(digest),
+			Platform:  &ocispec.Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{
+				AnnotationBashbrewArch:            subject.Annotations[AnnotationBashbrewArch],
+				annotationBuildkitReferenceType:   annotationBuildkitReferenceTypeAttestation,
+				annotationBuildkitReferenceDigest: string(subject.Digest),
+			},
+		}
+	}
+
+	var (
+		amd64      = image("amd64", "amd64", ocispec.Platform{OS: "linux", Architecture: "amd64"}, nil)
+		amd64New   = image("amd64 new", "amd64", ocispec.Platform{OS: "linux", Architecture: "amd64"}, nil)
+		arm64      = image("arm64", "arm64v8", ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, nil)
+		arm64Label = image("arm64", "arm64v8", ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, map[string]string{"foo": "bar"})
+		s390x      = image("s390x", "s390x", ocispec.Platform{OS: "linux", Architecture: "s390x"}, nil)
+		ppc64le    = image("ppc64le", "ppc64le", ocispec.Platform{OS: "linux", Architecture: "ppc64le"}, nil)
+		windows    = image("windows", "windows-amd64", ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.100"}, nil)
+		windowsNew = image("windows new", "windows-amd64", ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.200"}, nil)
+	)
+
+	a := &ocispec.Index{
+		Annotations: map[string]string{ocispec.AnnotationRefName: "example.com/a", "removed": "x", "changed": "1"},
+		Manifests: []ocispec.Descriptor{
+			amd64, attestation("amd64 attestation", amd64),
+			arm64, attestation("arm64 attestation", arm64),
+			s390x,
+			windows,
+		},
+	}
+	b := &ocispec.Index{
+		Annotations: map[string]string{ocispec.AnnotationRefName: "example.com/b", "added": "y", "changed": "2"},
+		Manifests: []ocispec.Descriptor{
+			amd64New, attestation("amd64 new attestation", amd64New),
+			arm64Label, attestation("arm64 new attestation", arm64Label),
+			ppc64le,
+			windowsNew,
+		},
+	}
+
+	diff := DiffIndex(a, b)
+	if diff.Empty() {
+		t.Fatal("expected differences")
+	}
+
+	keys := func(entries []IndexDiffEntry) []string {
+		var ret []string
+		for _, e := range entries {
+			ret = append(ret, e.Key)
+		}
+		return ret
+	}
+	if got, want := keys(diff.Added), []string{"ppc64le"}; !slices.Equal(got, want) {
+		t.Errorf("added: expected %v, got %v", want, got)
+	}
+	if got, want := keys(diff.Removed), []string{"s390x"}; !slices.Equal(got, want) {
+		t.Errorf("removed: expected %v, got %v", want, got)
+	}
+	if got, want := keys(diff.Changed), []string{"amd64", "arm64v8", "windows-amd64 (os.version 10.0.20348)"}; !slices.Equal(got, want) {
+		t.Fatalf("changed: expected %v, got %v", want, got)
+	}
+
+	if c := diff.Changed[0]; c.From.Digest != amd64.Digest || c.To.Digest != amd64New.Digest || c.Attestations == nil || len(c.Attestations.Added) != 1 || len(c.Attestations.Removed) != 1 || c.Annotations != nil {
+		t.Errorf("unexpected amd64 change: %+v", c)
+	}
+	if c := diff.Changed[1]; c.From.Digest != c.To.Digest || c.Annotations == nil || c.Annotations.Added["foo"] != "bar" || c.Attestations == nil {
+		t.Errorf("unexpected arm64v8 change: %+v", c)
+	}
+
+	if d := diff.Annotations; d == nil || d.Added["added"] != "y" || d.Removed["removed"] != "x" || d.Changed["changed"] != (AnnotationChange{From: "1", To: "2"}) || len(d.Added)+len(d.Removed)+len(d.Changed) != 3 {
+		t.Errorf("unexpected index annotations diff: %+v", diff.Annotations)
+	}
+
+	if diff := DiffIndex(a, a); !diff.Empty() {
+		t.Errorf("expected no differences between an index and itself: %+v", diff)
+	}
+
+	if diff := DiffIndex(nil, b); len(diff.Added) != 4 || len(diff.Removed) != 0 || len(diff.Changed) != 0 {
+		t.Errorf("expected everything to be added: %+v", diff)
+	}
+}
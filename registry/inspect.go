@@ -0,0 +1,155 @@
+package registry
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// the result of [InspectImage]
+type ImageInspection struct {
+	Ref        Reference          `json:"ref"`
+	Descriptor ocispec.Descriptor `json:"descriptor"`
+	Manifest   ocispec.Manifest   `json:"manifest"`
+	Config     ocispec.Image      `json:"config"` // includes history, env, labels, rootfs diff_ids, etc
+
+	// the total size of all layers, as stored in the registry (the sum of the "size" fields of the layer descriptors)
+	CompressedSize int64 `json:"compressedSize"`
+	// the total size of all layers after decompression (nil if any layer uses a compression we can't undo, like zstd)
+	UncompressedSize *int64 `json:"uncompressedSize"`
+
+	Created *time.Time `json:"created,omitempty"` // (copied from the config for convenience)
+}
+
+// fetches and parses the image manifest and config for the given reference (plus downloads every gzip layer to calculate its uncompressed size, so this is not cheap for large images!)
+//
+// if the reference is an index, it must contain exactly one (non-attestation) image (like our staging images do), otherwise use [ResolvePlatform] first to pick one
+//
+// returns nil (and no error) if the reference does not exist
+func InspectImage(ctx context.Context, ref Reference) (*ImageInspection, error) {
+	client, err := Client(ref.Host, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed getting client: %w", ref, err)
+	}
+
+	r, err := Lookup(ctx, ref, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed GET: %w", ref, err)
+	}
+	if r == nil {
+		return nil, nil
+	}
+	desc := r.Descriptor()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest, mediaTypeDockerImageManifest:
+		// all good, continue below!
+
+	case ocispec.MediaTypeImageIndex, mediaTypeDockerManifestList:
+		r.Close()
+		index, err := SynthesizeIndex(ctx, ref, nil)
+		if err != nil {
+			return nil, err
+		}
+		if index == nil {
+			// deleted between our lookups
+			return nil, nil
+		}
+		var images []ocispec.Descriptor
+		for _, m := range index.Manifests {
+			if m.Annotations[annotationBuildkitReferenceType] != annotationBuildkitReferenceTypeAttestation {
+				images = append(images, m)
+			}
+		}
+		if len(images) != 1 {
+			return nil, fmt.Errorf("%s: index contains %d images (expected exactly one; try a digest or a platform instead)", ref, len(images))
+		}
+		ref.Digest = images[0].Digest
+		r, err = client.GetManifest(ctx, ref.Repository, ref.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed GET: %w", ref, err)
+		}
+		desc = r.Descriptor()
+
+	default:
+		r.Close()
+		return nil, fmt.Errorf("%s: unsupported mediaType: %q", ref, desc.MediaType)
+	}
+
+	defer r.Close()
+
+	ret := ImageInspection{
+		Ref:        ref,
+		Descriptor: desc,
+	}
+	ret.Ref.Digest = desc.Digest
+
+	if err := readJSONHelper(r, &ret.Manifest); err != nil {
+		return nil, fmt.Errorf("%s: failed reading manifest: %w", ref, err)
+	}
+
+	config, err := readImageConfig(ctx, client, ref.Repository, ret.Manifest.Config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed reading config: %w", ref, err)
+	}
+	if config == nil {
+		return nil, fmt.Errorf("%s: unsupported config mediaType: %q", ref, ret.Manifest.Config.MediaType)
+	}
+	ret.Config = *config
+	ret.Created = config.Created
+
+	var uncompressed int64
+	for _, layer := range ret.Manifest.Layers {
+		ret.CompressedSize += layer.Size
+		if uncompressed < 0 {
+			continue
+		}
+		size, err := uncompressedLayerSize(ctx, client, ref.Repository, layer)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed reading layer %s: %w", ref, layer.Digest, err)
+		}
+		if size < 0 {
+			uncompressed = -1
+			continue
+		}
+		uncompressed += size
+	}
+	if uncompressed >= 0 {
+		ret.UncompressedSize = &uncompressed
+	}
+
+	return &ret, nil
+}
+
+// downloads the given layer blob and returns how many bytes it decompresses to (or -1 if we don't know how to decompress it); only gzip layers actually need to be downloaded
+func uncompressedLayerSize(ctx context.Context, client ociregistry.Interface, repo string, layer ocispec.Descriptor) (int64, error) {
+	switch {
+	case strings.HasSuffix(layer.MediaType, "+gzip"), strings.HasSuffix(layer.MediaType, ".gzip"):
+		// continue below!
+	case strings.Contains(layer.MediaType, "+"), strings.HasSuffix(layer.MediaType, ".zstd"):
+		// some compression other than gzip (zstd, most likely)
+		return -1, nil
+	default:
+		// uncompressed layers are already their own uncompressed size
+		return layer.Size, nil
+	}
+
+	r, err := client.GetBlob(ctx, repo, layer.Digest)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	return io.Copy(io.Discard, gz)
+}
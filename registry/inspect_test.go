@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	"cuelabs.dev/go/oci/ociregistry/ocimem"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestInspectImage(t *testing.T) {
+	ctx := context.Background()
+
+	reg := testRegistry(t, "inspect.invalid")
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	if _, err := gz.Write(bytes.Repeat([]byte("hello world\n"), 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	config := testPushBlob(t, reg, "test", ocispec.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"linux","created":"2024-01-02T03:04:05Z","config":{"Env":["PATH=/usr/bin"],"Labels":{"foo":"bar"}},"rootfs":{"type":"layers","diff_ids":[]},"history":[{"created_by":"hello"},{"created_by":"world"}]}`))
+	layers := []ocispec.Descriptor{
+		testPushBlob(t, reg, "test", ocispec.MediaTypeImageLayerGzip, gzipped.Bytes()),
+		testPushBlob(t, reg, "test", ocispec.MediaTypeImageLayer, []byte("uncompressed layer")),
+	}
+	image := testPushManifest(t, reg, "test", "image", ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    layers,
+	})
+	zstd := testPushManifest(t, reg, "test", "zstd", ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{testPushBlob(t, reg, "test", ocispec.MediaTypeImageLayerZstd, []byte("not really zstd"))},
+	})
+	other, _, _ := testImage(t, reg, "test", "", "other layer")
+
+	pushIndex := func(tag string, manifests ...ocispec.Descriptor) {
+		t.Helper()
+		b, err := json.Marshal(ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: manifests,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reg.PushManifest(ctx, "test", tag, b, ocispec.MediaTypeImageIndex); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pushIndex("index", image)
+	pushIndex("multiple", image, other)
+
+	inspect := func(ref string) (*ImageInspection, error) {
+		t.Helper()
+		parsed, err := ParseRef(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return InspectImage(ctx, parsed)
+	}
+
+	for _, ref := range []string{"inspect.invalid/test:image", "inspect.invalid/test:index"} {
+		t.Run(ref, func(t *testing.T) {
+			inspection, err := inspect(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inspection.Descriptor.Digest != image.Digest || inspection.Ref.Digest != image.Digest {
+				t.Fatalf("unexpected image: %v (%s)", inspection.Descriptor, inspection.Ref)
+			}
+			if want := layers[0].Size + layers[1].Size; inspection.CompressedSize != want {
+				t.Errorf("expected compressed size %d, got %d", want, inspection.CompressedSize)
+			}
+			if want := int64(12*1000 + len("uncompressed layer")); inspection.UncompressedSize == nil || *inspection.UncompressedSize != want {
+				t.Errorf("expected uncompressed size %d, got %v", want, inspection.UncompressedSize)
+			}
+			if inspection.Created == nil || inspection.Created.Year() != 2024 {
+				t.Errorf("unexpected created: %v", inspection.Created)
+			}
+			if len(inspection.Config.History) != 2 || inspection.Config.Config.Labels["foo"] != "bar" || len(inspection.Config.Config.Env) != 1 {
+				t.Errorf("unexpected config: %+v", inspection.Config)
+			}
+		})
+	}
+
+	t.Run("zstd", func(t *testing.T) {
+		inspection, err := inspect("inspect.invalid/test:zstd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inspection.Descriptor.Digest != zstd.Digest || inspection.UncompressedSize != nil {
+			t.Fatalf("expected unknown uncompressed size: %v", inspection.UncompressedSize)
+		}
+	})
+
+	t.Run("multiple", func(t *testing.T) {
+		if _, err := inspect("inspect.invalid/test:multiple"); err == nil {
+			t.Fatal("expected error for index with multiple images")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		inspection, err := inspect("inspect.invalid/test:missing")
+		if err != nil || inspection != nil {
+			t.Fatalf("expected nil, got %v (%v)", inspection, err)
+		}
+	})
+	t.Run("deleted between lookups", func(t *testing.T) {
+		vanishing := &testVanishingTag{Interface: ocimem.New()}
+		testClient(t, "inspect-vanishing.invalid", vanishing)
+		image, _, _ := testImage(t, vanishing.Interface, "test", "", "vanishing layer")
+		b, err := json.Marshal(ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: []ocispec.Descriptor{image},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := vanishing.PushManifest(ctx, "test", "index", b, ocispec.MediaTypeImageIndex); err != nil {
+			t.Fatal(err)
+		}
+
+		inspection, err := inspect("inspect-vanishing.invalid/test:index")
+		if err != nil || inspection != nil {
+			t.Fatalf("expected nil, got %v (%v)", inspection, err)
+		}
+	})
+}
+
+// a registry where every tag disappears after the first time it is fetched (as if it were deleted right after)
+type testVanishingTag struct {
+	ociregistry.Interface
+	gets atomic.Int32
+}
+
+func (r *testVanishingTag) GetTag(ctx context.Context, repo string, tag string) (ociregistry.BlobReader, error) {
+	if r.gets.Add(1) > 1 {
+		return nil, ociregistry.ErrManifestUnknown
+	}
+	return r.Interface.GetTag(ctx, repo, tag)
+}
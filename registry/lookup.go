@@ -78,16 +78,7 @@ func Lookup(ctx context.Context, ref Reference, opts *LookupOptions) (ociregistr
 
 	// normalize 404 and 404-like to nil return (so it's easier to detect)
 	if err != nil {
-		if errors.Is(err, ociregistry.ErrBlobUnknown) ||
-			errors.Is(err, ociregistry.ErrManifestUnknown) ||
-			errors.Is(err, ociregistry.ErrNameUnknown) {
-			// obvious 404 cases
-			return nil, nil
-		}
-		var httpErr ociregistry.HTTPError
-		if errors.As(err, &httpErr) && (httpErr.StatusCode() == 404 ||
-			// 401 often means "repository not found" (due to the nature of public/private mixing on Hub and the fact that ociauth definitely handled any possible authentication for us, so if we're still getting 401 it's unavoidable and might as well be 404, and 403 because getting 401 is actually a server bug that ociclient/ociauth works around for us in https://github.com/cue-labs/oci/commit/7eb5fc60a0e025038cd64d7f5df0a461136d5e9b)
-			httpErr.StatusCode() == 401 || httpErr.StatusCode() == 403) {
+		if isNotFound(err) {
 			return nil, nil
 		}
 		return r, err
@@ -99,3 +90,17 @@ func Lookup(ctx context.Context, ref Reference, opts *LookupOptions) (ociregistr
 
 	return r, err
 }
+
+// whether the given error is a 404 (or 404-like) error
+func isNotFound(err error) bool {
+	if errors.Is(err, ociregistry.ErrBlobUnknown) ||
+		errors.Is(err, ociregistry.ErrManifestUnknown) ||
+		errors.Is(err, ociregistry.ErrNameUnknown) {
+		// obvious 404 cases
+		return true
+	}
+	var httpErr ociregistry.HTTPError
+	return errors.As(err, &httpErr) && (httpErr.StatusCode() == 404 ||
+		// 401 often means "repository not found" (due to the nature of public/private mixing on Hub and the fact that ociauth definitely handled any possible authentication for us, so if we're still getting 401 it's unavoidable and might as well be 404, and 403 because getting 401 is actually a server bug that ociclient/ociauth works around for us in https://github.com/cue-labs/oci/commit/7eb5fc60a0e025038cd64d7f5df0a461136d5e9b)
+		httpErr.StatusCode() == 401 || httpErr.StatusCode() == 403)
+}
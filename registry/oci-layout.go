@@ -0,0 +1,487 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	"cuelabs.dev/go/oci/ociregistry/ocimem"
+	"cuelabs.dev/go/oci/ociregistry/ociref"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// the (fake) [Reference.Host] value for OCI image layout directories on disk (see [ParseRef] and [OCILayout]); for these, [Reference.Repository] is the path to the layout directory
+const OCILayoutHost = "oci"
+
+// the [ParseRef] prefix for OCI image layout references: `oci:/path/to/layout:tag`, `oci:./layout@sha256:xxx`, etc (the path has to start with "/" or "." so that we don't confuse it with a registry named "oci" and a port number)
+const ociLayoutRefPrefix = OCILayoutHost + ":"
+
+// parse the part of an `oci:/path/to/layout:tag@digest` reference that comes after "oci:"
+func parseOCILayoutRef(path string) (Reference, error) {
+	ref := Reference{Host: OCILayoutHost}
+	path, digest, ok := strings.Cut(path, "@")
+	if ok {
+		ref.Digest = ociregistry.Digest(digest)
+		if err := ref.Digest.Validate(); err != nil {
+			return Reference{}, fmt.Errorf("invalid digest %q: %w", digest, err)
+		}
+	}
+	ref.Repository = path
+	// a tag is anything after the last ":" that comes after the last "/"
+	if i := strings.LastIndexByte(ref.Repository, ':'); i > strings.LastIndexByte(ref.Repository, '/') {
+		ref.Tag = ref.Repository[i+1:]
+		ref.Repository = ref.Repository[:i]
+		if !ociref.IsValidTag(ref.Tag) {
+			return Reference{}, fmt.Errorf("invalid tag %q", ref.Tag)
+		}
+	}
+	if ref.Repository == "" {
+		return Reference{}, fmt.Errorf("missing OCI layout path")
+	}
+	ref.Repository = filepath.Clean(ref.Repository)
+	if !filepath.IsAbs(ref.Repository) && !strings.HasPrefix(ref.Repository, ".") {
+		// filepath.Clean strips a leading "./", but our String() needs to round-trip back through ParseRef
+		ref.Repository = "." + string(filepath.Separator) + ref.Repository
+	}
+	return ref, nil
+}
+
+// returns an [ociregistry.Interface] that treats every "repository" as a path to an OCI image layout directory on disk (https://github.com/opencontainers/image-spec/blob/v1.1.0/image-layout.md): blobs and manifests live in `blobs/<alg>/<hex>` and tags are the `org.opencontainers.image.ref.name` annotations of the descriptors in `index.json`
+//
+// pushing to a directory that does not exist yet (or is empty) will create a new layout there
+//
+// see also [OCILayoutHost] and [Client]
+func OCILayout() ociregistry.Interface {
+	return &ociLayout{}
+}
+
+type ociLayout struct {
+	*ociregistry.Funcs
+
+	// protects "index.json" updates (read-modify-write); there's no protection against *other* processes writing to the same layout concurrently
+	mu sync.Mutex
+}
+
+func ociLayoutBlobPath(dir string, digest ociregistry.Digest) (string, error) {
+	if err := digest.Validate(); err != nil {
+		return "", fmt.Errorf("%w: %w", ociregistry.ErrDigestInvalid, err)
+	}
+	return filepath.Join(dir, ocispec.ImageBlobsDir, digest.Algorithm().String(), digest.Encoded()), nil
+}
+
+// verifies that "dir" is actually an OCI layout (returning [ociregistry.ErrNameUnknown] if it isn't, like a registry would for a repository that doesn't exist)
+func ociLayoutCheck(dir string) error {
+	b, err := os.ReadFile(filepath.Join(dir, ocispec.ImageLayoutFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%s: %w", dir, ociregistry.ErrNameUnknown)
+		}
+		return err
+	}
+	var layout ocispec.ImageLayout
+	if err := json.Unmarshal(b, &layout); err != nil {
+		return fmt.Errorf("%s: failed to parse %s: %w", dir, ocispec.ImageLayoutFile, err)
+	}
+	if layout.Version != ocispec.ImageLayoutVersion {
+		return fmt.Errorf("%s: unsupported %s version: %q", dir, ocispec.ImageLayoutFile, layout.Version)
+	}
+	return nil
+}
+
+func ociLayoutReadIndex(dir string) (ocispec.Index, error) {
+	var index ocispec.Index
+	if err := ociLayoutCheck(dir); err != nil {
+		return index, err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		return index, fmt.Errorf("%s: failed to parse index.json: %w", dir, err)
+	}
+	return index, nil
+}
+
+// write a file "atomically" (via a temporary file in the same directory + rename); if "verify" is non-nil, it gets called after all of "r" has been written but *before* the rename, so anything it rejects never shows up at "path" at all
+func ociLayoutWriteFile(path string, r io.Reader, verify func() error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // (no-op after a successful rename)
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if verify != nil {
+		if err := verify(); err != nil {
+			return err
+		}
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// create the skeleton of a new layout, if necessary (see [ociLayout.init])
+func ociLayoutInit(dir string) error {
+	if err := ociLayoutCheck(dir); !errors.Is(err, ociregistry.ErrNameUnknown) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ocispec.ImageBlobsDir), 0o755); err != nil {
+		return err
+	}
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{},
+	})
+	if err != nil {
+		return err
+	}
+	if err := ociLayoutWriteFile(filepath.Join(dir, "index.json"), bytes.NewReader(index), nil); err != nil {
+		return err
+	}
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	// "oci-layout" goes last, so a partially initialized layout isn't mistaken for a valid one
+	return ociLayoutWriteFile(filepath.Join(dir, ocispec.ImageLayoutFile), bytes.NewReader(layout), nil)
+}
+
+// [ociLayoutInit], but while holding "mu" (otherwise two concurrent pushes to a new layout could both decide to initialize it, and the second would replace an "index.json" the first already added a tag to)
+func (l *ociLayout) init(dir string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ociLayoutInit(dir)
+}
+
+func (l *ociLayout) resolveBlob(dir string, digest ociregistry.Digest, notFound error) (ociregistry.Descriptor, error) {
+	if err := ociLayoutCheck(dir); err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	path, err := ociLayoutBlobPath(dir, digest)
+	if err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ociregistry.Descriptor{}, fmt.Errorf("%s: %w", digest, notFound)
+		}
+		return ociregistry.Descriptor{}, err
+	}
+	return ociregistry.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    digest,
+		Size:      fi.Size(),
+	}, nil
+}
+
+type ociLayoutBlobReader struct {
+	*os.File
+	desc ociregistry.Descriptor
+}
+
+func (r ociLayoutBlobReader) Descriptor() ociregistry.Descriptor {
+	return r.desc
+}
+
+func (l *ociLayout) GetBlob(ctx context.Context, dir string, digest ociregistry.Digest) (ociregistry.BlobReader, error) {
+	desc, err := l.ResolveBlob(ctx, dir, digest)
+	if err != nil {
+		return nil, err
+	}
+	path, err := ociLayoutBlobPath(dir, digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return ociLayoutBlobReader{File: f, desc: desc}, nil
+}
+
+func (l *ociLayout) ResolveBlob(ctx context.Context, dir string, digest ociregistry.Digest) (ociregistry.Descriptor, error) {
+	return l.resolveBlob(dir, digest, ociregistry.ErrBlobUnknown)
+}
+
+// manifests are read fully into memory (so we can figure out their mediaType)
+func (l *ociLayout) getManifest(dir string, desc ociregistry.Descriptor) (ociregistry.Descriptor, []byte, error) {
+	path, err := ociLayoutBlobPath(dir, desc.Digest)
+	if err != nil {
+		return desc, nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return desc, nil, fmt.Errorf("%s: %w", desc.Digest, ociregistry.ErrManifestUnknown)
+		}
+		return desc, nil, err
+	}
+	if got := godigest.FromBytes(b); got != desc.Digest {
+		return desc, nil, fmt.Errorf("%s: corrupt blob in %s (actual digest %s)", desc.Digest, dir, got)
+	}
+	desc.Size = int64(len(b))
+	if desc.MediaType == "" {
+		// index.json didn't tell us, so the manifest has to (see the "mediaType" comments in "cmd/deploy")
+		var mediaTypeHaver struct {
+			MediaType string `json:"mediaType"`
+		}
+		if err := json.Unmarshal(b, &mediaTypeHaver); err != nil {
+			return desc, nil, fmt.Errorf("%s: failed to parse manifest: %w", desc.Digest, err)
+		}
+		if mediaTypeHaver.MediaType == "" {
+			return desc, nil, fmt.Errorf("%s: manifest is missing mediaType", desc.Digest)
+		}
+		desc.MediaType = mediaTypeHaver.MediaType
+	}
+	return desc, b, nil
+}
+
+// finds the descriptor for the given digest (if it's listed directly in "index.json", which gives us a mediaType for free)
+func (l *ociLayout) indexDescriptor(dir string, digest ociregistry.Digest) (ociregistry.Descriptor, error) {
+	index, err := ociLayoutReadIndex(dir)
+	if err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	for _, desc := range index.Manifests {
+		if desc.Digest == digest {
+			return ociregistry.Descriptor{
+				MediaType: desc.MediaType,
+				Digest:    desc.Digest,
+				Size:      desc.Size,
+			}, nil
+		}
+	}
+	return ociregistry.Descriptor{Digest: digest}, nil
+}
+
+func (l *ociLayout) GetManifest(ctx context.Context, dir string, digest ociregistry.Digest) (ociregistry.BlobReader, error) {
+	desc, err := l.indexDescriptor(dir, digest)
+	if err != nil {
+		return nil, err
+	}
+	desc, b, err := l.getManifest(dir, desc)
+	if err != nil {
+		return nil, err
+	}
+	return ocimem.NewBytesReader(b, desc), nil
+}
+
+func (l *ociLayout) ResolveManifest(ctx context.Context, dir string, digest ociregistry.Digest) (ociregistry.Descriptor, error) {
+	r, err := l.GetManifest(ctx, dir, digest)
+	if err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	defer r.Close()
+	return r.Descriptor(), nil
+}
+
+// finds the (last) descriptor in "index.json" whose "org.opencontainers.image.ref.name" annotation is the given tag
+func (l *ociLayout) tagDescriptor(dir string, tag string) (ociregistry.Descriptor, error) {
+	index, err := ociLayoutReadIndex(dir)
+	if err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	for i := len(index.Manifests) - 1; i >= 0; i-- {
+		desc := index.Manifests[i]
+		if desc.Annotations[ocispec.AnnotationRefName] == tag {
+			return ociregistry.Descriptor{
+				MediaType: desc.MediaType,
+				Digest:    desc.Digest,
+				Size:      desc.Size,
+			}, nil
+		}
+	}
+	return ociregistry.Descriptor{}, fmt.Errorf("%s: %w", tag, ociregistry.ErrManifestUnknown)
+}
+
+func (l *ociLayout) GetTag(ctx context.Context, dir string, tag string) (ociregistry.BlobReader, error) {
+	desc, err := l.tagDescriptor(dir, tag)
+	if err != nil {
+		return nil, err
+	}
+	desc, b, err := l.getManifest(dir, desc)
+	if err != nil {
+		return nil, err
+	}
+	return ocimem.NewBytesReader(b, desc), nil
+}
+
+func (l *ociLayout) ResolveTag(ctx context.Context, dir string, tag string) (ociregistry.Descriptor, error) {
+	return l.tagDescriptor(dir, tag)
+}
+
+func (l *ociLayout) Tags(ctx context.Context, dir string, startAfter string) ociregistry.Seq[string] {
+	index, err := ociLayoutReadIndex(dir)
+	if err != nil {
+		return ociregistry.ErrorSeq[string](err)
+	}
+	var tags []string
+	for _, desc := range index.Manifests {
+		if tag, ok := desc.Annotations[ocispec.AnnotationRefName]; ok && tag > startAfter && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	slices.Sort(tags)
+	return ociregistry.SliceSeq(tags)
+}
+
+// writes the blob (verifying digest and size before it ever shows up in "blobs/", so readers never see corrupt content)
+func (l *ociLayout) writeBlob(dir string, desc ociregistry.Descriptor, r io.Reader) error {
+	path, err := ociLayoutBlobPath(dir, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if ociLayoutBlobValid(path, desc) {
+		// content-addressable, so if it's already there (and actually has the right content), we're done
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	verifier := desc.Digest.Verifier()
+	counter := &ociLayoutCounter{}
+	return ociLayoutWriteFile(path, io.TeeReader(io.TeeReader(r, verifier), counter), func() error {
+		if counter.n != desc.Size {
+			return fmt.Errorf("%s: %w (expected %d, got %d)", desc.Digest, ociregistry.ErrSizeInvalid, desc.Size, counter.n)
+		}
+		if !verifier.Verified() {
+			return fmt.Errorf("%s: %w", desc.Digest, ociregistry.ErrDigestInvalid)
+		}
+		return nil
+	})
+}
+
+// whether the existing blob at "path" has exactly the size and digest of "desc" (a matching size alone isn't enough to trust a file someone else might have written or truncated/corrupted)
+func ociLayoutBlobValid(path string, desc ociregistry.Descriptor) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || fi.Size() != desc.Size {
+		return false
+	}
+	verifier := desc.Digest.Verifier()
+	if _, err := io.Copy(verifier, f); err != nil {
+		return false
+	}
+	return verifier.Verified()
+}
+
+type ociLayoutCounter struct {
+	n int64
+}
+
+func (c *ociLayoutCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func (l *ociLayout) PushBlob(ctx context.Context, dir string, desc ociregistry.Descriptor, r io.Reader) (ociregistry.Descriptor, error) {
+	if err := l.init(dir); err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	if err := l.writeBlob(dir, desc, r); err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+func (l *ociLayout) MountBlob(ctx context.Context, fromDir, toDir string, digest ociregistry.Digest) (ociregistry.Descriptor, error) {
+	r, err := l.GetBlob(ctx, fromDir, digest)
+	if err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	defer r.Close()
+	return l.PushBlob(ctx, toDir, r.Descriptor(), r)
+}
+
+func (l *ociLayout) PushManifest(ctx context.Context, dir string, tag string, contents []byte, mediaType string) (ociregistry.Descriptor, error) {
+	desc := ociregistry.Descriptor{
+		MediaType: mediaType,
+		Digest:    godigest.FromBytes(contents),
+		Size:      int64(len(contents)),
+	}
+	if tag != "" && !ociref.IsValidTag(tag) {
+		return desc, fmt.Errorf("%q: %w", tag, ociregistry.ErrManifestInvalid)
+	}
+
+	if err := l.init(dir); err != nil {
+		return desc, err
+	}
+
+	// just like a registry, refuse manifests whose children don't exist (so [EnsureManifest] knows to copy them)
+	children, err := ParseManifestChildren(contents)
+	if err != nil {
+		return desc, fmt.Errorf("%w: %w", ociregistry.ErrManifestInvalid, err)
+	}
+	var childDescs []ocispec.Descriptor
+	childDescs = append(childDescs, children.Manifests...)
+	if children.Config != nil {
+		childDescs = append(childDescs, *children.Config)
+	}
+	childDescs = append(childDescs, children.Layers...)
+	for _, child := range childDescs {
+		childDesc, err := l.resolveBlob(dir, child.Digest, ociregistry.ErrManifestBlobUnknown)
+		if err != nil {
+			return desc, err
+		}
+		if childDesc.Size != child.Size {
+			return desc, fmt.Errorf("%s: %w (size %d vs %d)", child.Digest, ociregistry.ErrManifestInvalid, childDesc.Size, child.Size)
+		}
+	}
+
+	if err := l.writeBlob(dir, desc, bytes.NewReader(contents)); err != nil {
+		return desc, err
+	}
+
+	if tag == "" {
+		// untagged manifests don't get an entry in "index.json" (they're usually children of something else)
+		return desc, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	index, err := ociLayoutReadIndex(dir)
+	if err != nil {
+		return desc, err
+	}
+	index.Manifests = slices.DeleteFunc(index.Manifests, func(d ocispec.Descriptor) bool {
+		return d.Annotations[ocispec.AnnotationRefName] == tag
+	})
+	index.Manifests = append(index.Manifests, ocispec.Descriptor{
+		MediaType:   desc.MediaType,
+		Digest:      desc.Digest,
+		Size:        desc.Size,
+		Annotations: map[string]string{ocispec.AnnotationRefName: tag},
+	})
+	b, err := json.Marshal(index)
+	if err != nil {
+		return desc, err
+	}
+	if err := ociLayoutWriteFile(filepath.Join(dir, "index.json"), bytes.NewReader(b), nil); err != nil {
+		return desc, err
+	}
+
+	return desc, nil
+}
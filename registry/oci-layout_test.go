@@ -0,0 +1,188 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	godigest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestOCILayout(t *testing.T) {
+	ctx := context.Background()
+
+	src := testRegistry(t, "layout-src.invalid")
+	manifest, config, layer := testImage(t, src, "test", "latest", "some layer content")
+
+	srcRef, err := ParseRef("layout-src.invalid/test:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "layout")
+	layoutRef, err := ParseRef("oci:" + dir + ":copied")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("copy to layout", func(t *testing.T) {
+		desc, err := CopyManifest(ctx, srcRef, layoutRef, map[ociregistry.Digest]Reference{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if desc.Digest != manifest.Digest {
+			t.Fatalf("unexpected digest: %s", desc.Digest)
+		}
+
+		for _, d := range []ociregistry.Digest{manifest.Digest, config.Digest, layer.Digest} {
+			if _, err := os.Stat(filepath.Join(dir, "blobs", d.Algorithm().String(), d.Encoded())); err != nil {
+				t.Errorf("missing blob: %v", err)
+			}
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, "index.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index ocispec.Index
+		if err := json.Unmarshal(b, &index); err != nil {
+			t.Fatal(err)
+		}
+		if len(index.Manifests) != 1 || index.Manifests[0].Digest != manifest.Digest || index.Manifests[0].Annotations[ocispec.AnnotationRefName] != "copied" {
+			t.Fatalf("unexpected index.json: %s", b)
+		}
+	})
+
+	t.Run("lookup", func(t *testing.T) {
+		r, err := Lookup(ctx, layoutRef, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r == nil {
+			t.Fatal("tag not found")
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := godigest.FromBytes(b); got != manifest.Digest || r.Descriptor().MediaType != ocispec.MediaTypeImageManifest {
+			t.Fatalf("unexpected manifest: %s (%+v)", got, r.Descriptor())
+		}
+
+		missing := layoutRef
+		missing.Tag = "missing"
+		if r, err := Lookup(ctx, missing, &LookupOptions{Head: true}); err != nil || r != nil {
+			t.Fatalf("expected 404 for missing tag, got %v, %v", r, err)
+		}
+
+		notLayout, err := ParseRef("oci:" + filepath.Join(t.TempDir(), "nope") + ":latest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, err := Lookup(ctx, notLayout, &LookupOptions{Head: true}); err != nil || r != nil {
+			t.Fatalf("expected 404 for missing layout, got %v, %v", r, err)
+		}
+	})
+
+	t.Run("copy between layouts", func(t *testing.T) {
+		otherRef, err := ParseRef("oci:" + filepath.Join(t.TempDir(), "other") + ":again")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := CopyManifest(ctx, layoutRef, otherRef, map[ociregistry.Digest]Reference{}); err != nil {
+			t.Fatal(err)
+		}
+		r, err := Lookup(ctx, otherRef, &LookupOptions{Head: true})
+		if err != nil || r == nil {
+			t.Fatalf("expected tag, got %v, %v", r, err)
+		}
+		if r.Descriptor().Digest != manifest.Digest {
+			t.Fatalf("unexpected digest: %s", r.Descriptor().Digest)
+		}
+	})
+
+	t.Run("synthesize", func(t *testing.T) {
+		index, err := SynthesizeIndex(ctx, layoutRef, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index == nil || len(index.Manifests) != 1 {
+			t.Fatalf("unexpected index: %+v", index)
+		}
+		m := index.Manifests[0]
+		if m.Platform == nil || m.Platform.OS != "linux" || m.Platform.Architecture != "amd64" || m.Annotations[AnnotationBashbrewArch] != "amd64" {
+			t.Errorf("unexpected platform: %+v", m)
+		}
+		wantRef := layoutRef
+		wantRef.Digest = manifest.Digest
+		if got := m.Annotations[ocispec.AnnotationRefName]; got != wantRef.String() {
+			t.Errorf("expected ref.name %q, got %q", wantRef.String(), got)
+		}
+	})
+
+	t.Run("concurrent tags on a new layout", func(t *testing.T) {
+		// (every push races to initialize the layout, and none of their tags should be lost to a later initialization)
+		layout := OCILayout()
+		dir := filepath.Join(t.TempDir(), "concurrent")
+		contents := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`)
+		var wg sync.WaitGroup
+		errs := make([]error, 20)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = layout.PushManifest(ctx, dir, fmt.Sprintf("tag%d", i), contents, ocispec.MediaTypeImageIndex)
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		tags, err := ociregistry.All(layout.Tags(ctx, dir, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tags) != len(errs) {
+			t.Fatalf("expected %d tags, got %d: %v", len(errs), len(tags), tags)
+		}
+	})
+
+	t.Run("corrupt blobs", func(t *testing.T) {
+		layout := OCILayout()
+		dir := filepath.Join(t.TempDir(), "corrupt")
+		content := []byte("some blob content")
+		desc := ociregistry.Descriptor{MediaType: "application/octet-stream", Digest: godigest.FromBytes(content), Size: int64(len(content))}
+		path := filepath.Join(dir, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+
+		// same size, wrong content: rejected, and never visible in "blobs/"
+		if _, err := layout.PushBlob(ctx, dir, desc, bytes.NewReader(bytes.ToUpper(content))); !errors.Is(err, ociregistry.ErrDigestInvalid) {
+			t.Fatalf("expected %v, got %v", ociregistry.ErrDigestInvalid, err)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected no blob after a failed push, got %v", err)
+		}
+
+		// a corrupted blob that's already there (with the right size) gets replaced instead of trusted
+		if err := os.WriteFile(path, bytes.ToUpper(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := layout.PushBlob(ctx, dir, desc, bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(path); err != nil || !bytes.Equal(b, content) {
+			t.Fatalf("expected blob to be rewritten, got %q (%v)", b, err)
+		}
+	})
+}
@@ -0,0 +1,305 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// the "Plan*" functions in this file are read-only mirrors of [EnsureManifest], [CopyManifest], [EnsureBlob], and [CopyBlob] that walk the same children (via the same lookup rules) but only report what *would* happen instead of pushing anything
+
+// see `PlanAction*` consts for possible values for this type
+type PlanAction string
+
+const (
+	// the object already exists at the destination (nothing to do)
+	PlanActionExists PlanAction = "exists"
+	// the object's content is already in hand (embedded data, for example) and only needs to be pushed
+	PlanActionPush PlanAction = "push"
+	// the (blob) object exists in another repository on the same registry and can be mounted
+	PlanActionMount PlanAction = "mount"
+	// the object needs to be read from somewhere else and then pushed (cross-registry blobs, and manifests, since there is no manifest equivalent of mounting)
+	PlanActionCopy PlanAction = "copy"
+)
+
+// a single object (manifest or blob) that is part of a [Plan]
+type PlanObject struct {
+	Type      LookupType `json:"type"`
+	Action    PlanAction `json:"action"`
+	Ref       Reference  `json:"ref"`            // where the object would end up (always by-digest)
+	From      *Reference `json:"from,omitempty"` // where the object would come from (for [PlanActionMount] and [PlanActionCopy])
+	MediaType string     `json:"mediaType,omitempty"`
+	Size      int64      `json:"size"`
+
+	// any child objects (manifests, config, layers) that were checked because this object does not exist at the destination yet
+	Children []PlanObject `json:"children,omitempty"`
+}
+
+// a tag that would be created or updated
+type PlanTag struct {
+	Ref  Reference          `json:"ref"`
+	From ociregistry.Digest `json:"from,omitempty"` // empty if the tag does not exist yet
+	To   ociregistry.Digest `json:"to"`
+}
+
+// whether the tag would actually change
+func (t PlanTag) Moves() bool {
+	return t.From != t.To
+}
+
+// the result of a "Plan*" function: the top-level object (and its children) plus any tag it would update
+type Plan struct {
+	PlanObject
+	Tag *PlanTag `json:"tag,omitempty"`
+}
+
+// the sum of the sizes of every object (including this one) whose content would need to be read and pushed again (that is, [PlanActionCopy]; cross-registry blobs, but also every manifest that isn't at the destination yet, even within the same registry)
+func (o PlanObject) CopyBytes() int64 {
+	var total int64
+	if o.Action == PlanActionCopy {
+		total += o.Size
+	}
+	for _, child := range o.Children {
+		total += child.CopyBytes()
+	}
+	return total
+}
+
+// the read-only equivalent of [EnsureManifest]
+func PlanEnsureManifest(ctx context.Context, ref Reference, manifest json.RawMessage, mediaType string, childRefs map[ociregistry.Digest]Reference) (Plan, error) {
+	desc := ociregistry.Descriptor{
+		MediaType: mediaType,
+		Digest:    godigest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+	}
+	if ref.Digest != "" && ref.Digest != desc.Digest {
+		return Plan{}, fmt.Errorf("%s: digest mismatch: %s", ref, desc.Digest)
+	}
+
+	if _, ok := childRefs[""]; !ok {
+		// empty digest is a "fallback" ref for where missing children might be found (if we don't have one, inject one)
+		childRefs[""] = ref
+	}
+
+	digestRef := ref
+	digestRef.Tag = ""
+	digestRef.Digest = desc.Digest
+
+	plan := Plan{
+		PlanObject: PlanObject{
+			Type:      LookupTypeManifest,
+			Action:    PlanActionPush,
+			Ref:       digestRef,
+			MediaType: desc.MediaType,
+			Size:      desc.Size,
+		},
+	}
+
+	if ref.Tag != "" {
+		tagRef := ref
+		tagRef.Digest = ""
+		plan.Tag = &PlanTag{
+			Ref: tagRef,
+			To:  desc.Digest,
+		}
+		r, err := Lookup(ctx, tagRef, &LookupOptions{Head: true})
+		if err != nil {
+			return plan, fmt.Errorf("%s: failed HEAD: %w", tagRef, err)
+		}
+		if r != nil {
+			plan.Tag.From = r.Descriptor().Digest
+			r.Close()
+		}
+	}
+
+	exists, err := planExists(ctx, digestRef, LookupTypeManifest, desc.Size)
+	if err != nil {
+		return plan, err
+	}
+	if exists {
+		// just like EnsureManifest, if the manifest exists we can assume its children do too
+		plan.Action = PlanActionExists
+		return plan, nil
+	}
+
+	plan.Children, err = planChildren(ctx, digestRef, manifest, childRefs)
+	return plan, err
+}
+
+// the read-only equivalent of [CopyManifest]
+func PlanCopyManifest(ctx context.Context, srcRef, dstRef Reference, childRefs map[ociregistry.Digest]Reference) (Plan, error) {
+	r, err := Lookup(ctx, srcRef, nil)
+	if err != nil {
+		return Plan{}, fmt.Errorf("%s: lookup failed: %w", srcRef, err)
+	}
+	if r == nil {
+		return Plan{}, fmt.Errorf("%s: manifest not found", srcRef)
+	}
+	defer r.Close()
+	desc := r.Descriptor()
+
+	manifest, err := io.ReadAll(r)
+	if err != nil {
+		return Plan{}, fmt.Errorf("%s: reading manifest failed: %w", srcRef, err)
+	}
+
+	if _, ok := childRefs[""]; !ok {
+		// if we don't have a fallback, set it to src
+		childRefs[""] = srcRef
+	}
+
+	plan, err := PlanEnsureManifest(ctx, dstRef, manifest, desc.MediaType, childRefs)
+	if err == nil && plan.Action == PlanActionPush {
+		plan.Action = PlanActionCopy
+		from := srcRef
+		from.Tag = ""
+		from.Digest = desc.Digest
+		plan.From = &from
+	}
+	return plan, err
+}
+
+// the read-only equivalent of [EnsureBlob]
+func PlanEnsureBlob(ctx context.Context, ref Reference, size int64) (Plan, error) {
+	plan := Plan{
+		PlanObject: PlanObject{
+			Type:   LookupTypeBlob,
+			Action: PlanActionPush,
+			Ref:    ref,
+			Size:   size,
+		},
+	}
+	if ref.Digest == "" {
+		return plan, fmt.Errorf("%s: blobs must be pushed by digest", ref)
+	}
+	exists, err := planExists(ctx, ref, LookupTypeBlob, size)
+	if exists {
+		plan.Action = PlanActionExists
+	}
+	return plan, err
+}
+
+// the read-only equivalent of [CopyBlob]
+func PlanCopyBlob(ctx context.Context, srcRef, dstRef Reference) (Plan, error) {
+	if srcRef.Digest == "" {
+		return Plan{}, fmt.Errorf("%s: missing digest (cannot copy blob without digest)", srcRef)
+	} else if !(dstRef.Digest == "" || dstRef.Digest == srcRef.Digest) {
+		return Plan{}, fmt.Errorf("%s: digest mismatch in copy: %s", dstRef, srcRef)
+	}
+	dstRef.Digest = srcRef.Digest
+	srcRef.Tag = ""
+	dstRef.Tag = ""
+
+	r, err := Lookup(ctx, srcRef, &LookupOptions{Type: LookupTypeBlob, Head: true})
+	if err != nil {
+		return Plan{}, fmt.Errorf("%s: blob lookup failed: %w", srcRef, err)
+	}
+	if r == nil {
+		return Plan{}, fmt.Errorf("%s: blob not found", srcRef)
+	}
+	desc := r.Descriptor()
+	r.Close()
+
+	obj, err := planBlob(ctx, srcRef, dstRef, desc)
+	return Plan{PlanObject: obj}, err
+}
+
+// whether the given object exists (by digest, with the expected size) at ref
+func planExists(ctx context.Context, ref Reference, lookupType LookupType, size int64) (bool, error) {
+	_, ok, err := headMatches(ctx, ref, lookupType, ociregistry.Descriptor{Digest: ref.Digest, Size: size})
+	return ok, err
+}
+
+// see "childToRefs" and the children handling in [EnsureManifest]
+func planChildren(ctx context.Context, ref Reference, manifest []byte, childRefs map[ociregistry.Digest]Reference) ([]PlanObject, error) {
+	manifestChildren, err := ParseManifestChildren(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed parsing manifest JSON: %w", ref, err)
+	}
+
+	var children []PlanObject
+
+	for _, child := range manifestChildren.Manifests {
+		childRef, childTargetRef := childToRefs(ref, child, childRefs)
+
+		obj := PlanObject{
+			Type:      LookupTypeManifest,
+			Action:    PlanActionExists,
+			Ref:       childTargetRef,
+			MediaType: child.MediaType,
+			Size:      child.Size,
+		}
+		exists, err := planExists(ctx, childTargetRef, LookupTypeManifest, child.Size)
+		if err != nil {
+			return children, err
+		}
+		if !exists {
+			r, err := Lookup(ctx, childRef, nil)
+			if err != nil {
+				return children, fmt.Errorf("%s: manifest lookup failed: %w", childRef, err)
+			}
+			if r == nil {
+				return children, fmt.Errorf("%s: manifest not found", childRef)
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				r.Close()
+				return children, fmt.Errorf("%s: ReadAll of GetManifest failed: %w", childRef, err)
+			}
+			if err := r.Close(); err != nil {
+				return children, fmt.Errorf("%s: Close of GetManifest failed: %w", childRef, err)
+			}
+			grandchildRefs := maps.Clone(childRefs)
+			grandchildRefs[""] = childRef // make the child's ref explicitly the "fallback" ref for any of its children
+			obj.Action = PlanActionCopy
+			obj.From = &childRef
+			obj.Children, err = planChildren(ctx, childTargetRef, b, grandchildRefs)
+			if err != nil {
+				return children, err
+			}
+		}
+		children = append(children, obj)
+	}
+
+	var childBlobs []ociregistry.Descriptor
+	if manifestChildren.Config != nil {
+		childBlobs = append(childBlobs, *manifestChildren.Config)
+	}
+	childBlobs = append(childBlobs, manifestChildren.Layers...)
+	for _, child := range childBlobs {
+		childRef, childTargetRef := childToRefs(ref, child, childRefs)
+		obj, err := planBlob(ctx, childRef, childTargetRef, child)
+		if err != nil {
+			return children, err
+		}
+		children = append(children, obj)
+	}
+
+	return children, nil
+}
+
+func planBlob(ctx context.Context, srcRef, dstRef Reference, desc ociregistry.Descriptor) (PlanObject, error) {
+	obj := PlanObject{
+		Type:      LookupTypeBlob,
+		Action:    PlanActionExists,
+		Ref:       dstRef,
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+	}
+	exists, err := planExists(ctx, dstRef, LookupTypeBlob, desc.Size)
+	if err != nil || exists {
+		return obj, err
+	}
+	obj.From = &srcRef
+	if srcRef.Host == dstRef.Host {
+		obj.Action = PlanActionMount
+	} else {
+		obj.Action = PlanActionCopy
+	}
+	return obj, nil
+}
@@ -0,0 +1,180 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	"cuelabs.dev/go/oci/ociregistry/ocimem"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// inject an in-memory registry as the [Client] for the given (fake) host
+func testRegistry(t *testing.T, host string) ociregistry.Interface {
+	t.Helper()
+	reg := ocimem.New()
+	testClient(t, host, reg)
+	return reg
+}
+
+// inject the given registry as the [Client] for the given (fake) host
+func testClient(t *testing.T, host string, reg ociregistry.Interface) {
+	t.Helper()
+	if _, loaded := clientCache.LoadOrStore(host, sync.OnceValues(func() (ociregistry.Interface, error) {
+		return reg, nil
+	})); loaded {
+		t.Fatalf("host %q already has a client", host)
+	}
+	t.Cleanup(func() {
+		clientCache.Delete(host)
+	})
+}
+
+func testPushBlob(t *testing.T, reg ociregistry.Interface, repo string, mediaType string, content []byte) ocispec.Descriptor {
+	t.Helper()
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    godigest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+	if _, err := reg.PushBlob(context.Background(), repo, desc, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func testPushManifest(t *testing.T, reg ociregistry.Interface, repo, tag string, manifest any) ocispec.Descriptor {
+	t.Helper()
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := reg.PushManifest(context.Background(), repo, tag, b, ocispec.MediaTypeImageManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func testImage(t *testing.T, reg ociregistry.Interface, repo, tag, layer string) (ocispec.Descriptor, ocispec.Descriptor, ocispec.Descriptor) {
+	t.Helper()
+	config := testPushBlob(t, reg, repo, ocispec.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"linux"}`))
+	layerDesc := testPushBlob(t, reg, repo, ocispec.MediaTypeImageLayer, []byte(layer))
+	manifest := testPushManifest(t, reg, repo, tag, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	return manifest, config, layerDesc
+}
+
+func TestPlanCopyManifest(t *testing.T) {
+	ctx := context.Background()
+
+	src := testRegistry(t, "plan-src.invalid")
+	dst := testRegistry(t, "plan-dst.invalid")
+
+	manifest, config, layer := testImage(t, src, "test", "latest", "some layer content")
+	// the destination already has the config blob (but not the layer) and has an older image on the tag we're going to update
+	testPushBlob(t, dst, "test", config.MediaType, []byte(`{"architecture":"amd64","os":"linux"}`))
+	old, _, _ := testImage(t, dst, "test", "latest", "older layer content")
+
+	srcRef, err := ParseRef("plan-src.invalid/test:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("cross-registry", func(t *testing.T) {
+		dstRef, err := ParseRef("plan-dst.invalid/test:latest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := PlanCopyManifest(ctx, srcRef, dstRef, map[ociregistry.Digest]Reference{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if plan.Action != PlanActionCopy || plan.Ref.Digest != manifest.Digest {
+			t.Errorf("unexpected top-level plan: %+v", plan.PlanObject)
+		}
+		if plan.Tag == nil || plan.Tag.From != old.Digest || plan.Tag.To != manifest.Digest || !plan.Tag.Moves() {
+			t.Errorf("unexpected tag plan: %+v", plan.Tag)
+		}
+		if len(plan.Children) != 2 {
+			t.Fatalf("expected 2 children, got %d: %+v", len(plan.Children), plan.Children)
+		}
+		if c := plan.Children[0]; c.Ref.Digest != config.Digest || c.Action != PlanActionExists {
+			t.Errorf("unexpected config plan: %+v", c)
+		}
+		if c := plan.Children[1]; c.Ref.Digest != layer.Digest || c.Action != PlanActionCopy || c.From == nil || c.From.Host != "plan-src.invalid" {
+			t.Errorf("unexpected layer plan: %+v", c)
+		}
+		if got, want := plan.CopyBytes(), manifest.Size+layer.Size; got != want {
+			t.Errorf("expected %d bytes to copy, got %d", want, got)
+		}
+	})
+
+	t.Run("same-registry", func(t *testing.T) {
+		dstRef, err := ParseRef("plan-src.invalid/other:foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := PlanCopyManifest(ctx, srcRef, dstRef, map[ociregistry.Digest]Reference{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Tag == nil || plan.Tag.From != "" {
+			t.Errorf("expected new tag, got: %+v", plan.Tag)
+		}
+		for _, c := range plan.Children {
+			if c.Action != PlanActionMount {
+				t.Errorf("expected mount, got: %+v", c)
+			}
+		}
+		if got, want := plan.CopyBytes(), manifest.Size; got != want {
+			t.Errorf("expected %d bytes to copy (just the manifest), got %d", want, got)
+		}
+	})
+
+	t.Run("exists", func(t *testing.T) {
+		plan, err := PlanCopyManifest(ctx, srcRef, srcRef, map[ociregistry.Digest]Reference{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Action != PlanActionExists || len(plan.Children) != 0 || plan.Tag.Moves() {
+			t.Errorf("expected nothing to do, got: %+v", plan)
+		}
+	})
+
+	t.Run("blob", func(t *testing.T) {
+		blobSrc := srcRef
+		blobSrc.Tag = ""
+		blobSrc.Digest = layer.Digest
+		dstRef, err := ParseRef("plan-dst.invalid/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := PlanCopyBlob(ctx, blobSrc, dstRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Action != PlanActionCopy || plan.Size != layer.Size {
+			t.Errorf("unexpected blob plan: %+v", plan)
+		}
+
+		dstRef.Digest = config.Digest
+		plan, err = PlanEnsureBlob(ctx, dstRef, config.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Action != PlanActionExists {
+			t.Errorf("unexpected blob plan: %+v", plan)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker-library/bashbrew/architecture"
+
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// parses either a bashbrew architecture ("arm64v8", "windows-amd64", etc; see [architecture.SupportedArches]) or an OCI platform string ("linux/arm64/v8", "windows/amd64", etc) into an [ocispec.Platform]
+func ParsePlatform(s string) (ocispec.Platform, error) {
+	if p, ok := architecture.SupportedArches[s]; ok {
+		return ocispec.Platform(p), nil
+	}
+	if !strings.Contains(s, "/") {
+		// containerd's parser would happily "fill in the blanks" from whatever platform we happen to be running on, which is never what we want here
+		return ocispec.Platform{}, fmt.Errorf("invalid platform %q: expected a bashbrew architecture or os/arch[/variant]", s)
+	}
+	p, err := platforms.Parse(s)
+	if err != nil {
+		return ocispec.Platform{}, fmt.Errorf("invalid platform %q: %w", s, err)
+	}
+	return p, nil
+}
+
+// returns the single image descriptor from [SynthesizeIndex] that best matches the given platform, using the same matching rules as containerd (and thus Docker) do for pulling images: variants fall back ("linux/arm/v7" will match "linux/arm/v6" if that's all there is, "linux/arm64/v8" will match "linux/arm/v7", etc) and exact matches win over fallbacks
+//
+// for Windows, if "platform" has an os.version, only images with the same build number ("10.0.20348.X") match, with an exact os.version preferred over a newer revision preferred over an older one; without an os.version, more than one Windows version is ambiguous
+//
+// returns nil (and no error) if nothing matches, and a [MultipleImagesError] if more than one image is an equally good match
+func ResolvePlatform(ctx context.Context, ref Reference, platform ocispec.Platform) (*ocispec.Descriptor, error) {
+	index, err := SynthesizeIndex(ctx, ref, nil)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		return nil, nil
+	}
+
+	want := architecture.Normalize(platform)
+	matcher := platforms.Only(want)
+
+	var candidates []ocispec.Descriptor
+	for _, m := range index.Manifests {
+		if m.Platform == nil || m.Annotations[annotationBuildkitReferenceType] == annotationBuildkitReferenceTypeAttestation {
+			continue
+		}
+		if !matcher.Match(*m.Platform) {
+			continue
+		}
+		if want.OS == "windows" && want.OSVersion != "" && windowsBuild(m.Platform.OSVersion) != windowsBuild(want.OSVersion) {
+			continue
+		}
+		candidates = append(candidates, m)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	less := func(a, b ocispec.Descriptor) bool {
+		if matcher.Less(*a.Platform, *b.Platform) {
+			return true
+		}
+		if matcher.Less(*b.Platform, *a.Platform) {
+			return false
+		}
+		if want.OS == "windows" && want.OSVersion != "" {
+			if aExact, bExact := a.Platform.OSVersion == want.OSVersion, b.Platform.OSVersion == want.OSVersion; aExact != bExact {
+				return aExact
+			}
+			return windowsRevision(a.Platform.OSVersion) > windowsRevision(b.Platform.OSVersion)
+		}
+		return false
+	}
+	slices.SortStableFunc(candidates, func(a, b ocispec.Descriptor) int {
+		switch {
+		case less(a, b):
+			return -1
+		case less(b, a):
+			return 1
+		}
+		return 0
+	})
+
+	if len(candidates) > 1 && !less(candidates[0], candidates[1]) {
+		multiple := MultipleImagesError{Arch: candidates[0].Annotations[AnnotationBashbrewArch]}
+		if multiple.Arch == "" {
+			multiple.Arch = platforms.Format(want)
+		}
+		if want.OS == "windows" && want.OSVersion != "" {
+			multiple.OSVersion = candidates[0].Platform.OSVersion
+		}
+		for _, c := range candidates {
+			if less(candidates[0], c) {
+				break
+			}
+			multiple.Digests = append(multiple.Digests, c.Digest)
+		}
+		return nil, fmt.Errorf("%s: %w", ref, multiple)
+	}
+
+	return &candidates[0], nil
+}
+
+// "10.0.20348.2655" => "10.0.20348" (the part of a Windows os.version that has to match for an image to run on a given host)
+func windowsBuild(osVersion string) string {
+	parts := strings.SplitN(osVersion, ".", 4)
+	return strings.Join(parts[:min(len(parts), 3)], ".")
+}
+
+// "10.0.20348.2655" => 2655 (or -1 if there isn't one)
+func windowsRevision(osVersion string) int {
+	parts := strings.SplitN(osVersion, ".", 4)
+	if len(parts) < 4 {
+		return -1
+	}
+	rev, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return -1
+	}
+	return rev
+}
@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestParsePlatform(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want ocispec.Platform
+		err  bool
+	}{
+		{in: "arm64v8", want: ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{in: "windows-amd64", want: ocispec.Platform{OS: "windows", Architecture: "amd64"}},
+		{in: "linux/arm/v7", want: ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{in: "linux/amd64", want: ocispec.Platform{OS: "linux", Architecture: "amd64"}},
+		{in: "arm64", err: true}, // neither a bashbrew arch nor a full platform
+		{in: "linux/arm/v7/extra", err: true},
+	} {
+		t.Run(test.in, func(t *testing.T) {
+			got, err := ParsePlatform(test.in)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.OS != test.want.OS || got.Architecture != test.want.Architecture || got.Variant != test.want.Variant {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestResolvePlatform(t *testing.T) {
+	ctx := context.Background()
+
+	reg := testRegistry(t, "resolve-platform.invalid")
+
+	image := func(layer string, platform ocispec.Platform) ocispec.Descriptor {
+		t.Helper()
+		desc, _, _ := testImage(t, reg, "test", "", layer)
+		desc.Platform = &platform
+		return desc
+	}
+	var (
+		amd64       = image("amd64", ocispec.Platform{OS: "linux", Architecture: "amd64"})
+		armv6       = image("armv6", ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v6"})
+		arm64       = image("arm64", ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"})
+		ltsc2022    = image("ltsc2022", ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.2655"})
+		ltsc2022old = image("ltsc2022 (older revision)", ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.100"})
+		ltsc2025    = image("ltsc2025", ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.26100.1742"})
+	)
+	b, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{amd64, armv6, arm64, ltsc2022old, ltsc2022, ltsc2025},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.PushManifest(ctx, "test", "latest", b, ocispec.MediaTypeImageIndex); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := ParseRef("resolve-platform.invalid/test:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		platform ocispec.Platform
+		want     *ocispec.Descriptor
+		multiple bool
+	}{
+		{name: "exact", platform: ocispec.Platform{OS: "linux", Architecture: "amd64"}, want: &amd64},
+		{name: "arm64 (no variant)", platform: ocispec.Platform{OS: "linux", Architecture: "arm64"}, want: &arm64},
+		{name: "variant fallback", platform: ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, want: &armv6},
+		{name: "no fallback upwards", platform: ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v5"}},
+		{name: "no match", platform: ocispec.Platform{OS: "linux", Architecture: "s390x"}},
+		{name: "windows os.version (newest revision)", platform: ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.1"}, want: &ltsc2022},
+		{name: "windows os.version (exact)", platform: ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.100"}, want: &ltsc2022old},
+		{name: "windows unknown build", platform: ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1"}},
+		{name: "windows ambiguous", platform: ocispec.Platform{OS: "windows", Architecture: "amd64"}, multiple: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ResolvePlatform(ctx, ref, test.platform)
+			if test.multiple {
+				var multiple MultipleImagesError
+				if !errors.As(err, &multiple) {
+					t.Fatalf("expected MultipleImagesError, got %v (%v)", err, got)
+				}
+				if len(multiple.Digests) != 3 {
+					t.Fatalf("unexpected digests: %v", multiple.Digests)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case test.want == nil && got != nil:
+				t.Fatalf("expected no match, got %v", got)
+			case test.want != nil && got == nil:
+				t.Fatalf("expected %s, got no match", test.want.Digest)
+			case test.want != nil && got.Digest != test.want.Digest:
+				t.Fatalf("expected %s, got %s (%v)", test.want.Digest, got.Digest, got.Platform)
+			}
+		})
+	}
+}
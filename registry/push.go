@@ -50,17 +50,10 @@ func EnsureManifest(ctx context.Context, ref Reference, manifest json.RawMessage
 		// if this function is called with *both* tag *and* digest, the code below works correctly and pushes by tag and then validates by digest, but this lookup specifically will prefer the digest instead and skip when it shouldn't
 		headRef.Digest = ""
 	}
-	r, err := Lookup(ctx, headRef, &LookupOptions{Head: true})
-	if err != nil {
-		return desc, fmt.Errorf("%s: failed HEAD: %w", ref, err)
-	}
-	// TODO if we had some kind of progress interface, this would be a great place for some kind of debug log of head's contents
-	if r != nil {
-		head := r.Descriptor()
-		r.Close()
-		if head.Digest == desc.Digest && head.Size == desc.Size {
-			return head, nil
-		}
+	if head, ok, err := headMatches(ctx, headRef, LookupTypeManifest, desc); err != nil {
+		return desc, err
+	} else if ok {
+		return head, nil
 	}
 
 	// since we need to potentially retry this call after copying/mounting children, let's wrap it up for ease of use
@@ -79,23 +72,8 @@ func EnsureManifest(ctx context.Context, ref Reference, manifest json.RawMessage
 				return desc, fmt.Errorf("%s: failed parsing manifest JSON: %w", ref, err)
 			}
 
-			childToRefs := func(child ocispec.Descriptor) (Reference, Reference) {
-				childTargetRef := Reference{
-					Host:       ref.Host,
-					Repository: ref.Repository,
-					Digest:     child.Digest,
-				}
-				childRef, ok := childRefs[child.Digest]
-				if !ok {
-					childRef = childRefs[""]
-				}
-				childRef.Tag = ""
-				childRef.Digest = child.Digest
-				return childRef, childTargetRef
-			}
-
 			for _, child := range manifestChildren.Manifests {
-				childRef, childTargetRef := childToRefs(child)
+				childRef, childTargetRef := childToRefs(ref, child, childRefs)
 				r, err := Lookup(ctx, childRef, nil)
 				if err != nil {
 					return desc, fmt.Errorf("%s: manifest lookup failed: %w", childRef, err)
@@ -128,7 +106,7 @@ func EnsureManifest(ctx context.Context, ref Reference, manifest json.RawMessage
 			}
 			childBlobs = append(childBlobs, manifestChildren.Layers...)
 			for _, child := range childBlobs {
-				childRef, childTargetRef := childToRefs(child)
+				childRef, childTargetRef := childToRefs(ref, child, childRefs)
 				// TODO if blob sets URLs, don't bother (foreign layer) -- maybe check for those MediaTypes explicitly? (not a high priority as they're no longer used and officially discouraged/deprecated; would only matter if Tianon wants to use this for "hell/win" too 👀)
 				if _, err := CopyBlob(ctx, childRef, childTargetRef); err != nil {
 					return desc, fmt.Errorf("%s: CopyBlob(%s) failed: %w", childTargetRef, childRef, err)
@@ -151,9 +129,77 @@ func EnsureManifest(ctx context.Context, ref Reference, manifest json.RawMessage
 	return desc, nil
 }
 
+// the "fast path" shared by [EnsureManifest], [CopyManifest], [EnsureBlob], and friends: a HEAD request for the given reference, and whether the result matches the given descriptor (digest *and* size)
+func headMatches(ctx context.Context, ref Reference, lookupType LookupType, desc ociregistry.Descriptor) (ociregistry.Descriptor, bool, error) {
+	r, err := Lookup(ctx, ref, &LookupOptions{Type: lookupType, Head: true})
+	if err != nil {
+		return desc, false, fmt.Errorf("%s: failed HEAD: %w", ref, err)
+	}
+	// TODO if we had some kind of progress interface, this would be a great place for some kind of debug log of head's contents
+	if r == nil {
+		return desc, false, nil
+	}
+	head := r.Descriptor()
+	r.Close()
+	return head, head.Digest == desc.Digest && head.Size == desc.Size, nil
+}
+
+// this does a HEAD request on both the source and the destination of a manifest copy (see [CopyManifest]) and returns the source descriptor and whether the destination already matches it, so callers can skip fetching the source manifest entirely when there's nothing to do
+//
+// these requests go through [Client] (and thus [RegistryCache]), so the results are cached for the rest of the run (a source referenced by many destination tags only gets resolved once)
+func CopyManifestUpToDate(ctx context.Context, srcRef, dstRef Reference) (ociregistry.Descriptor, bool, error) {
+	r, err := Lookup(ctx, srcRef, &LookupOptions{Head: true})
+	if err != nil {
+		return ociregistry.Descriptor{}, false, fmt.Errorf("%s: failed HEAD: %w", srcRef, err)
+	}
+	if r == nil {
+		return ociregistry.Descriptor{}, false, fmt.Errorf("%s: manifest not found", srcRef)
+	}
+	desc := r.Descriptor()
+	r.Close()
+	if desc.Digest == "" {
+		return desc, false, fmt.Errorf("%s: manifest is missing digest", srcRef)
+	}
+
+	if dstRef.Tag != "" {
+		// see "headRef" in [EnsureManifest] (the tag is what we care about)
+		dstRef.Digest = ""
+	} else if dstRef.Digest == "" {
+		// copy from tag but push by digest (weird, but valid)
+		dstRef.Digest = desc.Digest
+	} else if dstRef.Digest != desc.Digest {
+		// this is an error, but one [EnsureManifest] will report for us in more detail
+		return desc, false, nil
+	}
+
+	_, ok, err := headMatches(ctx, dstRef, LookupTypeManifest, desc)
+	return desc, ok, err
+}
+
+// given a parent [Reference] and a child descriptor (and the lookup map of where to find children), returns where the child can be found (first) and where the child needs to end up (second)
+func childToRefs(ref Reference, child ocispec.Descriptor, childRefs map[ociregistry.Digest]Reference) (Reference, Reference) {
+	childTargetRef := Reference{
+		Host:       ref.Host,
+		Repository: ref.Repository,
+		Digest:     child.Digest,
+	}
+	childRef, ok := childRefs[child.Digest]
+	if !ok {
+		childRef = childRefs[""]
+	}
+	childRef.Tag = ""
+	childRef.Digest = child.Digest
+	return childRef, childTargetRef
+}
+
 // this copies a manifest (index or image) and all child objects (manifests or config+layers) from one name to another
 func CopyManifest(ctx context.Context, srcRef, dstRef Reference, childRefs map[ociregistry.Digest]Reference) (ociregistry.Descriptor, error) {
-	var desc ociregistry.Descriptor
+	desc, upToDate, err := CopyManifestUpToDate(ctx, srcRef, dstRef)
+	if err != nil || upToDate {
+		return desc, err
+	}
+	// from here on, look up by digest so we copy exactly the object we compared against above
+	srcRef.Digest = desc.Digest
 
 	// wouldn't it be nice if MountBlob for manifests was a thing? 🥺
 	r, err := Lookup(ctx, srcRef, nil)
@@ -194,17 +240,10 @@ func EnsureBlob(ctx context.Context, ref Reference, size int64, content io.Reade
 	}
 
 	if desc.Size > BlobSizeWorthHEAD {
-		r, err := Lookup(ctx, ref, &LookupOptions{Type: LookupTypeBlob, Head: true})
-		if err != nil {
-			return desc, fmt.Errorf("%s: failed HEAD: %w", ref, err)
-		}
-		// TODO if we had some kind of progress interface, this would be a great place for some kind of debug log of head's contents
-		if r != nil {
-			head := r.Descriptor()
-			r.Close()
-			if head.Digest == desc.Digest && head.Size == desc.Size {
-				return head, nil
-			}
+		if head, ok, err := headMatches(ctx, ref, LookupTypeBlob, desc); err != nil {
+			return desc, err
+		} else if ok {
+			return head, nil
 		}
 	}
 
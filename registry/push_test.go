@@ -0,0 +1,149 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	"cuelabs.dev/go/oci/ociregistry/ocimem"
+)
+
+// wraps an [ociregistry.Interface] and counts the (read/write) manifest requests that make it through
+type countingRegistry struct {
+	ociregistry.Interface
+
+	mu    sync.Mutex
+	calls map[string]int // "GetTag repo:tag", "ResolveManifest repo@digest", etc
+}
+
+func (c *countingRegistry) count(call string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls == nil {
+		c.calls = map[string]int{}
+	}
+	c.calls[call]++
+}
+
+func (c *countingRegistry) get(call string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[call]
+}
+
+func (c *countingRegistry) GetTag(ctx context.Context, repo, tag string) (ociregistry.BlobReader, error) {
+	c.count("GetTag " + repo + ":" + tag)
+	return c.Interface.GetTag(ctx, repo, tag)
+}
+
+func (c *countingRegistry) GetManifest(ctx context.Context, repo string, digest ociregistry.Digest) (ociregistry.BlobReader, error) {
+	c.count("GetManifest " + repo + "@" + digest.String())
+	return c.Interface.GetManifest(ctx, repo, digest)
+}
+
+func (c *countingRegistry) ResolveTag(ctx context.Context, repo, tag string) (ociregistry.Descriptor, error) {
+	c.count("ResolveTag " + repo + ":" + tag)
+	return c.Interface.ResolveTag(ctx, repo, tag)
+}
+
+func (c *countingRegistry) PushManifest(ctx context.Context, repo, tag string, contents []byte, mediaType string) (ociregistry.Descriptor, error) {
+	c.count("PushManifest " + repo + ":" + tag)
+	return c.Interface.PushManifest(ctx, repo, tag, contents, mediaType)
+}
+
+func TestCopyManifestFastPath(t *testing.T) {
+	ctx := context.Background()
+
+	srcMem, dstMem := ocimem.New(), ocimem.New()
+	src := &countingRegistry{Interface: srcMem}
+	dst := &countingRegistry{Interface: dstMem}
+
+	manifest, config, layer := testImage(t, srcMem, "test", "latest", "some layer content")
+	// the destination already has the blobs (we're only interested in manifest traffic here)
+	testPushBlob(t, dstMem, "test", config.MediaType, []byte(`{"architecture":"amd64","os":"linux"}`))
+	testPushBlob(t, dstMem, "test", layer.MediaType, []byte("some layer content"))
+
+	srcRef, err := ParseRef("fast-src.invalid/test:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dstRefs []Reference
+	for i := 0; i < 20; i++ {
+		dstRef, err := ParseRef(fmt.Sprintf("fast-dst.invalid/test:tag-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dstRefs = append(dstRefs, dstRef)
+	}
+
+	// each "run" gets fresh clients (and thus a fresh cache), just like a new invocation of "cmd/deploy" would
+	parent := t
+	run := func(t *testing.T) {
+		t.Helper()
+		clientCache.Delete("fast-src.invalid")
+		clientCache.Delete("fast-dst.invalid")
+		testClient(parent, "fast-src.invalid", RegistryCache(src))
+		testClient(parent, "fast-dst.invalid", RegistryCache(dst))
+		for _, dstRef := range dstRefs {
+			desc, err := CopyManifest(ctx, srcRef, dstRef, map[ociregistry.Digest]Reference{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if desc.Digest != manifest.Digest || desc.Size != manifest.Size {
+				t.Fatalf("%s: unexpected descriptor: %+v", dstRef, desc)
+			}
+		}
+	}
+
+	t.Run("first", func(t *testing.T) {
+		run(t)
+		if got := src.get("ResolveTag test:latest"); got != 1 {
+			t.Errorf("expected source tag to be resolved exactly once, got %d", got)
+		}
+		if got := src.get("GetManifest test@" + manifest.Digest.String()); got != 1 {
+			t.Errorf("expected source manifest to be fetched exactly once, got %d", got)
+		}
+		for _, dstRef := range dstRefs {
+			if got := dst.get("PushManifest test:" + dstRef.Tag); got != 1 {
+				t.Errorf("%s: expected exactly one push, got %d", dstRef, got)
+			}
+		}
+	})
+
+	t.Run("up-to-date", func(t *testing.T) {
+		run(t)
+		if got := src.get("ResolveTag test:latest"); got != 2 {
+			t.Errorf("expected source tag to be resolved exactly once more, got %d total", got)
+		}
+		if got := src.get("GetTag test:latest") + src.get("GetManifest test@"+manifest.Digest.String()); got != 1 {
+			t.Errorf("expected no further source manifest fetches, got %d total", got)
+		}
+		for _, dstRef := range dstRefs {
+			if got := dst.get("PushManifest test:" + dstRef.Tag); got != 1 {
+				t.Errorf("%s: expected no further pushes, got %d total", dstRef, got)
+			}
+			if got := dst.get("GetTag test:" + dstRef.Tag); got != 0 {
+				t.Errorf("%s: expected no destination manifest fetches, got %d", dstRef, got)
+			}
+		}
+	})
+
+	t.Run("dry-run", func(t *testing.T) {
+		stale, err := ParseRef("fast-dst.invalid/test:stale")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, upToDate, err := CopyManifestUpToDate(ctx, srcRef, stale); err != nil {
+			t.Fatal(err)
+		} else if upToDate {
+			t.Errorf("%s: expected missing tag to not be up-to-date", stale)
+		}
+		if _, upToDate, err := CopyManifestUpToDate(ctx, srcRef, dstRefs[0]); err != nil {
+			t.Fatal(err)
+		} else if !upToDate {
+			t.Errorf("%s: expected up-to-date", dstRefs[0])
+		}
+	})
+}
@@ -14,7 +14,13 @@ import (
 // parse a string ref like `hello-world:latest` directly into a [Reference] object, with Docker Hub canonicalization applied: `docker.io/library/hello-world:latest`
 //
 // See also [Reference.Normalize] and [ociref.ParseRelative] (which are the underlying implementation details of this method).
+//
+// References to OCI image layout directories on disk are also supported: `oci:/path/to/layout:tag` (see [OCILayoutHost]).
 func ParseRef(img string) (Reference, error) {
+	if path, ok := strings.CutPrefix(img, ociLayoutRefPrefix); ok && (strings.HasPrefix(path, "/") || strings.HasPrefix(path, ".")) {
+		// `oci:/path/to/layout:tag` (see [OCILayoutHost])
+		return parseOCILayoutRef(path)
+	}
 	r, err := ociref.ParseRelative(img)
 	if err != nil {
 		return Reference{}, err
@@ -44,6 +50,11 @@ func (ref *Reference) Normalize() {
 
 // like [ociref.Reference.String], but with Docker Hub "denormalization" applied (no explicit `docker.io` host, no `library/` prefix for DOI)
 func (ref Reference) String() string {
+	if ref.Host == OCILayoutHost {
+		// `oci:/path/to/layout:tag@digest` (see [ParseRef])
+		ref.Host = ""
+		return ociLayoutRefPrefix + ociref.Reference(ref).String()
+	}
 	if ref.Host == dockerHubCanonical {
 		ref.Host = ""
 		ref.Repository = strings.TrimPrefix(ref.Repository, "library/")
@@ -101,3 +101,64 @@ func TestRefStringWithKnownDigest(t *testing.T) {
 		t.Fatalf("expected %q, got %q", "hello-world:latest", got)
 	}
 }
+
+func TestParseRefOCILayout(t *testing.T) {
+	t.Parallel()
+
+	for _, o := range []struct {
+		in   string
+		repo string
+		tag  string
+		dig  string
+		out  string // empty means same as "in"
+	}{
+		{"oci:/path/to/layout", "/path/to/layout", "", "", ""},
+		{"oci:/path/to/layout:tag", "/path/to/layout", "tag", "", ""},
+		{"oci:/path/to/layout@sha256:53641cd209a4fecfc68e21a99871ce8c6920b2e7502df0a20671c6fccc73a7c6", "/path/to/layout", "", "sha256:53641cd209a4fecfc68e21a99871ce8c6920b2e7502df0a20671c6fccc73a7c6", ""},
+		{"oci:/path/to/layout:tag@sha256:53641cd209a4fecfc68e21a99871ce8c6920b2e7502df0a20671c6fccc73a7c6", "/path/to/layout", "tag", "sha256:53641cd209a4fecfc68e21a99871ce8c6920b2e7502df0a20671c6fccc73a7c6", ""},
+		{"oci:/path/to.layout/", "/path/to.layout", "", "", "oci:/path/to.layout"},
+		{"oci:./layout:tag", "./layout", "tag", "", ""},
+		{"oci:../layout", "../layout", "", "", ""},
+		{"oci:.", ".", "", "", ""},
+	} {
+		o := o // https://github.com/golang/go/issues/60078
+		if o.out == "" {
+			o.out = o.in
+		}
+		t.Run(o.in, func(t *testing.T) {
+			ref, err := registry.ParseRef(o.in)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			if ref.Host != registry.OCILayoutHost || ref.Repository != o.repo || ref.Tag != o.tag || string(ref.Digest) != o.dig {
+				t.Fatalf("unexpected parse result: %#v", ref)
+			}
+			if out := ref.String(); out != o.out {
+				t.Fatalf("expected %q, got %q", o.out, out)
+			}
+			var roundtrip registry.Reference
+			fromJson(t, toJson(t, ref), &roundtrip)
+			if roundtrip != ref {
+				t.Fatalf("JSON round-trip mismatch: %#v vs %#v", roundtrip, ref)
+			}
+		})
+	}
+
+	// a registry that happens to be named "oci" (with a port) is *not* a layout
+	ref, err := registry.ParseRef("oci:5000/foo")
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if ref.Host != "oci:5000" || ref.Repository != "foo" {
+		t.Fatalf("unexpected parse result: %#v", ref)
+	}
+
+	for _, bad := range []string{
+		"oci:/path/to/layout:not@valid",
+		"oci:/path/to/layout:-tag",
+	} {
+		if ref, err := registry.ParseRef(bad); err == nil {
+			t.Errorf("%s: expected error, got %#v", bad, ref)
+		}
+	}
+}
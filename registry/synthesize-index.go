@@ -2,7 +2,11 @@ package registry
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/docker-library/bashbrew/architecture"
 
@@ -11,8 +15,18 @@ import (
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// optional behavior for [SynthesizeIndex] (nil is equivalent to the zero value)
+type SynthesizeIndexOptions struct {
+	// also ask the registry for OCI 1.1 referrers of every platform manifest (attestations attached via "subject" instead of BuildKit-style index entries); this costs one or two extra requests per manifest, so it is only worth it for images whose attestations we actually deploy (our own staging images, for example), not for every parent lookup
+	Referrers bool
+}
+
 // returns a synthesized [ocispec.Index] object for the given reference that includes automatically pulling up [ocispec.Platform] objects for entries missing them plus annotations for bashbrew architecture ([AnnotationBashbrewArch]) and where to find the "upstream" object if it needs to be copied/pulled ([ocispec.AnnotationRefName])
-func SynthesizeIndex(ctx context.Context, ref Reference) (*ocispec.Index, error) {
+func SynthesizeIndex(ctx context.Context, ref Reference, opts *SynthesizeIndexOptions) (*ocispec.Index, error) {
+	if opts == nil {
+		opts = &SynthesizeIndexOptions{}
+	}
+
 	client, err := Client(ref.Host, nil)
 	if err != nil {
 		return nil, fmt.Errorf("%s: failed getting client: %w", ref, err)
@@ -74,11 +88,23 @@ func SynthesizeIndex(ctx context.Context, ref Reference) (*ocispec.Index, error)
 
 	setRefAnnotation(&index.Annotations, ref, desc.Digest)
 
+	// if we had to wrap a bare manifest, there is no upstream index to copy
+	unmodified := desc.MediaType == ocispec.MediaTypeImageIndex || desc.MediaType == mediaTypeDockerManifestList
+	var excluded []ExcludedManifest
+
+	// nested indexes are valid OCI, but nothing downstream of us knows what to do with them, so we pull their children up into our index instead (see also [ExcludedReasonNestedIndex])
+	index.Manifests, err = flattenNestedIndexes(ctx, client, ref, index.Manifests, nil, 1, &excluded)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed flattening nested index: %w", ref, err)
+	}
+
+	// (these pointers only ever point into "index.Manifests", which is only ever filtered in-place below, never grown)
 	seen := map[string]*ociregistry.Descriptor{}
 	i := 0 // https://go.dev/wiki/SliceTricks#filter-in-place (used to delete references we don't have the subject of)
 	for _, m := range index.Manifests {
 		if seen[string(m.Digest)] != nil {
 			// skip digests we've already seen (de-dupe), since we have a map already for dropping dangling attestations
+			excluded = append(excluded, ExcludedManifest{Digest: m.Digest, Reason: ExcludedReasonDuplicate})
 			continue
 			// if there was unique data on this lower entry (different annotations, etc), perhaps we should merge/overwrite?  OCI spec technically says "first match SHOULD win", so this is probably fine/sane
 			// https://github.com/opencontainers/image-spec/blob/v1.1.0/image-index.md#:~:text=If%20multiple%20manifests%20match%20a%20client%20or%20runtime%27s%20requirements%2C%20the%20first%20matching%20entry%20SHOULD%20be%20used.
@@ -96,6 +122,7 @@ func SynthesizeIndex(ctx context.Context, ref Reference) (*ocispec.Index, error)
 				m.Annotations[AnnotationBashbrewArch] = subject.Annotations[AnnotationBashbrewArch]
 			} else {
 				// if our subject is missing, delete this entry from the index (see "i")
+				excluded = append(excluded, ExcludedManifest{Digest: m.Digest, Reason: ExcludedReasonMissingSubject})
 				continue
 			}
 		} else if m.Platform != nil {
@@ -120,11 +147,291 @@ func SynthesizeIndex(ctx context.Context, ref Reference) (*ocispec.Index, error)
 	}
 	index.Manifests = index.Manifests[:i] // https://go.dev/wiki/SliceTricks#filter-in-place
 
-	// TODO set an annotation on the index to specify whether or not we actually filtered anything (or whether it's safe to copy the original index as-is during arch-specific deploy instead of reconstructing it from all the parts); maybe a list of digests that were skipped/excluded?
+	// newer builders attach attestations (SBOMs, provenance, etc) via "subject" instead of BuildKit's index entries, so (if asked to) we need to go ask the registry for those too
+	if opts.Referrers {
+		referrers, err := synthesizeReferrers(ctx, client, ref, index.Manifests, seen)
+		if err != nil {
+			return nil, err
+		}
+		if len(referrers) > 0 {
+			index.Manifests = append(index.Manifests, referrers...)
+			unmodified = false
+		}
+	}
+
+	index.Annotations[AnnotationUnmodified] = "false"
+	if unmodified {
+		index.Annotations[AnnotationUnmodified] = "true"
+	}
+	if err := AddExcludedManifests(&index, excluded...); err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+
+	// an upstream index is allowed to contain multiple Windows images for the same architecture (one per os.version), but more than one image for the exact same platform is ambiguous, so we flag it for whoever consumes this index (see also [MultipleImages])
+	if multiple := MultipleImages(&index, true); len(multiple) > 0 {
+		b, err := json.Marshal(multiple)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ref, err)
+		}
+		index.Annotations[AnnotationMultipleImages] = string(b)
+	}
 
 	return &index, nil
 }
 
+// more than one actual (non-attestation) image for a single bashbrew architecture (see [MultipleImages])
+type MultipleImagesError struct {
+	Arch      string               `json:"arch"`
+	OSVersion string               `json:"osVersion,omitempty"` // only set when grouping Windows images by os.version
+	Digests   []ociregistry.Digest `json:"digests"`
+}
+
+func (e MultipleImagesError) Error() string {
+	arch := e.Arch
+	if e.OSVersion != "" {
+		arch += " (os.version " + e.OSVersion + ")"
+	}
+	digests := make([]string, len(e.Digests))
+	for i, d := range e.Digests {
+		digests[i] = string(d)
+	}
+	return fmt.Sprintf("%d images for %s: %s", len(e.Digests), arch, strings.Join(digests, ", "))
+}
+
+// returns every bashbrew architecture ([AnnotationBashbrewArch], as set by [SynthesizeIndex]) that has more than one actual (non-attestation) image in the given index; if "perOSVersion" is set, Windows images are grouped by os.version too (so multiple Windows versions of the same architecture are not a problem, but two images for the same version are)
+func MultipleImages(index *ocispec.Index, perOSVersion bool) []MultipleImagesError {
+	type groupKey struct{ arch, osVersion string }
+	var (
+		ret    []MultipleImagesError
+		groups = map[groupKey]int{} // index into "ret"
+	)
+	for _, m := range index.Manifests {
+		if m.Annotations[annotationBuildkitReferenceType] == annotationBuildkitReferenceTypeAttestation {
+			continue
+		}
+		key := groupKey{arch: m.Annotations[AnnotationBashbrewArch]}
+		if key.arch == "" {
+			// not something we know how to build/use anyhow
+			continue
+		}
+		if perOSVersion && m.Platform != nil && m.Platform.OS == "windows" {
+			key.osVersion = m.Platform.OSVersion
+		}
+		i, ok := groups[key]
+		if !ok {
+			i = len(ret)
+			groups[key] = i
+			ret = append(ret, MultipleImagesError{Arch: key.arch, OSVersion: key.osVersion})
+		}
+		ret[i].Digests = append(ret[i].Digests, m.Digest)
+	}
+	return slices.DeleteFunc(ret, func(e MultipleImagesError) bool {
+		return len(e.Digests) < 2
+	})
+}
+
+// reasons a manifest from the upstream index might not be included in a synthesized index (see [ExcludedManifest])
+const (
+	ExcludedReasonDuplicate      = "duplicate"       // the same digest appeared earlier in the index (and "first match SHOULD win")
+	ExcludedReasonMissingSubject = "missing-subject" // an attestation whose subject is not in the index
+	ExcludedReasonNestedIndex    = "nested-index"    // a nested index, whose children were flattened into the index in its place
+)
+
+// an entry of [AnnotationExcludedManifests]
+type ExcludedManifest struct {
+	Digest ociregistry.Digest `json:"digest"`
+	Reason string             `json:"reason"` // see "ExcludedReason*" constants
+}
+
+// parses the [AnnotationExcludedManifests] annotation of the given (synthesized) index
+func ExcludedManifests(index *ocispec.Index) ([]ExcludedManifest, error) {
+	val, ok := index.Annotations[AnnotationExcludedManifests]
+	if !ok {
+		return nil, nil
+	}
+	var excluded []ExcludedManifest
+	if err := json.Unmarshal([]byte(val), &excluded); err != nil {
+		return nil, fmt.Errorf("failed to parse %q annotation: %w", AnnotationExcludedManifests, err)
+	}
+	return excluded, nil
+}
+
+// records additional excluded manifests in [AnnotationExcludedManifests] (and clears [AnnotationUnmodified], since the index no longer matches upstream); a no-op if "excluded" is empty
+func AddExcludedManifests(index *ocispec.Index, excluded ...ExcludedManifest) error {
+	if len(excluded) == 0 {
+		return nil
+	}
+	prev, err := ExcludedManifests(index)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(append(prev, excluded...))
+	if err != nil {
+		return err
+	}
+	if index.Annotations == nil {
+		index.Annotations = map[string]string{}
+	}
+	index.Annotations[AnnotationExcludedManifests] = string(b)
+	index.Annotations[AnnotationUnmodified] = "false"
+	return nil
+}
+
+// if the given (synthesized) index is an unmodified copy of an upstream index, returns the reference to that upstream index (which can then be copied as-is instead of reconstructing a new index from the parts, ala [CopyManifest])
+func SynthesizedIndexUpstream(index *ocispec.Index) (Reference, bool) {
+	if index == nil || index.Annotations[AnnotationUnmodified] != "true" {
+		return Reference{}, false
+	}
+	ref, err := ParseRef(index.Annotations[ocispec.AnnotationRefName])
+	if err != nil || ref.Digest == "" {
+		return Reference{}, false
+	}
+	return ref, true
+}
+
+// looks up the subject-linked attestations of each platform manifest in "manifests" (only for images we kept, which means attestations of filtered subjects are dropped for free) and returns them in the same form BuildKit uses for its own index entries; "seen" is every digest already in the index, which we skip
+func synthesizeReferrers(ctx context.Context, client ociregistry.Interface, ref Reference, manifests []ocispec.Descriptor, seen map[string]*ociregistry.Descriptor) ([]ocispec.Descriptor, error) {
+	var (
+		referrers     []ocispec.Descriptor
+		seenReferrers = map[ociregistry.Digest]bool{}
+	)
+	for _, m := range manifests {
+		if m.Annotations[AnnotationBashbrewArch] == "" || m.Annotations[annotationBuildkitReferenceType] == annotationBuildkitReferenceTypeAttestation {
+			continue
+		}
+		refs, err := lookupReferrers(ctx, client, ref.Repository, m.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed looking up referrers: %w", m.Annotations[ocispec.AnnotationRefName], err)
+		}
+		for _, r := range refs {
+			if seen[string(r.Digest)] != nil || seenReferrers[r.Digest] {
+				// skip anything we already have (from the index itself or an earlier subject)
+				continue
+			}
+			if r.MediaType != ocispec.MediaTypeImageManifest {
+				// OCI 1.1 allows "subject" on an index, but nothing else we do knows what to do with that
+				continue
+			}
+			if r.ArtifactType == "" {
+				// the referrers API is supposed to include "artifactType", but not every registry does (and the "referrers tag schema" index is only as good as whoever pushed it), so we have to go look
+				if err := readManifestArtifactType(ctx, client, ref.Repository, &r); err != nil {
+					return nil, fmt.Errorf("%s: failed reading referrer %s: %w", m.Annotations[ocispec.AnnotationRefName], r.Digest, err)
+				}
+			}
+			if r.ArtifactType != artifactTypeBuildkitAttestation {
+				// skip anything that isn't actually a BuildKit-shaped attestation manifest (signatures, arbitrary artifacts, etc); relabelling those as attestations would get them deployed as something they are not (and rejected by validation, which expects in-toto layers)
+				continue
+			}
+			setRefAnnotation(&r.Annotations, ref, r.Digest)
+			// we represent these exactly the same way BuildKit does, so everything downstream of us (deploy, arch filtering, etc) treats them the same
+			r.Platform = &ocispec.Platform{OS: "unknown", Architecture: "unknown"}
+			r.Annotations[annotationBuildkitReferenceType] = annotationBuildkitReferenceTypeAttestation
+			r.Annotations[annotationBuildkitReferenceDigest] = string(m.Digest)
+			r.Annotations[AnnotationBashbrewArch] = m.Annotations[AnnotationBashbrewArch]
+			r.Data = nil
+			referrers = append(referrers, r)
+			seenReferrers[r.Digest] = true
+		}
+	}
+	return referrers, nil
+}
+
+// fills in "artifactType" of the given manifest descriptor from the manifest itself (which is what the referrers API is supposed to report, but doesn't always)
+func readManifestArtifactType(ctx context.Context, client ociregistry.Interface, repo string, desc *ocispec.Descriptor) error {
+	r, err := client.GetManifest(ctx, repo, desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var manifest ocispec.Manifest
+	if err := readJSONHelper(r, &manifest); err != nil {
+		return err
+	}
+	desc.ArtifactType = manifest.ArtifactType
+	return nil
+}
+
+// returns the manifests whose "subject" is the given digest, via the OCI 1.1 referrers API (falling back to the "referrers tag schema" for registries that don't support the API, and returning nothing if neither exist)
+//
+// https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#listing-referrers
+func lookupReferrers(ctx context.Context, client ociregistry.Interface, repo string, digest ociregistry.Digest) ([]ocispec.Descriptor, error) {
+	refs, err := ociregistry.All(client.Referrers(ctx, repo, digest, ""))
+	if err == nil {
+		return refs, nil
+	}
+	if !errors.Is(err, ociregistry.ErrUnsupported) && !isNotFound(err) {
+		return nil, err
+	}
+
+	// https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#referrers-tag-schema
+	r, err := client.GetTag(ctx, repo, strings.Replace(string(digest), ":", "-", 1))
+	if err != nil {
+		if errors.Is(err, ociregistry.ErrUnsupported) || isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+	var index ocispec.Index
+	if err := readJSONHelper(r, &index); err != nil {
+		return nil, err
+	}
+	return index.Manifests, nil
+}
+
+// how deeply nested an index is allowed to be before [SynthesizeIndex] gives up (the top-level index is depth 0, so this allows for a few levels of index-in-index, which is plenty for any real-world tooling)
+const maxNestedIndexDepth = 4
+
+// replaces any (nested) index descriptors in "manifests" with their children, recursively (in-place, so the order of the result is still the "first match SHOULD win" order of the original); children without a platform inherit the platform of the nearest index descriptor that has one ("platform"), and since children live in the same repository as the top-level index, [SynthesizeIndex] can give them an [ocispec.AnnotationRefName] just like any other entry
+func flattenNestedIndexes(ctx context.Context, client ociregistry.Interface, ref Reference, manifests []ocispec.Descriptor, platform *ocispec.Platform, depth int, excluded *[]ExcludedManifest) ([]ocispec.Descriptor, error) {
+	var ret []ocispec.Descriptor
+	for _, m := range manifests {
+		if m.Platform == nil && platform != nil {
+			p := *platform // copy (so "normalizeManifestPlatform" doesn't modify the parent's object)
+			m.Platform = &p
+		}
+
+		switch m.MediaType {
+		case ocispec.MediaTypeImageIndex, mediaTypeDockerManifestList:
+			// handled below
+		default:
+			ret = append(ret, m)
+			continue
+		}
+
+		if depth > maxNestedIndexDepth {
+			return nil, fmt.Errorf("%s: nested index depth exceeds %d", m.Digest, maxNestedIndexDepth)
+		}
+
+		var (
+			r   ociregistry.BlobReader
+			err error
+		)
+		if m.Data != nil && int64(len(m.Data)) == m.Size {
+			r = ocimem.NewBytesReader(m.Data, m)
+		} else {
+			r, err = client.GetManifest(ctx, ref.Repository, m.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", m.Digest, err)
+			}
+		}
+		var nested ocispec.Index
+		err = readJSONHelper(r, &nested)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed reading nested index: %w", m.Digest, err)
+		}
+
+		children, err := flattenNestedIndexes(ctx, client, ref, nested.Manifests, m.Platform, depth+1, excluded)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, children...)
+		*excluded = append(*excluded, ExcludedManifest{Digest: m.Digest, Reason: ExcludedReasonNestedIndex})
+	}
+	return ret, nil
+}
+
 // given a (potentially `nil`) map of annotations, add [ocispec.AnnotationRefName] including the supplied [Reference] (but with [Reference.Digest] set to a new value)
 func setRefAnnotation(annotations *map[string]string, ref Reference, digest ociregistry.Digest) {
 	if *annotations == nil {
@@ -161,27 +468,12 @@ func normalizeManifestPlatform(ctx context.Context, m *ocispec.Descriptor, r oci
 				return err
 			}
 
-			switch manifest.Config.MediaType {
-			case ocispec.MediaTypeImageConfig, mediaTypeDockerImageConfig:
-				var r ociregistry.BlobReader
-				if manifest.Config.Data != nil && int64(len(manifest.Config.Data)) == manifest.Config.Size {
-					r = ocimem.NewBytesReader(manifest.Config.Data, manifest.Config)
-				} else {
-					r, err = client.GetBlob(ctx, ref.Repository, manifest.Config.Digest)
-					if err != nil {
-						return err
-					}
-				}
-				defer r.Close()
-
-				var config ocispec.Image
-				if err := readJSONHelper(r, &config); err != nil {
-					return err
-				}
-
-				if config.Platform.OS != "" && config.Platform.Architecture != "" {
-					m.Platform = &config.Platform
-				}
+			config, err := readImageConfig(ctx, client, ref.Repository, manifest.Config)
+			if err != nil {
+				return err
+			}
+			if config != nil && config.Platform.OS != "" && config.Platform.Architecture != "" {
+				m.Platform = &config.Platform
 			}
 		}
 	}
@@ -194,3 +486,33 @@ func normalizeManifestPlatform(ctx context.Context, m *ocispec.Descriptor, r oci
 
 	return nil
 }
+
+// reads (and parses) the given "config" blob of an image manifest, returning nil (and no error) if it isn't actually an image config (an artifact, for example)
+func readImageConfig(ctx context.Context, client ociregistry.Interface, repo string, desc ocispec.Descriptor) (*ocispec.Image, error) {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageConfig, mediaTypeDockerImageConfig:
+		// all good, continue below!
+	default:
+		return nil, nil
+	}
+
+	var (
+		r   ociregistry.BlobReader
+		err error
+	)
+	if desc.Data != nil && int64(len(desc.Data)) == desc.Size {
+		r = ocimem.NewBytesReader(desc.Data, desc)
+	} else {
+		r, err = client.GetBlob(ctx, repo, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer r.Close()
+
+	var config ocispec.Image
+	if err := readJSONHelper(r, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
@@ -0,0 +1,413 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"cuelabs.dev/go/oci/ociregistry"
+	"cuelabs.dev/go/oci/ociregistry/ocimem"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSynthesizeIndexExcluded(t *testing.T) {
+	ctx := context.Background()
+
+	reg := testRegistry(t, "synthesize-excluded.invalid")
+	image, _, _ := testImage(t, reg, "test", "image", "image layer")
+	attestation, _, _ := testImage(t, reg, "test", "", "attestation layer")
+	danglingAttestation, _, _ := testImage(t, reg, "test", "", "dangling attestation layer")
+
+	amd64 := image
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	attestationFor := func(desc ocispec.Descriptor, subject godigest.Digest) ocispec.Descriptor {
+		desc.Platform = &ocispec.Platform{OS: "unknown", Architecture: "unknown"}
+		desc.Annotations = map[string]string{
+			annotationBuildkitReferenceType:   annotationBuildkitReferenceTypeAttestation,
+			annotationBuildkitReferenceDigest: string(subject),
+		}
+		return desc
+	}
+
+	pushIndex := func(tag string, manifests ...ocispec.Descriptor) ocispec.Descriptor {
+		t.Helper()
+		b, err := json.Marshal(ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: manifests,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := reg.PushManifest(ctx, "test", tag, b, ocispec.MediaTypeImageIndex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+
+	synthesize := func(ref string) *ocispec.Index {
+		t.Helper()
+		parsed, err := ParseRef(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index, err := SynthesizeIndex(ctx, parsed, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return index
+	}
+
+	t.Run("unmodified", func(t *testing.T) {
+		desc := pushIndex("unmodified", amd64, attestationFor(attestation, image.Digest))
+		index := synthesize("synthesize-excluded.invalid/test:unmodified")
+		if excluded, err := ExcludedManifests(index); err != nil || excluded != nil {
+			t.Fatalf("unexpected excluded manifests: %v (%v)", excluded, err)
+		}
+		upstream, ok := SynthesizedIndexUpstream(index)
+		if !ok {
+			t.Fatalf("expected unmodified index: %v", index.Annotations)
+		}
+		if upstream.Digest != desc.Digest {
+			t.Fatalf("unexpected upstream: %s", upstream)
+		}
+	})
+
+	t.Run("filtered", func(t *testing.T) {
+		pushIndex("filtered",
+			amd64,
+			amd64, // duplicate
+			attestationFor(attestation, image.Digest),
+			attestationFor(danglingAttestation, godigest.FromString("missing subject")),
+		)
+		index := synthesize("synthesize-excluded.invalid/test:filtered")
+		if len(index.Manifests) != 2 {
+			t.Fatalf("unexpected manifests: %v", index.Manifests)
+		}
+		if _, ok := SynthesizedIndexUpstream(index); ok {
+			t.Fatalf("expected modified index: %v", index.Annotations)
+		}
+		excluded, err := ExcludedManifests(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []ExcludedManifest{
+			{Digest: image.Digest, Reason: ExcludedReasonDuplicate},
+			{Digest: danglingAttestation.Digest, Reason: ExcludedReasonMissingSubject},
+		}
+		if !slices.Equal(excluded, want) {
+			t.Fatalf("unexpected excluded manifests: %v", excluded)
+		}
+
+		// more exclusions get appended
+		if err := AddExcludedManifests(index, ExcludedManifest{Digest: attestation.Digest, Reason: ExcludedReasonNestedIndex}); err != nil {
+			t.Fatal(err)
+		}
+		excluded, err = ExcludedManifests(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(excluded) != 3 || excluded[2].Reason != ExcludedReasonNestedIndex {
+			t.Fatalf("unexpected excluded manifests: %v", excluded)
+		}
+	})
+
+	t.Run("bare manifest", func(t *testing.T) {
+		index := synthesize("synthesize-excluded.invalid/test:image")
+		if _, ok := SynthesizedIndexUpstream(index); ok {
+			t.Fatalf("a synthesized wrapper around a bare manifest cannot be unmodified: %v", index.Annotations)
+		}
+
+		// adding nothing doesn't change anything
+		before := index.Annotations[AnnotationUnmodified]
+		if err := AddExcludedManifests(index); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := index.Annotations[AnnotationExcludedManifests]; ok || index.Annotations[AnnotationUnmodified] != before {
+			t.Fatalf("unexpected annotations: %v", index.Annotations)
+		}
+	})
+}
+
+func TestMultipleImages(t *testing.T) {
+	image := func(digest, arch, os, osVersion string) ocispec.Descriptor {
+		return ocispec.Descriptor{
+			Digest:      godigest.FromString(digest),
+			Platform:    &ocispec.Platform{OS: os, Architecture: "amd64", OSVersion: osVersion},
+			Annotations: map[string]string{AnnotationBashbrewArch: arch},
+		}
+	}
+	attestation := func(digest string, subject ocispec.Descriptor) ocispec.Descriptor {
+		return ocispec.Descriptor{
+			Digest:   godigest.FromString(digest),
+			Platform: &ocispec.Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{
+				AnnotationBashbrewArch:            subject.Annotations[AnnotationBashbrewArch],
+				annotationBuildkitReferenceType:   annotationBuildkitReferenceTypeAttestation,
+				annotationBuildkitReferenceDigest: string(subject.Digest),
+			},
+		}
+	}
+
+	linux := image("linux", "amd64", "linux", "")
+	ltsc2022 := image("ltsc2022", "windows-amd64", "windows", "10.0.20348.1")
+	ltsc2025 := image("ltsc2025", "windows-amd64", "windows", "10.0.26100.1")
+
+	for _, test := range []struct {
+		name         string
+		manifests    []ocispec.Descriptor
+		perOSVersion bool
+		want         []MultipleImagesError
+	}{
+		{
+			name:      "single image plus attestation",
+			manifests: []ocispec.Descriptor{linux, attestation("linux attestation", linux)},
+		},
+		{
+			name:      "two linux images",
+			manifests: []ocispec.Descriptor{linux, attestation("linux attestation", linux), image("linux2", "amd64", "linux", "")},
+			want:      []MultipleImagesError{{Arch: "amd64", Digests: []godigest.Digest{linux.Digest, godigest.FromString("linux2")}}},
+		},
+		{
+			name:         "windows versions (per os.version)",
+			manifests:    []ocispec.Descriptor{ltsc2022, ltsc2025, linux},
+			perOSVersion: true,
+		},
+		{
+			name:      "windows versions",
+			manifests: []ocispec.Descriptor{ltsc2022, ltsc2025, linux},
+			want:      []MultipleImagesError{{Arch: "windows-amd64", Digests: []godigest.Digest{ltsc2022.Digest, ltsc2025.Digest}}},
+		},
+		{
+			name:         "same windows version",
+			manifests:    []ocispec.Descriptor{ltsc2022, image("ltsc2022 again", "windows-amd64", "windows", "10.0.20348.1")},
+			perOSVersion: true,
+			want:         []MultipleImagesError{{Arch: "windows-amd64", OSVersion: "10.0.20348.1", Digests: []godigest.Digest{ltsc2022.Digest, godigest.FromString("ltsc2022 again")}}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := MultipleImages(&ocispec.Index{Manifests: test.manifests}, test.perOSVersion)
+			if !slices.EqualFunc(got, test.want, func(a, b MultipleImagesError) bool {
+				return a.Arch == b.Arch && a.OSVersion == b.OSVersion && slices.Equal(a.Digests, b.Digests)
+			}) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+
+	if got, want := (MultipleImagesError{Arch: "windows-amd64", OSVersion: "10.0.20348.1", Digests: []godigest.Digest{"sha256:a", "sha256:b"}}).Error(), "2 images for windows-amd64 (os.version 10.0.20348.1): sha256:a, sha256:b"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestSynthesizeIndexNested(t *testing.T) {
+	ctx := context.Background()
+
+	reg := testRegistry(t, "synthesize-nested.invalid")
+	image, _, _ := testImage(t, reg, "test", "", "image layer")
+	attestation, _, _ := testImage(t, reg, "test", "", "attestation layer")
+	other, _, _ := testImage(t, reg, "test", "", "other layer")
+	attestation.Platform = &ocispec.Platform{OS: "unknown", Architecture: "unknown"}
+	attestation.Annotations = map[string]string{
+		annotationBuildkitReferenceType:   annotationBuildkitReferenceTypeAttestation,
+		annotationBuildkitReferenceDigest: string(image.Digest),
+	}
+	other.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+
+	pushIndex := func(tag string, manifests ...ocispec.Descriptor) ocispec.Descriptor {
+		t.Helper()
+		b, err := json.Marshal(ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: manifests,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := reg.PushManifest(ctx, "test", tag, b, ocispec.MediaTypeImageIndex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+
+	// the image itself has no platform (and its config says amd64), so it should inherit the platform of the nested index (two levels up)
+	inner := pushIndex("", image, attestation)
+	inner.Annotations = map[string]string{ocispec.AnnotationRefName: "inner"}
+	outer := pushIndex("", inner)
+	outer.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	pushIndex("nested", outer, other)
+
+	ref, err := ParseRef("synthesize-nested.invalid/test:nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := SynthesizeIndex(ctx, ref, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		digest godigest.Digest
+		arch   string
+	}{
+		{image.Digest, "arm64v8"},
+		{attestation.Digest, "arm64v8"},
+		{other.Digest, "amd64"},
+	}
+	if len(index.Manifests) != len(want) {
+		t.Fatalf("expected %d manifests, got %d: %v", len(want), len(index.Manifests), index.Manifests)
+	}
+	for i, w := range want {
+		m := index.Manifests[i]
+		if m.Digest != w.digest || m.Annotations[AnnotationBashbrewArch] != w.arch {
+			t.Errorf("manifests[%d]: expected %s (%s), got %s (%s)", i, w.digest, w.arch, m.Digest, m.Annotations[AnnotationBashbrewArch])
+		}
+		if refName := "synthesize-nested.invalid/test:nested@" + string(w.digest); m.Annotations[ocispec.AnnotationRefName] != refName {
+			t.Errorf("manifests[%d]: expected ref name %q, got %q", i, refName, m.Annotations[ocispec.AnnotationRefName])
+		}
+	}
+
+	if _, ok := SynthesizedIndexUpstream(index); ok {
+		t.Fatalf("a flattened index cannot be unmodified: %v", index.Annotations)
+	}
+	excluded, err := ExcludedManifests(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantExcluded := []ExcludedManifest{
+		{Digest: inner.Digest, Reason: ExcludedReasonNestedIndex},
+		{Digest: outer.Digest, Reason: ExcludedReasonNestedIndex},
+	}; !slices.Equal(excluded, wantExcluded) {
+		t.Fatalf("unexpected excluded manifests: %v", excluded)
+	}
+
+	t.Run("too deep", func(t *testing.T) {
+		desc := image
+		for i := 0; i <= maxNestedIndexDepth; i++ {
+			desc = pushIndex("", desc)
+		}
+		pushIndex("too-deep", desc)
+		ref, err := ParseRef("synthesize-nested.invalid/test:too-deep")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := SynthesizeIndex(ctx, ref, nil); err == nil {
+			t.Fatal("expected error for too deeply nested index")
+		}
+	})
+}
+
+// a registry without support for the referrers API (so we have to use the "referrers tag schema" fallback instead)
+type testNoReferrers struct {
+	ociregistry.Interface
+}
+
+func (testNoReferrers) Referrers(ctx context.Context, repo string, digest ociregistry.Digest, artifactType string) ociregistry.Seq[ociregistry.Descriptor] {
+	return ociregistry.ErrorSeq[ociregistry.Descriptor](ociregistry.ErrUnsupported)
+}
+
+func TestSynthesizeIndexReferrers(t *testing.T) {
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		name     string
+		host     string
+		fallback bool
+	}{
+		{"referrers API", "synthesize-referrers.invalid", false},
+		{"referrers tag schema", "synthesize-referrers-fallback.invalid", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			reg := ocimem.New()
+			if test.fallback {
+				testClient(t, test.host, testNoReferrers{reg})
+			} else {
+				testClient(t, test.host, reg)
+			}
+
+			image, _, _ := testImage(t, reg, "test", "image", "image layer")
+			other, _, _ := testImage(t, reg, "test", "", "other image layer")
+
+			artifact := func(subject ocispec.Descriptor, artifactType, layerType, content string) ocispec.Descriptor {
+				t.Helper()
+				config := testPushBlob(t, reg, "test", ocispec.MediaTypeEmptyJSON, []byte(`{}`))
+				layer := testPushBlob(t, reg, "test", layerType, []byte(content))
+				desc := testPushManifest(t, reg, "test", "", ocispec.Manifest{
+					Versioned:    specs.Versioned{SchemaVersion: 2},
+					MediaType:    ocispec.MediaTypeImageManifest,
+					ArtifactType: artifactType,
+					Config:       config,
+					Layers:       []ocispec.Descriptor{layer},
+					Subject:      &subject,
+				})
+				desc.ArtifactType = artifactType
+				return desc
+			}
+			sbom := artifact(image, artifactTypeBuildkitAttestation, "application/vnd.in-toto+json", "image sbom")
+			signature := artifact(image, "application/vnd.dev.cosign.artifact.sig.v1+json", "application/vnd.dev.cosign.simplesigning.v1+json", "image signature") // not an attestation, so it should never show up either
+			artifact(other, artifactTypeBuildkitAttestation, "application/vnd.in-toto+json", "other sbom")                                                         // the subject of this one is not part of our index, so it should never show up
+
+			if test.fallback {
+				b, err := json.Marshal(ocispec.Index{
+					Versioned: specs.Versioned{SchemaVersion: 2},
+					MediaType: ocispec.MediaTypeImageIndex,
+					Manifests: []ocispec.Descriptor{sbom, signature},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := reg.PushManifest(ctx, "test", strings.Replace(string(image.Digest), ":", "-", 1), b, ocispec.MediaTypeImageIndex); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ref, err := ParseRef(test.host + "/test:image")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// referrers are opt-in (they cost extra requests per image)
+			index, err := SynthesizeIndex(ctx, ref, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(index.Manifests) != 1 || index.Annotations[AnnotationUnmodified] != "false" {
+				t.Fatalf("expected just the image, got %v", index.Manifests)
+			}
+
+			index, err = SynthesizeIndex(ctx, ref, &SynthesizeIndexOptions{Referrers: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(index.Manifests) != 2 {
+				t.Fatalf("expected image + sbom, got %v", index.Manifests)
+			}
+			if m := index.Manifests[0]; m.Digest != image.Digest || m.Annotations[AnnotationBashbrewArch] != "amd64" {
+				t.Fatalf("unexpected image: %v", m)
+			}
+			m := index.Manifests[1]
+			if m.Digest != sbom.Digest {
+				t.Fatalf("unexpected sbom: %v", m)
+			}
+			for k, v := range map[string]string{
+				AnnotationBashbrewArch:            "amd64",
+				annotationBuildkitReferenceType:   annotationBuildkitReferenceTypeAttestation,
+				annotationBuildkitReferenceDigest: string(image.Digest),
+				ocispec.AnnotationRefName:         test.host + "/test:image@" + string(sbom.Digest),
+			} {
+				if m.Annotations[k] != v {
+					t.Errorf("expected %s=%q, got %q", k, v, m.Annotations[k])
+				}
+			}
+			if m.Platform == nil || m.Platform.OS != "unknown" || m.Platform.Architecture != "unknown" {
+				t.Errorf("expected unknown/unknown platform, got %v", m.Platform)
+			}
+		})
+	}
+}